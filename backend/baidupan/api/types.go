@@ -23,12 +23,49 @@ const (
 	PathPrecreate    = "/rest/2.0/xpan/file"
 	PathUpload       = "/rest/2.0/pcs/superfile2"
 	PathCreate       = "/rest/2.0/xpan/file"
+
+	// Recycle bin paths - these aren't part of the xpan open API
+	PathRecycleList    = "/api/recycle/list"
+	PathRecycleRestore = "/api/recycle/restore"
+	PathRecycleClear   = "/api/recycle/clear"
+
+	// Share paths - these aren't part of the xpan open API
+	PathShareRecord   = "/share/record"
+	PathShareCancel   = "/share/cancel"
+	PathShareVerify   = "/share/verify"
+	PathShareList     = "/share/list"
+	PathShareTransfer = "/share/transfer"
+
+	// Offline download (cloud_dl) - not part of the xpan open API
+	PathOffline = "/rest/2.0/services/cloud_dl"
 	
 	// File types
 	FileTypeFile   = 0
 	FileTypeFolder = 1
 )
 
+// File categories
+const (
+	CategoryVideo       = 1
+	CategoryAudio       = 2
+	CategoryImage       = 3
+	CategoryDocument    = 4
+	CategoryApplication = 5
+	CategoryOther       = 6
+	CategoryTorrent     = 7
+)
+
+// CategoryNames maps file categories to their names
+var CategoryNames = map[int]string{
+	CategoryVideo:       "video",
+	CategoryAudio:       "audio",
+	CategoryImage:       "image",
+	CategoryDocument:    "document",
+	CategoryApplication: "application",
+	CategoryOther:       "other",
+	CategoryTorrent:     "torrent",
+}
+
 // Error represents an API error response
 type Error struct {
 	ErrorCode int         `json:"errno"`
@@ -118,6 +155,20 @@ type FileMeta struct {
 	Category     int    `json:"category"`
 	MD5          string `json:"md5"`
 	Dlink        string `json:"dlink"`  // download link
+
+	// Only returned when asked for with thumb=1, extra=1 and needmedia=1
+	Thumbs    map[string]string `json:"thumbs"`     // url1, url2, url3 from small to large
+	Width     int               `json:"width"`      // images
+	Height    int               `json:"height"`     // images
+	DateTaken int64             `json:"date_taken"` // images
+	MediaInfo *MediaInfo        `json:"media_info"` // audio and video
+}
+
+// MediaInfo describes an audio or video file
+type MediaInfo struct {
+	Duration int64 `json:"duration"` // seconds
+	Width    int   `json:"width"`
+	Height   int   `json:"height"`
 }
 
 // CreateDirResponse represents the response from create directory API
@@ -211,6 +262,150 @@ type CreateFileResponse struct {
 	Isdir        int    `json:"isdir"`
 }
 
+// RecycleListResponse represents the response from the recycle bin list API
+type RecycleListResponse struct {
+	Error
+	List []RecycleItem `json:"list"`
+}
+
+// RecycleItem represents a file or directory in the recycle bin
+type RecycleItem struct {
+	FsID           int64  `json:"fs_id"`
+	Path           string `json:"path"`
+	ServerFilename string `json:"server_filename"`
+	Size           int64  `json:"size"`
+	Isdir          int    `json:"isdir"`
+	MD5            string `json:"md5,omitempty"`
+	ServerMtime    int64  `json:"server_mtime"`
+	LeftTime       int64  `json:"leftTime"` // seconds until it is deleted
+}
+
+// Offline download task status values
+const (
+	OfflineStatusSuccess      = "0"
+	OfflineStatusRunning      = "1"
+	OfflineStatusSystemError  = "2"
+	OfflineStatusNotFound     = "3"
+	OfflineStatusTimeout      = "4"
+	OfflineStatusFailed       = "5"
+	OfflineStatusNoSpace      = "6"
+	OfflineStatusTargetExists = "7"
+	OfflineStatusCancelled    = "8"
+)
+
+// OfflineError is the error returned by the cloud_dl API which
+// doesn't use errno like the rest of the API
+type OfflineError struct {
+	ErrorCode int         `json:"error_code"`
+	ErrorMsg  string      `json:"error_msg"`
+	RequestID interface{} `json:"request_id"`
+}
+
+// Error implements the error interface
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("baidu pan offline download error %d: %s (request_id: %v)", e.ErrorCode, e.ErrorMsg, e.RequestID)
+}
+
+// OfflineAddResponse is returned when adding an offline download task
+type OfflineAddResponse struct {
+	OfflineError
+	TaskID        int64 `json:"task_id"`
+	RapidDownload int   `json:"rapid_download"` // 1 if the file was already known
+}
+
+// OfflineMagnetResponse describes the files in a magnet link
+type OfflineMagnetResponse struct {
+	OfflineError
+	MagnetInfo []OfflineMagnetFile `json:"magnet_info"`
+	Total      int                 `json:"total"`
+}
+
+// OfflineMagnetFile is a file in a magnet link
+type OfflineMagnetFile struct {
+	FileName string `json:"file_name"`
+	Size     string `json:"size"`
+}
+
+// OfflineTask describes an offline download task
+//
+// cloud_dl returns most numbers as strings.
+type OfflineTask struct {
+	TaskID       string `json:"task_id"`
+	TaskName     string `json:"task_name"`
+	Status       string `json:"status"`
+	FileSize     string `json:"file_size"`
+	FinishedSize string `json:"finished_size"`
+	SavePath     string `json:"save_path"`
+	SourceURL    string `json:"source_url"`
+	CreateTime   string `json:"create_time"`
+	StartTime    string `json:"start_time"`
+	FinishTime   string `json:"finish_time"`
+}
+
+// OfflineQueryResponse is returned by query_task
+type OfflineQueryResponse struct {
+	OfflineError
+	TaskInfo map[string]OfflineTask `json:"task_info"`
+}
+
+// OfflineListResponse is returned by list_task
+type OfflineListResponse struct {
+	OfflineError
+	TaskInfo []OfflineTask `json:"task_info"`
+	Total    int           `json:"total"`
+}
+
+// ShareSetResponse is returned when creating a share link
+type ShareSetResponse struct {
+	Error
+	ShareID int64  `json:"shareid"`
+	Link    string `json:"link"`
+}
+
+// ShareRecordResponse lists the user's own share links
+type ShareRecordResponse struct {
+	Error
+	List  []ShareRecord `json:"list"`
+	Count int           `json:"count"`
+}
+
+// ShareRecord describes one of the user's own share links
+type ShareRecord struct {
+	ShareID     int64   `json:"shareId"`
+	ShortURL    string  `json:"shorturl"`
+	TypicalPath string  `json:"typicalPath"` // path of the first item shared
+	Passwd      string  `json:"passwd"`
+	FsIDs       []int64 `json:"fsIds"`
+	Ctime       int64   `json:"ctime"`
+	ExpiredTime int64   `json:"expiredTime"` // 0 if the link is permanent
+	Status      int     `json:"status"`
+}
+
+// ShareVerifyResponse is returned when checking a share's extraction code
+type ShareVerifyResponse struct {
+	Error
+	Randsk string `json:"randsk"` // key to access the share with
+}
+
+// ShareListResponse lists a directory in someone else's share
+type ShareListResponse struct {
+	Error
+	ShareID int64       `json:"share_id"`
+	UK      int64       `json:"uk"` // user id of the sharer
+	List    []ShareFile `json:"list"`
+}
+
+// ShareFile is a file or directory in someone else's share
+type ShareFile struct {
+	FsID           int64  `json:"fs_id"`
+	Path           string `json:"path"` // path in the sharer's netdisk
+	ServerFilename string `json:"server_filename"`
+	Size           int64  `json:"size"`
+	Isdir          int    `json:"isdir"`
+	MD5            string `json:"md5,omitempty"`
+	ServerMtime    int64  `json:"server_mtime"`
+}
+
 // DeviceCodeResponse represents the response from device code API
 type DeviceCodeResponse struct {
 	DeviceCode      string `json:"device_code"`
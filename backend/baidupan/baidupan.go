@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/backend/baidupan/api"
@@ -21,10 +24,13 @@ import (
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/list"
+	"github.com/rclone/rclone/lib/dircache"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/oauthutil"
 	"github.com/rclone/rclone/lib/pacer"
 	"github.com/rclone/rclone/lib/rest"
+	"golang.org/x/oauth2"
 	xpansdk "open-sdk-go/openxpanapi"
 )
 
@@ -34,6 +40,21 @@ const (
 	decayConstant    = 2
 	defaultChunkSize = 4 * 1024 * 1024 // 4MB - required by Baidu Pan
 	maxChunkSize     = 4 * 1024 * 1024 // 4MB - fixed by Baidu Pan
+	cachePrefix      = "rclone-baidupan-"
+	// MD5 of no data - used as the block MD5 of an empty file
+	emptyMD5 = "d41d8cd98f00b204e9800998ecf8427e"
+	// block MD5 passed to precreate when the real ones aren't known yet
+	placeholderBlockMD5 = "5910a591dd8fc18c32a8f3df4fdc1761"
+	// download links are valid for 8 hours - refresh them a bit early
+	dlinkCacheTime = 7 * time.Hour
+	// maximum number of items listall returns per page
+	listAllLimit = 1000
+	// maximum number of items list returns per page
+	listLimit = 1000
+	// directory ID the dircache uses for "/" which has no fs_id
+	rootID = "0"
+	// number of items to fetch per page of the recycle bin
+	recycleListLimit = 1000
 )
 
 // Register with Fs
@@ -43,6 +64,11 @@ func init() {
 		Description: "Baidu Pan (Baidu Netdisk)",
 		NewFs:       NewFs,
 		Config:      Config,
+		CommandHelp: commandHelp,
+		MetadataInfo: &fs.MetadataInfo{
+			System: systemMetadataInfo,
+			Help:   metadataHelp,
+		},
 		Options: []fs.Option{{
 			Name:      "client_id",
 			Help:      "Baidu Pan App Key.\n\nLeave blank to use rclone's.",
@@ -72,6 +98,78 @@ func init() {
 			Help:     "Upload chunk size. Must be 4MB (fixed by Baidu Pan).",
 			Default:  defaultChunkSize,
 			Advanced: true,
+		}, {
+			Name: "hash_memory_limit",
+			Help: `Files bigger than this will be spooled to disk to calculate the block MD5s.
+
+Baidu Pan needs the MD5 of every 4 MiB block before an upload can
+start, so the upload has to be read twice. Files up to this size are
+held in memory, larger files are written to a temporary file in the
+system temp directory.`,
+			Default:  fs.SizeSuffix(16 * 1024 * 1024),
+			Advanced: true,
+		}, {
+			Name: "resume_uploads",
+			Help: `Save the state of uploads so interrupted uploads can be resumed.
+
+If set, the upload ID and the blocks uploaded so far are saved in the
+rclone cache directory. A later upload of the same content to the same
+path will then only send the blocks which are missing.
+
+Sessions older than 24 hours are discarded.`,
+			Default:  true,
+			Advanced: true,
+		}, {
+			Name: "upload_concurrency",
+			Help: `Concurrency for multipart uploads.
+
+This is the number of 4 MiB blocks of the same file that are uploaded
+concurrently. It is also used by multi thread copies to this remote.
+
+If you are uploading small numbers of large files over high-speed links
+and these uploads do not fully utilize your bandwidth, then increasing
+this may help to speed up the transfers.`,
+			Default:  4,
+			Advanced: true,
+		}, {
+			Name: "persist_dir_cache",
+			Help: `Keep the cache of paths to fs_ids on disk between runs.
+
+The backend remembers the fs_id of every file and directory it sees so
+that directories don't need to be looked up again and objects can be
+found with a single filemetas call rather than by listing their parent
+directory.
+
+If this is set the cache is stored in the rclone cache directory so it
+survives restarts. Directories read from it are checked with filemetas
+the first time they are used in case other clients have changed them.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "recycle_bin_api",
+			Help: `Use Baidu's undocumented recycle bin API.
+
+The recycle bin isn't part of the Baidu Pan open platform API, so
+emptying it with "rclone cleanup", the recycled and restore backend
+commands and noticing deleted files in "rclone mount" use the API of
+the Baidu web client. This may stop working or be refused for your
+app at any time.
+
+Note that the recycle bin belongs to the whole account, so
+"rclone cleanup" empties all of it, not just the items deleted from
+under the remote's root.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "offline_app_id",
+			Help: `App ID to use for offline downloads.
+
+Offline downloads (the addurl and offline-status backend commands) use
+the cloud_dl API, which isn't part of the Baidu Pan open platform API
+and needs the app_id of an app Baidu has allowed to use it.
+
+Offline downloads are disabled unless this is set.`,
+			Advanced: true,
 		}, {
 			Name:     config.ConfigEncoding,
 			Help:     config.ConfigEncodingHelp,
@@ -88,11 +186,17 @@ func init() {
 
 // Options defines the configuration for this backend
 type Options struct {
-	ClientID     string               `config:"client_id"`
-	ClientSecret string               `config:"client_secret"`
-	AuthFlow     string               `config:"auth_flow"`
-	ChunkSize    fs.SizeSuffix        `config:"chunk_size"`
-	Enc          encoder.MultiEncoder `config:"encoding"`
+	ClientID          string               `config:"client_id"`
+	ClientSecret      string               `config:"client_secret"`
+	AuthFlow          string               `config:"auth_flow"`
+	ChunkSize         fs.SizeSuffix        `config:"chunk_size"`
+	HashMemoryLimit   fs.SizeSuffix        `config:"hash_memory_limit"`
+	ResumeUploads     bool                 `config:"resume_uploads"`
+	UploadConcurrency int                  `config:"upload_concurrency"`
+	PersistDirCache   bool                 `config:"persist_dir_cache"`
+	RecycleBinAPI     bool                 `config:"recycle_bin_api"`
+	OfflineAppID      string               `config:"offline_app_id"`
+	Enc               encoder.MultiEncoder `config:"encoding"`
 }
 
 // Fs represents a remote Baidu Pan
@@ -104,8 +208,10 @@ type Fs struct {
 	srv         *rest.Client
 	sdk         *xpansdk.APIClient
 	pacer       *fs.Pacer
-	dirCache    *dirCache
-	tokenSource *oauthutil.TokenSource
+	dirCache    *dircache.DirCache // map of directory path to fs_id
+	ids         *idCache           // map of absolute path to fs_id
+	virtual     *virtualRoot       // set if the root is {category} or {search}
+	tokenSource oauth2.TokenSource
 	m           configmap.Mapper
 }
 
@@ -117,12 +223,10 @@ type Object struct {
 	modTime time.Time
 	md5     string
 	fsid    int64
-}
 
-// dirCache caches directory paths to their fsids
-type dirCache struct {
-	root  string
-	cache map[string]int64
+	dlinkMu     sync.Mutex // protects the download link cache
+	dlink       string     // cached download link
+	dlinkExpiry time.Time  // when to stop using dlink
 }
 
 // Config is called when a new Fs is being configured
@@ -213,6 +317,10 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	}
 
 	root = strings.Trim(root, "/")
+	virtual, err := parseVirtualRoot(root)
+	if err != nil {
+		return nil, err
+	}
 
 	// Prepare OAuth config
 	clientSecret := opt.ClientSecret
@@ -248,11 +356,13 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		pacer:       fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
 		tokenSource: ts,
 		m:           m,
-		dirCache: &dirCache{
-			root:  root,
-			cache: make(map[string]int64),
-		},
+		virtual:     virtual,
+	}
+	f.ids, err = getIDCache(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open directory cache: %w", err)
 	}
+	f.dirCache = dircache.New(root, rootID, f)
 
 	f.features = (&fs.Features{
 		CaseInsensitive:         false,
@@ -263,11 +373,21 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		Copy:                    f.Copy,
 		About:                   f.About,
 		PublicLink:              f.PublicLink,
+		ReadMetadata:            true,
 	}).Fill(ctx, f)
+	if f.virtual != nil {
+		for _, feature := range []string{"Move", "Copy", "DirMove", "Purge", "CleanUp", "PutStream", "OpenChunkWriter", "PublicLink", "ListR", "ChangeNotify"} {
+			f.features.Disable(feature)
+		}
+	}
+	if !f.opt.RecycleBinAPI {
+		f.features.Disable("CleanUp")
+	}
 
 	// Check connection by getting user info
 	_, err = f.getUserInfo(ctx)
 	if err != nil {
+		_ = f.ids.close()
 		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 
@@ -509,64 +629,168 @@ func (f *Fs) makePath(remote string) string {
 	return "/" + path.Join(f.root, remote)
 }
 
+// remoteFromPath converts an absolute Baidu Pan path into a remote
+// relative to the root, returning false if it is outside the root
+func (f *Fs) remoteFromPath(p string) (remote string, ok bool) {
+	rootPath := strings.TrimSuffix(f.makePath(""), "/") + "/"
+	if !strings.HasPrefix(p, rootPath) {
+		return "", false
+	}
+	return f.opt.Enc.ToStandardPath(p[len(rootPath):]), true
+}
+
 // dirPath returns the directory path (without trailing slash)
 func (f *Fs) dirPath(remote string) string {
 	return path.Dir(f.makePath(remote))
 }
 
-// List the objects and directories in dir into entries
-func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+// listPath lists the absolute directory dirPath, reading all the pages
+//
+// The items found are added to the fs_id cache.
+func (f *Fs) listPath(ctx context.Context, dirPath string) (items []api.FileItem, err error) {
 	accessToken, err := f.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	dirPath := f.makePath(dir)
+	for start := 0; ; start += listLimit {
+		var result api.FileListResponse
+		err = f.callJSON(ctx, func() (string, *http.Response, error) {
+			return f.sdk.FileinfoApi.Xpanfilelist(ctx).
+				AccessToken(accessToken).
+				Dir(dirPath).
+				Folder("0").
+				Web("1").
+				Start(strconv.Itoa(start)).
+				Limit(listLimit).
+				Execute()
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
 
-	var result api.FileListResponse
-	err = f.callJSON(ctx, func() (string, *http.Response, error) {
-		return f.sdk.FileinfoApi.Xpanfilelist(ctx).
-			AccessToken(accessToken).
-			Dir(dirPath).
-			Folder("0").
-			Web("1").
-			Execute()
-	}, &result)
-	if err != nil {
-		return nil, err
-	}
+		if result.ErrorCode != 0 {
+			if result.ErrorCode == -9 {
+				return nil, fs.ErrorDirNotFound
+			}
+			return nil, &result.Error
+		}
 
-	if result.ErrorCode != 0 {
-		if result.ErrorCode == -9 {
-			return nil, fs.ErrorDirNotFound
+		recs := make(map[string]idRecord, len(result.List))
+		for _, item := range result.List {
+			recs[item.Path] = idRecord{FsID: item.FsID, IsDir: item.IsDir()}
 		}
-		return nil, &result.Error
+		f.ids.putAll(recs)
+		items = append(items, result.List...)
+		if len(result.List) < listLimit {
+			break
+		}
+	}
+	return items, nil
+}
+
+// List the objects and directories in dir into entries
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if f.virtual != nil {
+		return f.virtual.list(ctx, f, dir)
+	}
+	items, err := f.listPath(ctx, f.makePath(dir))
+	if err != nil {
+		return nil, err
 	}
 
-	for _, item := range result.List {
+	for _, item := range items {
 		remote := f.opt.Enc.ToStandardPath(path.Base(item.Path))
 		if dir != "" {
 			remote = path.Join(dir, remote)
 		}
+		entries = append(entries, f.itemToDirEntry(remote, &item))
+	}
 
-		if item.IsDir() {
-			d := fs.NewDir(remote, item.ModTime())
-			d.SetID(fmt.Sprintf("%d", item.FsID))
-			entries = append(entries, d)
-		} else {
-			o := &Object{
-				fs:      f,
-				remote:  remote,
-				size:    item.Size,
-				modTime: item.ModTime(),
-				md5:     item.MD5,
-				fsid:    item.FsID,
+	return entries, nil
+}
+
+// itemToDirEntry converts a FileItem into an fs.Directory or *Object
+func (f *Fs) itemToDirEntry(remote string, item *api.FileItem) fs.DirEntry {
+	if item.IsDir() {
+		d := fs.NewDir(remote, item.ModTime())
+		d.SetID(fmt.Sprintf("%d", item.FsID))
+		return d
+	}
+	o := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	o.setMetaData(item)
+	return o
+}
+
+// ListR lists the objects and directories of the Fs starting
+// from dir recursively into out.
+//
+// dir should be "" to start from the root, and should not
+// have trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+//
+// It should call callback for each tranche of entries read.
+// These need not be returned in any particular order.  If
+// callback returns an error then the listing will stop
+// immediately.
+//
+// This uses the listall API which pages through the whole tree
+// rather than listing each directory separately.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	dirPath := f.makePath(dir)
+	list := list.NewHelper(callback)
+
+	start := 0
+	for {
+		var result api.FileListResponse
+		err = f.callJSON(ctx, func() (string, *http.Response, error) {
+			return f.sdk.MultimediafileApi.Xpanfilelistall(ctx).
+				AccessToken(accessToken).
+				Path(dirPath).
+				Recursion(1).
+				Web("1").
+				Start(int32(start)).
+				Limit(listAllLimit).
+				Execute()
+		}, &result)
+		if err != nil {
+			return err
+		}
+		if result.ErrorCode != 0 {
+			if result.ErrorCode == -9 {
+				return fs.ErrorDirNotFound
 			}
-			entries = append(entries, o)
+			return &result.Error
 		}
+
+		for _, item := range result.List {
+			remote, ok := f.remoteFromPath(item.Path)
+			if !ok {
+				fs.Debugf(f, "ListR: ignoring %q outside root", item.Path)
+				continue
+			}
+			if err = list.Add(f.itemToDirEntry(remote, &item)); err != nil {
+				return err
+			}
+		}
+
+		if result.HasMore == 0 || len(result.List) == 0 {
+			break
+		}
+		start = int(result.Cursor)
 	}
 
-	return entries, nil
+	return list.Flush()
 }
 
 // NewObject finds the Object at remote
@@ -601,8 +825,39 @@ func (o *Object) setMetaData(info *api.FileItem) {
 	o.fsid = info.FsID
 }
 
-// readMetaData reads metadata for an object by listing its parent directory
+// readMetaData reads metadata for an object
+//
+// If the root is virtual the object is looked up in the virtual tree.
+// Otherwise if the fs_id of the object is cached it is read with
+// filemetas, or failing that by listing its parent directory.
 func (o *Object) readMetaData(ctx context.Context) error {
+	if o.fs.virtual != nil {
+		info, err := o.fs.virtual.find(ctx, o.fs, o.remote)
+		if err != nil {
+			return err
+		}
+		o.setMetaData(info)
+		return nil
+	}
+	filePath := o.fs.makePath(o.remote)
+	if rec, ok := o.fs.ids.get(filePath); ok {
+		if rec.IsDir {
+			return fs.ErrorIsDir
+		}
+		o.fsid = rec.FsID
+		meta, err := o.getFileMeta(ctx, false)
+		if err == nil && meta.Path == filePath && meta.Isdir == api.FileTypeFile {
+			o.size = meta.Size
+			o.md5 = meta.MD5
+			o.modTime = time.Unix(meta.ServerMtime, 0)
+			fs.Debugf(o, "readMetaData: found from cached fs_id %d", o.fsid)
+			return nil
+		}
+		fs.Debugf(o, "readMetaData: cached fs_id %d is stale: %v", o.fsid, err)
+		o.fs.ids.remove(filePath)
+		o.fsid = 0
+	}
+
 	dir := path.Dir(o.remote)
 	if dir == "." {
 		dir = ""
@@ -634,7 +889,7 @@ func (o *Object) readMetaData(ctx context.Context) error {
 
 				// If size is 0, fetch complete metadata using filemetas API
 				if o.size == 0 && o.fsid != 0 {
-					meta, err := o.getFileMetaWithDlink(ctx)
+					meta, err := o.getFileMeta(ctx, true)
 					if err == nil {
 						o.size = meta.Size
 						o.md5 = meta.MD5
@@ -657,6 +912,9 @@ func (o *Object) readMetaData(ctx context.Context) error {
 
 // Put uploads a file
 func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if f.virtual != nil {
+		return nil, errorReadOnly
+	}
 	remote := src.Remote()
 	size := src.Size()
 
@@ -684,18 +942,96 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 		md5:     result.MD5,
 		fsid:    result.FsID,
 	}
+	f.ids.put(f.makePath(remote), idRecord{FsID: result.FsID})
 
 	return o, nil
 }
 
 // Mkdir makes a directory
 func (f *Fs) Mkdir(ctx context.Context, dir string) error {
-	accessToken, err := f.getAccessToken(ctx)
+	if f.virtual != nil {
+		return errorReadOnly
+	}
+	_, err := f.dirCache.FindDir(ctx, dir, true)
+	return err
+}
+
+// idPath returns the absolute path of the directory with the given
+// dircache ID
+func (f *Fs) idPath(pathID string) (string, error) {
+	if pathID == rootID {
+		return "/", nil
+	}
+	fsid, err := strconv.ParseInt(pathID, 10, 64)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("invalid directory ID %q: %w", pathID, err)
+	}
+	dirPath, ok := f.ids.pathOf(fsid)
+	if !ok {
+		return "", fmt.Errorf("unknown directory ID %q", pathID)
 	}
+	return dirPath, nil
+}
 
-	dirPath := f.makePath(dir)
+// FindLeaf finds a directory of name leaf in the folder with ID pathID
+func (f *Fs) FindLeaf(ctx context.Context, pathID, leaf string) (pathIDOut string, found bool, err error) {
+	parentPath, err := f.idPath(pathID)
+	if err != nil {
+		return "", false, err
+	}
+	leafPath := path.Join(parentPath, leaf)
+
+	rec, ok := f.ids.get(leafPath)
+	if ok && rec.loaded {
+		rec, ok = f.checkIDRecord(ctx, leafPath, rec)
+	}
+	if ok {
+		if !rec.IsDir {
+			return "", false, nil
+		}
+		return strconv.FormatInt(rec.FsID, 10), true, nil
+	}
+
+	items, err := f.listPath(ctx, parentPath)
+	if err == fs.ErrorDirNotFound {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	for _, item := range items {
+		if item.IsDir() && item.Path == leafPath {
+			return strconv.FormatInt(item.FsID, 10), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// checkIDRecord checks the record for p read from the persistent
+// store with filemetas as it may be stale, removing it if it is
+func (f *Fs) checkIDRecord(ctx context.Context, p string, rec idRecord) (idRecord, bool) {
+	meta, err := f.fileMetas(ctx, rec.FsID, false, false)
+	if err != nil || meta.Path != p {
+		fs.Debugf(p, "cached fs_id %d is stale: %v", rec.FsID, err)
+		f.ids.remove(p)
+		return idRecord{}, false
+	}
+	rec = idRecord{FsID: rec.FsID, IsDir: meta.Isdir == api.FileTypeFolder}
+	f.ids.put(p, rec)
+	return rec, true
+}
+
+// CreateDir makes a directory with pathID as parent and name leaf
+func (f *Fs) CreateDir(ctx context.Context, pathID, leaf string) (newID string, err error) {
+	parentPath, err := f.idPath(pathID)
+	if err != nil {
+		return "", err
+	}
+	dirPath := path.Join(parentPath, leaf)
+
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	opts := rest.Opts{
 		Method: "POST",
@@ -718,22 +1054,33 @@ func (f *Fs) Mkdir(ctx context.Context, dir string) error {
 		return f.shouldRetry(ctx, resp, err)
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if result.ErrorCode != 0 {
-		// -8 means directory already exists, which is not an error for Mkdir
+		// -8 means directory already exists, so look it up
 		if result.ErrorCode == -8 {
-			return nil
+			newID, found, err := f.FindLeaf(ctx, pathID, leaf)
+			if err != nil {
+				return "", err
+			}
+			if !found {
+				return "", fs.ErrorIsFile
+			}
+			return newID, nil
 		}
-		return &result.Error
+		return "", &result.Error
 	}
 
-	return nil
+	f.ids.put(dirPath, idRecord{FsID: result.FsID, IsDir: true})
+	return strconv.FormatInt(result.FsID, 10), nil
 }
 
 // Rmdir removes a directory
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if f.virtual != nil {
+		return errorReadOnly
+	}
 	dirPath := f.makePath(dir)
 
 	// First check if directory is empty
@@ -752,7 +1099,12 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 			Filelist(fmt.Sprintf(`["%s"]`, dirPath)).
 			Execute()
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	f.dirCache.FlushDir(dir)
+	f.ids.remove(dirPath)
+	return nil
 }
 
 // ------------------------------------------------------------
@@ -807,16 +1159,48 @@ func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
 }
 
 // Open opens the file for read
+//
+// Range and seek options are passed on to the download server so
+// several ranges of the same object can be read concurrently, as
+// --multi-thread-streams does.
+//
+// If a TranscodeOption is passed the M3U8 playlist of a transcoded
+// stream of the file is returned instead.
 func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	for _, option := range options {
+		if t, ok := option.(*TranscodeOption); ok {
+			return o.openTranscoded(ctx, t.Type)
+		}
+	}
 	fs.Debugf(o, "Opening file for read, fsid=%d, size=%d", o.fsid, o.size)
+	fs.FixRangeOption(options, o.size)
+
+	resp, err := o.download(ctx, false, options)
+	if resp != nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusGone) {
+		// The cached download link may have expired early
+		fs.Debugf(o, "Download link rejected with status %d, fetching a new one", resp.StatusCode)
+		resp, err = o.download(ctx, true, options)
+	}
+	if err != nil {
+		fs.Errorf(o, "Download request failed: %v", err)
+		return nil, err
+	}
+
+	fs.Debugf(o, "Download response status: %d, content-length: %d", resp.StatusCode, resp.ContentLength)
 
+	return resp.Body, nil
+}
+
+// download makes a download request for the object, fetching a new
+// download link first if refresh is set or there isn't a valid one
+// cached.
+func (o *Object) download(ctx context.Context, refresh bool, options []fs.OpenOption) (*http.Response, error) {
 	// Get download link
-	dlink, err := o.getDownloadLink(ctx)
+	dlink, err := o.getDownloadLink(ctx, refresh)
 	if err != nil {
 		fs.Errorf(o, "Failed to get download link: %v", err)
 		return nil, err
 	}
-	fs.Debugf(o, "Got download link: %s", dlink)
 
 	// Get access token to append to download link
 	accessToken, err := o.fs.getAccessToken(ctx)
@@ -826,7 +1210,6 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadClo
 
 	// Append access_token to download link
 	dlinkWithToken := dlink + "&access_token=" + accessToken
-	fs.Debugf(o, "Download URL: %s", dlinkWithToken)
 
 	// Prepare download request with proper User-Agent
 	// The HTTP client will automatically follow redirects to the real download URL
@@ -845,30 +1228,44 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadClo
 		resp, err = o.fs.srv.Call(ctx, &opts)
 		return o.fs.shouldRetry(ctx, resp, err)
 	})
-	if err != nil {
-		fs.Errorf(o, "Download request failed: %v", err)
-		return nil, err
-	}
+	return resp, err
+}
 
-	fs.Debugf(o, "Download response status: %d, content-length: %d", resp.StatusCode, resp.ContentLength)
+// getFileMeta gets file metadata, including the download link if
+// dlink is set
+func (o *Object) getFileMeta(ctx context.Context, dlink bool) (*api.FileMeta, error) {
+	return o.fileMetas(ctx, dlink, false)
+}
 
-	return resp.Body, nil
+// fileMetas calls filemetas for the object, asking for the download
+// link if dlink is set and the thumbnails and media info if media is
+// set.
+func (o *Object) fileMetas(ctx context.Context, dlink, media bool) (*api.FileMeta, error) {
+	return o.fs.fileMetas(ctx, o.fsid, dlink, media)
 }
 
-// getFileMetaWithDlink gets file metadata including download link
-func (o *Object) getFileMetaWithDlink(ctx context.Context) (*api.FileMeta, error) {
-	accessToken, err := o.fs.getAccessToken(ctx)
+// fileMetas calls filemetas for the item with fsid
+func (f *Fs) fileMetas(ctx context.Context, fsid int64, dlink, media bool) (*api.FileMeta, error) {
+	accessToken, err := f.getAccessToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	dlinkParam := "0"
+	if dlink {
+		dlinkParam = "1"
+	}
+
 	var result api.FileMetasResponse
-	err = o.fs.callJSON(ctx, func() (string, *http.Response, error) {
-		return o.fs.sdk.MultimediafileApi.Xpanmultimediafilemetas(ctx).
+	err = f.callJSON(ctx, func() (string, *http.Response, error) {
+		req := f.sdk.MultimediafileApi.Xpanmultimediafilemetas(ctx).
 			AccessToken(accessToken).
-			Fsids(fmt.Sprintf("[%d]", o.fsid)).
-			Dlink("1").
-			Execute()
+			Fsids(fmt.Sprintf("[%d]", fsid)).
+			Dlink(dlinkParam)
+		if media {
+			req = req.Thumb("1").Extra("1").Needmedia(1)
+		}
+		return req.Execute()
 	}, &result)
 	if err != nil {
 		return nil, err
@@ -886,8 +1283,19 @@ func (o *Object) getFileMetaWithDlink(ctx context.Context) (*api.FileMeta, error
 }
 
 // getDownloadLink gets the download link for the object
-func (o *Object) getDownloadLink(ctx context.Context) (string, error) {
-	meta, err := o.getFileMetaWithDlink(ctx)
+//
+// The link is cached in the object until it is about to expire so that
+// range requests don't each need a filemetas call. If refresh is set a
+// new link is always fetched.
+func (o *Object) getDownloadLink(ctx context.Context, refresh bool) (string, error) {
+	o.dlinkMu.Lock()
+	defer o.dlinkMu.Unlock()
+
+	if !refresh && o.dlink != "" && time.Now().Before(o.dlinkExpiry) {
+		return o.dlink, nil
+	}
+
+	meta, err := o.getFileMeta(ctx, true)
 	if err != nil {
 		return "", err
 	}
@@ -903,12 +1311,18 @@ func (o *Object) getDownloadLink(ctx context.Context) (string, error) {
 	if dlink == "" {
 		return "", fmt.Errorf("no download link available")
 	}
+	o.dlink = dlink
+	o.dlinkExpiry = time.Now().Add(dlinkCacheTime)
+	fs.Debugf(o, "Got download link: %s", dlink)
 
 	return dlink, nil
 }
 
 // Update updates the object with new data
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if o.fs.virtual != nil {
+		return errorReadOnly
+	}
 	// Upload new file
 	newObj, err := o.fs.Put(ctx, in, src, options...)
 	if err != nil {
@@ -923,11 +1337,19 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		o.fsid = newObject.fsid
 	}
 
+	// The old download link points at the old content
+	o.dlinkMu.Lock()
+	o.dlink = ""
+	o.dlinkMu.Unlock()
+
 	return nil
 }
 
 // Remove deletes the object
 func (o *Object) Remove(ctx context.Context) error {
+	if o.fs.virtual != nil {
+		return errorReadOnly
+	}
 	filePath := o.fs.makePath(o.remote)
 	_, err := o.fs.fileManager(ctx, func(accessToken string) (*http.Response, error) {
 		return o.fs.sdk.FilemanagerApi.Filemanagerdelete(ctx).
@@ -936,7 +1358,11 @@ func (o *Object) Remove(ctx context.Context) error {
 			Filelist(fmt.Sprintf(`["%s"]`, filePath)).
 			Execute()
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	o.fs.ids.remove(filePath)
+	return nil
 }
 
 // ID returns the ID of the object
@@ -947,7 +1373,7 @@ func (o *Object) ID() string {
 // Move src to this remote using server-side move operations
 func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
 	srcObj, ok := src.(*Object)
-	if !ok {
+	if !ok || srcObj.fs.virtual != nil {
 		fs.Debugf(src, "Can't move - not same remote type")
 		return nil, fs.ErrorCantMove
 	}
@@ -975,6 +1401,10 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	}); err != nil {
 		return nil, err
 	}
+	srcObj.fs.ids.remove(srcPath)
+	if srcObj.fsid != 0 {
+		f.ids.put(dstPath, idRecord{FsID: srcObj.fsid})
+	}
 
 	return f.NewObject(ctx, remote)
 }
@@ -982,7 +1412,7 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 // Copy src to this remote using server-side copy operations
 func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
 	srcObj, ok := src.(*Object)
-	if !ok {
+	if !ok || srcObj.fs.virtual != nil {
 		fs.Debugf(src, "Can't copy - not same remote type")
 		return nil, fs.ErrorCantCopy
 	}
@@ -1014,6 +1444,216 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	return f.NewObject(ctx, remote)
 }
 
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || srcFs.virtual != nil {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+
+	srcPath := srcFs.makePath(srcRemote)
+	dstPath := f.makePath(dstRemote)
+	if srcPath == dstPath {
+		return fs.ErrorDirExists
+	}
+
+	// Check the destination doesn't exist
+	_, err := f.List(ctx, dstRemote)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if err != fs.ErrorDirNotFound {
+		return err
+	}
+
+	// Create parent directory if needed
+	dirPath := path.Dir(dstRemote)
+	if dirPath != "" && dirPath != "." {
+		if err := f.Mkdir(ctx, dirPath); err != nil {
+			return err
+		}
+	}
+
+	filelist, err := json.Marshal([]api.FileOpItem{{
+		Path:    srcPath,
+		Dest:    path.Dir(dstPath),
+		NewName: path.Base(dstPath),
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = f.fileManager(ctx, func(accessToken string) (*http.Response, error) {
+		return f.sdk.FilemanagerApi.Filemanagermove(ctx).
+			AccessToken(accessToken).
+			Async(0).
+			Filelist(string(filelist)).
+			Ondup("fail").
+			Execute()
+	})
+	if err != nil {
+		return fmt.Errorf("dirmove failed: %w", err)
+	}
+	srcFs.dirCache.FlushDir(srcRemote)
+	srcFs.ids.remove(srcPath)
+	return nil
+}
+
+// Purge deletes all the files in the directory
+//
+// The directory is deleted in one call to the filemanager which moves
+// it and its contents to the recycle bin.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	dirPath := f.makePath(dir)
+	if dirPath == "/" {
+		return errors.New("refusing to purge the root of the netdisk")
+	}
+
+	// Check the directory exists
+	if _, err := f.List(ctx, dir); err != nil {
+		return err
+	}
+
+	filelist, err := json.Marshal([]string{dirPath})
+	if err != nil {
+		return err
+	}
+	_, err = f.fileManager(ctx, func(accessToken string) (*http.Response, error) {
+		return f.sdk.FilemanagerApi.Filemanagerdelete(ctx).
+			AccessToken(accessToken).
+			Async(0).
+			Filelist(string(filelist)).
+			Execute()
+	})
+	if err != nil {
+		return err
+	}
+	f.dirCache.FlushDir(dir)
+	f.ids.remove(dirPath)
+	return nil
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	return f.ids.close()
+}
+
+var errorNoRecycleBinAPI = errors.New("the recycle bin needs --baidupan-recycle-bin-api as it isn't part of the open API")
+
+// CleanUp empties the recycle bin
+//
+// The recycle bin belongs to the whole account so this empties all of
+// it, not just the items deleted from under the root. This is only
+// enabled with --baidupan-recycle-bin-api as it isn't part of the open
+// API.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   api.PathRecycleClear,
+		Parameters: url.Values{
+			"access_token": {accessToken},
+			"async":        {"0"},
+		},
+	}
+	var result api.Error
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't empty recycle bin: %w", err)
+	}
+	if result.ErrorCode != 0 {
+		return fmt.Errorf("couldn't empty recycle bin: %w", &result)
+	}
+	return nil
+}
+
+// listRecycled lists the items in the recycle bin
+func (f *Fs) listRecycled(ctx context.Context) (items []api.RecycleItem, err error) {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for page := 1; ; page++ {
+		opts := rest.Opts{
+			Method: "GET",
+			Path:   api.PathRecycleList,
+			Parameters: url.Values{
+				"access_token": {accessToken},
+				"page":         {strconv.Itoa(page)},
+				"num":          {strconv.Itoa(recycleListLimit)},
+			},
+		}
+		var result api.RecycleListResponse
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+			return f.shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list recycle bin: %w", err)
+		}
+		if result.ErrorCode != 0 {
+			return nil, fmt.Errorf("couldn't list recycle bin: %w", &result.Error)
+		}
+		items = append(items, result.List...)
+		if len(result.List) < recycleListLimit {
+			break
+		}
+	}
+	return items, nil
+}
+
+// restoreRecycled restores the items with the given fs_ids from the
+// recycle bin to their original locations
+func (f *Fs) restoreRecycled(ctx context.Context, fsids []int64) error {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	fidlist, err := json.Marshal(fsids)
+	if err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   api.PathRecycleRestore,
+		Parameters: url.Values{
+			"access_token": {accessToken},
+			"async":        {"0"},
+		},
+		MultipartParams: url.Values{
+			"fidlist": {string(fidlist)},
+		},
+	}
+	var result api.Error
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't restore from recycle bin: %w", err)
+	}
+	if result.ErrorCode != 0 {
+		return fmt.Errorf("couldn't restore from recycle bin: %w", &result)
+	}
+	return nil
+}
+
 // About gets quota information
 func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
 	quota, err := f.getQuotaInfo(ctx)
@@ -1030,7 +1670,10 @@ func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
 	return usage, nil
 }
 
-// PublicLink generates a public link for a file or directory
+// PublicLink generates a public link for a file
+//
+// expire is rounded up to the next expiry time Baidu supports. If
+// unlink is set all the share links for the file are cancelled.
 func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
 	// Get the object to find its fsid
 	obj, err := f.NewObject(ctx, remote)
@@ -1042,8 +1685,12 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration,
 	filePath := f.makePath(remote)
 
 	if unlink {
-		// Cancel share - not implemented yet
-		return "", fs.ErrorNotImplemented
+		return "", f.unshare(ctx, fsid)
+	}
+
+	pathList, err := json.Marshal([]string{filePath})
+	if err != nil {
+		return "", err
 	}
 
 	// Create share link
@@ -1056,17 +1703,13 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration,
 		MultipartParams: map[string][]string{
 			"schannel":     {"0"},
 			"channel_list": {"[]"},
-			"period":       {"0"}, // 0: permanent, 1: 1 day, 7: 7 days
+			"period":       {sharePeriod(expire)},
 			"fid_list":     {fmt.Sprintf("[%d]", fsid)},
-			"path_list":    {fmt.Sprintf(`["%s"]`, filePath)},
+			"path_list":    {string(pathList)},
 		},
 	}
 
-	var result struct {
-		api.Error
-		Link string `json:"link"`
-	}
-
+	var result api.ShareSetResponse
 	var resp *http.Response
 	err = f.pacer.Call(func() (bool, error) {
 		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
@@ -1083,13 +1726,262 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration,
 	return result.Link, nil
 }
 
+// shareExperimental is added to the help of the share commands
+const shareExperimental = `
+
+This command is experimental. It uses the share API of the Baidu web
+client, which isn't part of the open API, so it may stop working at any
+time.`
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "recycled",
+	Short: "List the items in the recycle bin.",
+	Long: `This command lists the files and directories in the Baidu Pan
+recycle bin.
+
+Usage example:
+
+` + "```console" + `
+rclone backend recycled baidupan:
+` + "```" + `
+
+The fs_id of each item can be passed to the restore command. Note that
+the recycle bin covers the whole netdisk, not just the remote's root.
+
+This needs the recycle_bin_api option as the recycle bin isn't part of
+the open API.`,
+}, {
+	Name:  "restore",
+	Short: "Restore items from the recycle bin.",
+	Long: `This command restores items from the recycle bin to their
+original locations.
+
+Usage example:
+
+` + "```console" + `
+rclone backend restore baidupan: fs_id [fs_id...]
+` + "```" + `
+
+Use the recycled command to find the fs_id of the items to restore.
+
+This needs the recycle_bin_api option as the recycle bin isn't part of
+the open API.`,
+}, {
+	Name:  "addurl",
+	Short: "Add an offline download task for a URL.",
+	Long: `This command asks Baidu Pan to download a URL into the
+directory given by the remote path. The download happens on Baidu's
+servers, so the data doesn't pass through rclone.
+
+Usage example:
+
+` + "```console" + `
+rclone backend addurl baidupan:dirpath url
+rclone backend addurl baidupan:dirpath "magnet:?xt=urn:btih:..." -o select=1,3
+rclone backend addurl baidupan:dirpath url -o wait
+` + "```" + `
+
+http(s), ftp, ed2k and magnet links are supported. By default all the
+files in a magnet link are downloaded.
+
+The task id is returned and can be passed to the offline-status
+command. With the wait option the command doesn't return until the
+download has finished, returning an error if it fails.
+
+This needs the offline_app_id option as offline downloads aren't part
+of the open API.`,
+	Opts: map[string]string{
+		"select": "Comma separated 1 based indexes of the files to download from a magnet link",
+		"wait":   "Wait for the download to finish",
+	},
+}, {
+	Name:  "offline-status",
+	Short: "Show the status of offline download tasks.",
+	Long: `This command shows the status of offline download tasks.
+
+Usage example:
+
+` + "```console" + `
+rclone backend offline-status baidupan:
+rclone backend offline-status baidupan: task_id [task_id...]
+` + "```" + `
+
+With no arguments all the tasks are listed, newest first.
+
+This needs the offline_app_id option.`,
+}, {
+	Name:  "share-list",
+	Short: "List the contents of someone else's share link (experimental).",
+	Long: `This command lists a directory in a Baidu Pan share link.
+
+Usage example:
+
+` + "```console" + `
+rclone backend share-list baidupan: "https://pan.baidu.com/s/1AbCd?pwd=wxyz"
+rclone backend share-list baidupan: https://pan.baidu.com/s/1AbCd -o pwd=wxyz -o dir=sub/dir
+` + "```" + `
+
+The extraction code can be given in the link or with the pwd option.` + shareExperimental,
+	Opts: map[string]string{
+		"pwd": "Extraction code of the share",
+		"dir": "Directory in the share to list - the root if not set",
+	},
+}, {
+	Name:  "share-import",
+	Short: "Save someone else's share link into the remote path (experimental).",
+	Long: `This command copies the contents of a Baidu Pan share link into
+the directory given by the remote path ("save to my netdisk"). The copy
+is done server-side.
+
+Usage example:
+
+` + "```console" + `
+rclone backend share-import baidupan:dirpath "https://pan.baidu.com/s/1AbCd?pwd=wxyz"
+rclone backend share-import baidupan:dirpath https://pan.baidu.com/s/1AbCd -o pwd=wxyz path/in/share [path...]
+` + "```" + `
+
+With no paths the whole share is imported. Items which already exist
+are saved with a new name.` + shareExperimental,
+	Opts: map[string]string{
+		"pwd": "Extraction code of the share",
+	},
+}, {
+	Name:  "shares",
+	Short: "List your share links (experimental).",
+	Long: `This command lists the share links made from this account.
+
+Usage example:
+
+` + "```console" + `
+rclone backend shares baidupan:
+` + "```" + `
+
+The id of each share can be passed to the share-cancel command.` + shareExperimental,
+}, {
+	Name:  "share-cancel",
+	Short: "Cancel your share links (experimental).",
+	Long: `This command cancels share links made from this account.
+
+Usage example:
+
+` + "```console" + `
+rclone backend share-cancel baidupan: share_id [share_id...]
+` + "```" + `
+
+Use "rclone link --unlink" to cancel all the share links of a file.` + shareExperimental,
+}, {
+	Name:  "thumbnail",
+	Short: "Fetch the thumbnail of an image or video.",
+	Long: `This command fetches the thumbnail Baidu Pan has made of an image
+or video file and writes it to a local file.
+
+Usage example:
+
+` + "```console" + `
+rclone backend thumbnail baidupan: path/to/video.mp4 thumb.jpg
+rclone backend thumbnail baidupan: path/to/photo.jpg thumb.jpg -o size=small
+` + "```" + `
+
+If no local file is given the URL of the thumbnail is shown instead.
+This URL expires after a few hours.`,
+	Opts: map[string]string{
+		"size": "Size of the thumbnail: small, medium or large (default)",
+	},
+}, {
+	Name:  "transcode",
+	Short: "Show the M3U8 playlist of a transcoded video or audio stream.",
+	Long: `This command asks Baidu Pan to transcode a video or audio file and
+shows the M3U8 playlist of the HLS stream. Players can use this to
+stream the file without the throttling applied to raw downloads.
+
+Usage example:
+
+` + "```console" + `
+rclone backend transcode baidupan: path/to/video.mp4 -o resolution=1080 > video.m3u8
+rclone backend transcode baidupan: path/to/song.flac -o resolution=mp3
+` + "```" + `
+
+Accounts without a membership have to wait for a short advert time
+before the stream is served, and the first request for a file may take
+a while as Baidu transcodes it. The segment URLs in the playlist expire
+after a few hours.`,
+	Opts: map[string]string{
+		"resolution": "480, 720 (default) or 1080 for videos, mp3 for audio",
+		"type":       "Baidu stream type to use instead, e.g. M3U8_AUTO_720",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out any, err error) {
+	if f.virtual != nil && (name == "addurl" || name == "share-import") {
+		return nil, errorReadOnly
+	}
+	if !f.opt.RecycleBinAPI && (name == "recycled" || name == "restore") {
+		return nil, errorNoRecycleBinAPI
+	}
+	if f.opt.OfflineAppID == "" && (name == "addurl" || name == "offline-status") {
+		return nil, errorNoOfflineAppID
+	}
+	switch name {
+	case "recycled":
+		return f.listRecycled(ctx)
+	case "restore":
+		if len(arg) == 0 {
+			return nil, errors.New("need at least 1 fs_id to restore")
+		}
+		fsids := make([]int64, len(arg))
+		for i, a := range arg {
+			fsids[i], err = strconv.ParseInt(a, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fs_id %q: %w", a, err)
+			}
+		}
+		return nil, f.restoreRecycled(ctx, fsids)
+	case "addurl":
+		return f.offlineAdd(ctx, arg, opt)
+	case "offline-status":
+		return f.offlineStatus(ctx, arg)
+	case "share-list":
+		return f.shareList(ctx, arg, opt)
+	case "share-import":
+		return f.shareImport(ctx, arg, opt)
+	case "shares":
+		return f.shares(ctx)
+	case "share-cancel":
+		return f.shareCancel(ctx, arg)
+	case "thumbnail":
+		return f.thumbnail(ctx, arg, opt)
+	case "transcode":
+		return f.transcodeCommand(ctx, arg, opt)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs           = (*Fs)(nil)
-	_ fs.Mover        = (*Fs)(nil)
-	_ fs.Copier       = (*Fs)(nil)
-	_ fs.Abouter      = (*Fs)(nil)
-	_ fs.PublicLinker = (*Fs)(nil)
-	_ fs.Object       = (*Object)(nil)
-	_ fs.IDer         = (*Object)(nil)
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.PublicLinker    = (*Fs)(nil)
+	_ fs.OpenChunkWriter = (*Fs)(nil)
+	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.IDer            = (*Object)(nil)
+	_ fs.Metadataer      = (*Object)(nil)
 )
@@ -0,0 +1,1014 @@
+package baidupan
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/dircache"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/rest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	xpansdk "open-sdk-go/openxpanapi"
+)
+
+func TestReadBlockMD5s(t *testing.T) {
+	const blockSize = 1024
+	for _, test := range []struct {
+		name  string
+		size  int64
+		limit int64
+	}{
+		{"empty", 0, 4096},
+		{"memory", 2500, 4096},
+		{"spooled", 2500, 1024},
+		{"exactBlocks", 3 * blockSize, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(random.String(int(test.size)))
+			contentMD5, blockMD5s, spool, cleanup, err := readBlockMD5s(bytes.NewReader(data), test.size, blockSize, test.limit)
+			defer cleanup()
+			require.NoError(t, err)
+
+			sum := md5.Sum(data)
+			assert.Equal(t, hex.EncodeToString(sum[:]), contentMD5)
+
+			var want []string
+			for off := 0; off < len(data); off += blockSize {
+				end := min(off+blockSize, len(data))
+				sum := md5.Sum(data[off:end])
+				want = append(want, hex.EncodeToString(sum[:]))
+			}
+			assert.Equal(t, want, blockMD5s)
+
+			got, err := io.ReadAll(io.NewSectionReader(spool, 0, test.size))
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+func TestReadBlockMD5sShort(t *testing.T) {
+	_, _, _, cleanup, err := readBlockMD5s(bytes.NewReader(make([]byte, 100)), 200, 1024, 4096)
+	defer cleanup()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// newTestFs makes an Fs with the given root which sends all its API
+// calls to handler
+func newTestFs(t *testing.T, root string, handler http.Handler) *Fs {
+	ctx := context.Background()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	cfg := xpansdk.NewConfiguration()
+	cfg.HTTPClient = ts.Client()
+	cfg.Servers = xpansdk.ServerConfigurations{{URL: ts.URL}}
+	for endpoint := range cfg.OperationServers {
+		cfg.OperationServers[endpoint] = cfg.Servers
+	}
+	f := &Fs{
+		name: "TestBaiduPan",
+		root: root,
+		opt: Options{
+			ChunkSize:         1024,
+			HashMemoryLimit:   4096,
+			UploadConcurrency: 4,
+			Enc:               encoder.Base,
+		},
+		srv:         rest.NewClient(ts.Client()).SetRoot(ts.URL),
+		sdk:         xpansdk.NewAPIClient(cfg),
+		pacer:       fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep))),
+		tokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		ids: &idCache{
+			paths: make(map[string]idRecord),
+			ids:   make(map[int64]string),
+		},
+	}
+	f.dirCache = dircache.New(root, rootID, f)
+	f.features = (&fs.Features{}).Fill(ctx, f)
+	return f
+}
+
+// writeJSON writes response to w as JSON
+func writeJSON(w http.ResponseWriter, response string) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, response)
+}
+
+// fakeUpload fakes the precreate, superfile2 and create APIs
+//
+// Like Baidu Pan, create makes the file from the blocks uploaded
+// with superfile2 so it fails unless the block list passed to it
+// matches their MD5s, whatever was passed to precreate.
+type fakeUpload struct {
+	t           *testing.T
+	concurrency int // number of superfile2 calls to wait for before returning any
+	allIn       chan struct{}
+
+	mu              sync.Mutex
+	calls           []string
+	precreateBlocks []string
+	blocks          map[int][]byte
+	inFlight        int
+	maxInFlight     int
+	content         []byte // contents of the created file
+}
+
+func newFakeUpload(t *testing.T, concurrency int) *fakeUpload {
+	return &fakeUpload{
+		t:           t,
+		concurrency: concurrency,
+		allIn:       make(chan struct{}),
+		blocks:      make(map[int][]byte),
+	}
+}
+
+func (u *fakeUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := u.t
+	if err := r.ParseMultipartForm(1 << 20); err != http.ErrNotMultipart {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "token", r.Form.Get("access_token"))
+	method := r.Form.Get("method")
+	u.mu.Lock()
+	u.calls = append(u.calls, method)
+	u.mu.Unlock()
+
+	switch method {
+	case "precreate":
+		assert.Equal(t, "/file.bin", r.Form.Get("path"))
+		u.mu.Lock()
+		assert.NoError(t, json.Unmarshal([]byte(r.Form.Get("block_list")), &u.precreateBlocks))
+		blockList := make([]string, len(u.precreateBlocks))
+		for i := range blockList {
+			blockList[i] = strconv.Itoa(i)
+		}
+		u.mu.Unlock()
+		// The SDK decodes the block list as strings
+		list, _ := json.Marshal(blockList)
+		writeJSON(w, `{"errno":0,"return_type":1,"uploadid":"uploadid","block_list":`+string(list)+`}`)
+	case "upload":
+		assert.Equal(t, "uploadid", r.Form.Get("uploadid"))
+		partseq, err := strconv.Atoi(r.Form.Get("partseq"))
+		assert.NoError(t, err)
+		file, _, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, err := io.ReadAll(file)
+		assert.NoError(t, err)
+
+		u.mu.Lock()
+		u.blocks[partseq] = data
+		u.inFlight++
+		u.maxInFlight = max(u.maxInFlight, u.inFlight)
+		if u.inFlight == u.concurrency {
+			close(u.allIn)
+		}
+		u.mu.Unlock()
+		select {
+		case <-u.allIn:
+		case <-time.After(2 * time.Second):
+		}
+		u.mu.Lock()
+		u.inFlight--
+		u.mu.Unlock()
+
+		sum := md5.Sum(data)
+		writeJSON(w, `{"md5":"`+hex.EncodeToString(sum[:])+`"}`)
+	case "create":
+		assert.Equal(t, "uploadid", r.Form.Get("uploadid"))
+		var blockMD5s []string
+		assert.NoError(t, json.Unmarshal([]byte(r.Form.Get("block_list")), &blockMD5s))
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		var content []byte
+		for i, blockMD5 := range blockMD5s {
+			sum := md5.Sum(u.blocks[i])
+			if blockMD5 != hex.EncodeToString(sum[:]) {
+				writeJSON(w, `{"errno":2}`)
+				return
+			}
+			content = append(content, u.blocks[i]...)
+		}
+		if r.Form.Get("size") != strconv.Itoa(len(content)) {
+			writeJSON(w, `{"errno":2}`)
+			return
+		}
+		u.content = content
+		sum := md5.Sum(content)
+		writeJSON(w, fmt.Sprintf(`{"errno":0,"fs_id":1,"path":"/file.bin","size":%d,"md5":"%s","server_mtime":1700000000}`, len(content), hex.EncodeToString(sum[:])))
+	default:
+		t.Errorf("unexpected call %q", method)
+	}
+}
+
+func TestPutConcurrent(t *testing.T) {
+	ctx := context.Background()
+	u := newFakeUpload(t, 4)
+	f := newTestFs(t, "", u)
+
+	data := []byte(random.String(4*1024 - 100))
+	src := object.NewStaticObjectInfo("file.bin", time.Now(), int64(len(data)), true, nil, nil)
+	o, err := f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), o.Size())
+
+	assert.Equal(t, []string{"precreate", "upload", "upload", "upload", "upload", "create"}, u.calls)
+	assert.Equal(t, 4, u.maxInFlight, "blocks should be uploaded concurrently")
+	assert.Equal(t, data, u.content)
+	_, blockMD5s, _, cleanup, err := readBlockMD5s(bytes.NewReader(data), int64(len(data)), 1024, 4096)
+	defer cleanup()
+	require.NoError(t, err)
+	assert.Equal(t, blockMD5s, u.precreateBlocks)
+}
+
+func TestChunkWriter(t *testing.T) {
+	ctx := context.Background()
+	u := newFakeUpload(t, 3)
+	f := newTestFs(t, "", u)
+
+	data := []byte(random.String(3*1024 - 100))
+	src := object.NewStaticObjectInfo("file.bin", time.Now(), int64(len(data)), true, nil, nil)
+	info, w, err := f.OpenChunkWriter(ctx, "file.bin", src)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), info.ChunkSize)
+	assert.Equal(t, []string{placeholderBlockMD5, placeholderBlockMD5, placeholderBlockMD5}, u.precreateBlocks)
+
+	// Closing before all the chunks are written fails
+	assert.Error(t, w.Close(ctx))
+
+	// Write the chunks concurrently as multi-thread copy does
+	var wg sync.WaitGroup
+	for chunkNumber := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			offset, length := blockRange(int64(len(data)), 1024, chunkNumber)
+			n, err := w.WriteChunk(ctx, chunkNumber, bytes.NewReader(data[offset:offset+length]))
+			assert.NoError(t, err)
+			assert.Equal(t, length, n)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, u.maxInFlight)
+	require.NoError(t, w.Close(ctx))
+
+	// create is passed the real block MD5s, not the placeholders
+	assert.Equal(t, []string{"precreate", "upload", "upload", "upload", "create"}, u.calls)
+	assert.Equal(t, data, u.content)
+}
+
+func TestOpenExpiredLink(t *testing.T) {
+	ctx := context.Background()
+	data := []byte(random.String(1000))
+	var (
+		mu        sync.Mutex
+		links     int // number of download links handed out
+		downloads []string
+	)
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/rest/2.0/xpan/multimedia":
+			assert.Equal(t, "filemetas", r.URL.Query().Get("method"))
+			assert.Equal(t, "1", r.URL.Query().Get("dlink"))
+			links++
+			dlink := fmt.Sprintf("http://%s/file/%d?fid=1", r.Host, links)
+			_, _ = fmt.Fprintf(w, `{"errno":0,"list":[{"fs_id":1,"path":"/file.bin","size":%d,"dlink":%q}]}`, len(data), dlink)
+		case "/file/1", "/file/2":
+			assert.Equal(t, "token", r.URL.Query().Get("access_token"))
+			assert.Equal(t, "pan.baidu.com", r.Header.Get("User-Agent"))
+			downloads = append(downloads, r.URL.Path)
+			if r.URL.Path == "/file/1" {
+				// The first link has expired
+				http.Error(w, "link expired", http.StatusForbidden)
+				return
+			}
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+		default:
+			t.Errorf("unexpected request %q", r.URL)
+		}
+	}))
+	o := &Object{fs: f, remote: "file.bin", size: int64(len(data)), fsid: 1}
+
+	read := func(options ...fs.OpenOption) []byte {
+		in, err := o.Open(ctx, options...)
+		require.NoError(t, err)
+		got, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		return got
+	}
+
+	// The rejected link is replaced and the read succeeds
+	assert.Equal(t, data, read())
+	assert.Equal(t, 2, links)
+	assert.Equal(t, []string{"/file/1", "/file/2"}, downloads)
+
+	// Then the new link is reused for range reads
+	assert.Equal(t, data[100:200], read(&fs.RangeOption{Start: 100, End: 199}))
+	assert.Equal(t, data[900:], read(&fs.SeekOption{Offset: 900}))
+	assert.Equal(t, 2, links)
+	assert.Equal(t, []string{"/file/1", "/file/2", "/file/2", "/file/2"}, downloads)
+
+	// Until it is about to expire
+	o.dlinkMu.Lock()
+	o.dlinkExpiry = time.Now().Add(-time.Second)
+	o.dlinkMu.Unlock()
+	mu.Lock()
+	links = 1 // hand out /file/2 again
+	mu.Unlock()
+	assert.Equal(t, data, read())
+	assert.Equal(t, 2, links)
+}
+
+func TestListR(t *testing.T) {
+	ctx := context.Background()
+	// Pages of the listing of /Music keyed by start
+	pages := map[string]string{
+		"0": `{"errno":0,"has_more":1,"cursor":2,"list":[` +
+			`{"fs_id":1,"path":"/Music/A","isdir":1,"server_mtime":1700000000},` +
+			`{"fs_id":2,"path":"/Music/A/song1.mp3","size":100,"md5":"md5-2","server_mtime":1700000000}]}`,
+		"2": `{"errno":0,"has_more":1,"cursor":4,"list":[` +
+			`{"fs_id":3,"path":"/Musical/song2.mp3","size":200,"server_mtime":1700000000},` +
+			`{"fs_id":4,"path":"/Music/song3.mp3","size":300,"server_mtime":1700000000}]}`,
+		"4": `{"errno":0,"has_more":0,"cursor":5,"list":[` +
+			`{"fs_id":5,"path":"/Other/song4.mp3","size":400,"server_mtime":1700000000}]}`,
+	}
+	var starts []string
+	f := newTestFs(t, "Music", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal(t, "listall", query.Get("method"))
+		assert.Equal(t, "1", query.Get("recursion"))
+		if query.Get("path") != "/Music" {
+			_, _ = io.WriteString(w, `{"errno":-9}`)
+			return
+		}
+		start := query.Get("start")
+		starts = append(starts, start)
+		page, ok := pages[start]
+		assert.True(t, ok, start)
+		_, _ = io.WriteString(w, page)
+	}))
+
+	var entries fs.DirEntries
+	require.NoError(t, f.ListR(ctx, "", func(tranche fs.DirEntries) error {
+		entries = append(entries, tranche...)
+		return nil
+	}))
+	assert.Equal(t, []string{"0", "2", "4"}, starts)
+
+	// Items outside the root are ignored, even with the root as a prefix
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Remote())
+	}
+	assert.Equal(t, []string{"A", "A/song1.mp3", "song3.mp3"}, got)
+	_, isDir := entries[0].(fs.Directory)
+	assert.True(t, isDir)
+	o, ok := entries[1].(*Object)
+	require.True(t, ok)
+	assert.Equal(t, int64(100), o.Size())
+	assert.Equal(t, int64(2), o.fsid)
+
+	err := f.ListR(ctx, "missing", func(fs.DirEntries) error { return nil })
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
+
+func TestDirMove(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	// The directories on the fake remote
+	dirs := map[string]int64{"/src": 1, "/src/sub": 2, "/dst": 3}
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := r.ParseForm(); !assert.NoError(t, err) {
+			return
+		}
+		switch r.Form.Get("method") {
+		case "list":
+			dir := r.Form.Get("dir")
+			if _, ok := dirs[dir]; !ok && dir != "/" {
+				_, _ = io.WriteString(w, `{"errno":-9}`)
+				return
+			}
+			var items []string
+			for p, fsid := range dirs {
+				if path.Dir(p) == dir {
+					items = append(items, fmt.Sprintf(`{"fs_id":%d,"path":%q,"isdir":1}`, fsid, p))
+				}
+			}
+			_, _ = io.WriteString(w, `{"errno":0,"list":[`+strings.Join(items, ",")+`]}`)
+		case "filemanager":
+			assert.Equal(t, "move", r.Form.Get("opera"))
+			var ops []api.FileOpItem
+			assert.NoError(t, json.Unmarshal([]byte(r.Form.Get("filelist")), &ops))
+			for _, op := range ops {
+				newPath := path.Join(op.Dest, op.NewName)
+				for p, fsid := range dirs {
+					if p == op.Path || strings.HasPrefix(p, op.Path+"/") {
+						delete(dirs, p)
+						dirs[newPath+p[len(op.Path):]] = fsid
+					}
+				}
+			}
+			_, _ = io.WriteString(w, `{"errno":0}`)
+		default:
+			t.Errorf("unexpected call %q", r.URL)
+		}
+	}))
+
+	id, err := f.dirCache.FindDir(ctx, "src/sub", false)
+	require.NoError(t, err)
+	assert.Equal(t, "2", id)
+
+	assert.ErrorIs(t, f.DirMove(ctx, f, "src", "dst"), fs.ErrorDirExists)
+	require.NoError(t, f.DirMove(ctx, f, "src", "dst/moved"))
+
+	// The moved directories are forgotten by both caches
+	for _, dir := range []string{"src", "src/sub"} {
+		_, ok := f.dirCache.Get(dir)
+		assert.False(t, ok, dir)
+		_, ok = f.ids.get("/" + dir)
+		assert.False(t, ok, dir)
+	}
+	_, err = f.dirCache.FindDir(ctx, "src/sub", false)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	id, err = f.dirCache.FindDir(ctx, "dst/moved/sub", false)
+	require.NoError(t, err)
+	assert.Equal(t, "2", id)
+}
+
+func TestPurgeRoot(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected call %q", r.URL)
+	}))
+	assert.ErrorContains(t, f.Purge(ctx, ""), "refusing to purge the root")
+
+	// A subdirectory as the root is fine
+	var deleted []string
+	f = newTestFs(t, "dir", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); !assert.NoError(t, err) {
+			return
+		}
+		switch r.Form.Get("method") {
+		case "list":
+			assert.Equal(t, "/dir", r.Form.Get("dir"))
+			_, _ = io.WriteString(w, `{"errno":0,"list":[]}`)
+		case "filemanager":
+			assert.Equal(t, "delete", r.Form.Get("opera"))
+			deleted = append(deleted, r.Form.Get("filelist"))
+			_, _ = io.WriteString(w, `{"errno":0}`)
+		default:
+			t.Errorf("unexpected call %q", r.URL)
+		}
+	}))
+	require.NoError(t, f.Purge(ctx, ""))
+	assert.Equal(t, []string{`["/dir"]`}, deleted)
+}
+
+func TestUploadSession(t *testing.T) {
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() { _ = config.SetCacheDir(oldCacheDir) }()
+
+	f := &Fs{name: "test", opt: Options{ResumeUploads: true}}
+	blockMD5s := []string{"a", "b", "c"}
+
+	assert.Nil(t, f.loadUploadSession("/file", 3, "md5", blockMD5s))
+
+	s := f.newUploadSession("/file", 3, "md5", blockMD5s, "uploadid", []int{0, 1, 2})
+	assert.Equal(t, []int{0, 1, 2}, s.remaining())
+	s.done(1)
+
+	loaded := f.loadUploadSession("/file", 3, "md5", blockMD5s)
+	require.NotNil(t, loaded)
+	assert.Equal(t, "uploadid", loaded.UploadID)
+	assert.Equal(t, []int{0, 2}, loaded.remaining())
+
+	// Only the block numbers are appended and a partly written
+	// one is ignored
+	data, err := os.ReadFile(s.file)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+	require.NoError(t, os.WriteFile(s.file, append(data, '2'), 0600))
+	loaded = f.loadUploadSession("/file", 3, "md5", blockMD5s)
+	require.NotNil(t, loaded)
+	assert.Equal(t, []int{0, 2}, loaded.remaining())
+	loaded.done(2)
+	loaded = f.loadUploadSession("/file", 3, "md5", blockMD5s)
+	require.NotNil(t, loaded)
+	assert.Equal(t, []int{0}, loaded.remaining())
+
+	// Different content must not match
+	assert.Nil(t, f.loadUploadSession("/file", 3, "other", blockMD5s))
+	assert.Nil(t, f.loadUploadSession("/file", 3, "md5", []string{"a", "b", "d"}))
+
+	loaded.remove()
+	assert.Nil(t, f.loadUploadSession("/file", 3, "md5", blockMD5s))
+
+	// Disabled sessions aren't persisted
+	f.opt.ResumeUploads = false
+	s = f.newUploadSession("/file", 3, "md5", blockMD5s, "uploadid", []int{0, 1, 2})
+	s.done(0)
+	f.opt.ResumeUploads = true
+	assert.Nil(t, f.loadUploadSession("/file", 3, "md5", blockMD5s))
+}
+
+func TestBlockRange(t *testing.T) {
+	for _, test := range []struct {
+		size, blockSize int64
+		blockNum        int
+		offset, length  int64
+	}{
+		{10, 4, 0, 0, 4},
+		{10, 4, 1, 4, 4},
+		{10, 4, 2, 8, 2},
+		{8, 4, 1, 4, 4},
+		{0, 4, 0, 0, 0},
+	} {
+		offset, length := blockRange(test.size, test.blockSize, test.blockNum)
+		assert.Equal(t, test.offset, offset, "offset %+v", test)
+		assert.Equal(t, test.length, length, "length %+v", test)
+	}
+}
+
+func TestIDCache(t *testing.T) {
+	c := &idCache{
+		paths: make(map[string]idRecord),
+		ids:   make(map[int64]string),
+	}
+
+	_, ok := c.get("/a")
+	assert.False(t, ok)
+
+	c.put("/a", idRecord{FsID: 1, IsDir: true})
+	c.put("/a/b", idRecord{FsID: 2})
+	c.put("/a/c", idRecord{FsID: 3, IsDir: true})
+	c.put("/a/c/d", idRecord{FsID: 4})
+	c.put("/ab", idRecord{FsID: 5})
+
+	rec, ok := c.get("/a/b")
+	assert.True(t, ok)
+	assert.Equal(t, idRecord{FsID: 2}, rec)
+	p, ok := c.pathOf(3)
+	assert.True(t, ok)
+	assert.Equal(t, "/a/c", p)
+
+	c.remove("/a/c")
+	for _, p := range []string{"/a/c", "/a/c/d"} {
+		_, ok = c.get(p)
+		assert.False(t, ok, p)
+	}
+	_, ok = c.pathOf(4)
+	assert.False(t, ok)
+
+	c.remove("/a")
+	for _, p := range []string{"/a", "/a/b"} {
+		_, ok = c.get(p)
+		assert.False(t, ok, p)
+	}
+	_, ok = c.get("/ab")
+	assert.True(t, ok, "sibling with common prefix should be kept")
+
+	c.putAll(map[string]idRecord{"/x": {FsID: 6, IsDir: true}, "/x/y": {FsID: 7}})
+	p, ok = c.pathOf(7)
+	assert.True(t, ok)
+	assert.Equal(t, "/x/y", p)
+}
+
+func TestIDCacheShared(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported")
+	}
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() { _ = config.SetCacheDir(oldCacheDir) }()
+
+	ctx := context.Background()
+	newFs := func() *Fs {
+		return &Fs{name: "TestIDCacheShared", opt: Options{PersistDirCache: true}}
+	}
+	c1, err := getIDCache(ctx, newFs())
+	require.NoError(t, err)
+	c2, err := getIDCache(ctx, newFs())
+	require.NoError(t, err)
+	assert.Same(t, c1, c2)
+	require.NotNil(t, c1.getDB())
+
+	// Records read from the store are marked as loaded until seen again
+	c1.putAll(map[string]idRecord{"/a": {FsID: 1, IsDir: true}, "/a/b": {FsID: 2}})
+	c1.mu.Lock()
+	c1.paths = make(map[string]idRecord)
+	c1.mu.Unlock()
+	rec, ok := c1.get("/a")
+	assert.True(t, ok)
+	assert.Equal(t, idRecord{FsID: 1, IsDir: true, loaded: true}, rec)
+	c1.put("/a", idRecord{FsID: 1, IsDir: true})
+	rec, ok = c1.get("/a")
+	assert.True(t, ok)
+	assert.False(t, rec.loaded)
+
+	// The store stays open until the last user closes the cache
+	require.NoError(t, c1.close())
+	assert.NotNil(t, c2.getDB())
+	rec, ok = c2.get("/a/b")
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), rec.FsID)
+	require.NoError(t, c2.close())
+	assert.Nil(t, c2.getDB())
+
+	// And then a new cache is made
+	c3, err := getIDCache(ctx, newFs())
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c3)
+	require.NoError(t, c3.close())
+}
+
+func TestNewOfflineTask(t *testing.T) {
+	task := newOfflineTask("123", &api.OfflineTask{
+		TaskName:     "file.iso",
+		Status:       api.OfflineStatusRunning,
+		FileSize:     "1000",
+		FinishedSize: "250",
+		SavePath:     "/downloads",
+		CreateTime:   "1700000000",
+		FinishTime:   "0",
+	})
+	assert.Equal(t, offlineTask{
+		ID:           123,
+		Name:         "file.iso",
+		Status:       "running",
+		Size:         1000,
+		FinishedSize: 250,
+		SavePath:     "/downloads",
+		Created:      time.Unix(1700000000, 0),
+	}, task)
+
+	// list_task returns the id in the task
+	task = newOfflineTask("", &api.OfflineTask{TaskID: "456", Status: "99"})
+	assert.Equal(t, int64(456), task.ID)
+	assert.Equal(t, "unknown status 99", task.Status)
+}
+
+func TestParseShareURL(t *testing.T) {
+	for _, test := range []struct {
+		link, pwd string
+		want      shareRef
+		wantErr   bool
+	}{
+		{link: "https://pan.baidu.com/s/1AbCd?pwd=wxyz", want: shareRef{surl: "AbCd", pwd: "wxyz"}},
+		{link: "https://pan.baidu.com/s/1AbCd", pwd: "wxyz", want: shareRef{surl: "AbCd", pwd: "wxyz"}},
+		{link: "https://pan.baidu.com/s/1AbCd?pwd=wxyz", pwd: "abcd", want: shareRef{surl: "AbCd", pwd: "abcd"}},
+		{link: "https://pan.baidu.com/share/init?surl=AbCd", want: shareRef{surl: "AbCd"}},
+		{link: "1AbCd", want: shareRef{surl: "AbCd"}},
+		{link: "https://pan.baidu.com/disk/home", wantErr: true},
+		{link: "https://pan.baidu.com/s/", wantErr: true},
+	} {
+		got, err := parseShareURL(test.link, test.pwd)
+		if test.wantErr {
+			assert.Error(t, err, test.link)
+			continue
+		}
+		require.NoError(t, err, test.link)
+		assert.Equal(t, test.want, got, test.link)
+	}
+}
+
+// Synthetic responses for the share APIs, written to match the
+// documented fields. They are not recorded from the real API so
+// don't treat them as its contract.
+var shareResponses = map[string]string{
+	"/share/verify": `{"errno":0,"err_msg":"","request_id":8960457271213455000,"randsk":"Yo0Z%2Bk5pSx7IFm8DWxQuwA"}`,
+	"/share/list?root=1": `{"errno":0,"request_id":8960466843104577000,"server_time":1718090043,"share_id":45286715219,"uk":1102873420171,"title":"\/shared","list":[` +
+		`{"category":6,"fs_id":960436170512339,"isdir":1,"path":"\/shared\/Music","server_filename":"Music","server_mtime":1718089915,"size":0},` +
+		`{"category":4,"fs_id":543982211640284,"isdir":0,"md5":"0a6fe7a47ec62a2c6c3b38a5a3b2a0f5","path":"\/shared\/notes.txt","server_filename":"notes.txt","server_mtime":1718089863,"size":1234}]}`,
+	"/share/list?dir=/shared/Music": `{"errno":0,"request_id":8960468311225231000,"server_time":1718090049,"share_id":45286715219,"uk":1102873420171,"list":[` +
+		`{"category":2,"fs_id":214523779451683,"isdir":0,"md5":"6b1f59c1c4c5bd2b1aeb3e8f1e5a1c5d","path":"\/shared\/Music\/song.mp3","server_filename":"song.mp3","server_mtime":1718089915,"size":4567}]}`,
+	"/share/transfer": `{"errno":0,"task_id":0,"info":[{"errno":0,"fsid":214523779451683,"path":"\/shared\/Music\/song.mp3"}],"extra":{"list":[{"from":"\/shared\/Music\/song.mp3","to":"\/song.mp3"}]},"newno":"","request_id":8960472437920342000,"show_msg":""}`,
+	"/share/record": `{"errno":0,"request_id":8960476211394567000,"count":1,"list":[` +
+		`{"shareId":45286715219,"fsIds":[960436170512339],"shorturl":"1AbCdEfGh","typicalPath":"\/shared\/Music","passwd":"wxyz","ctime":1718089990,"expiredTime":1718694790,"status":0}]}`,
+	"/share/cancel": `{"errno":0,"request_id":8960479118827711000}`,
+}
+
+func TestShareCommands(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != http.ErrNotMultipart {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, "token", r.Form.Get("access_token"))
+		key := r.URL.Path
+		switch r.URL.Path {
+		case "/share/verify":
+			assert.Equal(t, "AbCdEfGh", r.Form.Get("surl"))
+			if r.Form.Get("pwd") != "wxyz" {
+				_, _ = io.WriteString(w, `{"errno":-9,"err_msg":"","request_id":8960457271213455000}`)
+				return
+			}
+		case "/share/list":
+			assert.Equal(t, "AbCdEfGh", r.Form.Get("shorturl"))
+			assert.Equal(t, "Yo0Z%2Bk5pSx7IFm8DWxQuwA", r.Form.Get("sekey"))
+			if r.Form.Get("root") == "1" {
+				key += "?root=1"
+			} else {
+				key += "?dir=" + r.Form.Get("dir")
+			}
+		case "/share/transfer":
+			assert.Equal(t, "45286715219", r.Form.Get("shareid"))
+			assert.Equal(t, "1102873420171", r.Form.Get("from"))
+			assert.Equal(t, "[214523779451683]", r.Form.Get("fsidlist"))
+			assert.Equal(t, "/", r.Form.Get("path"))
+		case "/share/cancel":
+			assert.Equal(t, "[45286715219]", r.Form.Get("shareid_list"))
+		}
+		calls = append(calls, key)
+		response, ok := shareResponses[key]
+		assert.True(t, ok, key)
+		_, _ = io.WriteString(w, response)
+	}))
+	defer ts.Close()
+
+	f := &Fs{
+		opt:         Options{Enc: encoder.Base},
+		srv:         rest.NewClient(ts.Client()).SetRoot(ts.URL),
+		pacer:       fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep))),
+		tokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+	}
+	f.dirCache = dircache.New("", rootID, f)
+	link := "https://pan.baidu.com/s/1AbCdEfGh?pwd=wxyz"
+
+	out, err := f.Command(ctx, "share-list", []string{link}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []shareItem{
+		{Path: "Music", IsDir: true, ModTime: time.Unix(1718089915, 0), FsID: 960436170512339},
+		{Path: "notes.txt", Size: 1234, ModTime: time.Unix(1718089863, 0), FsID: 543982211640284},
+	}, out)
+
+	out, err = f.Command(ctx, "share-list", []string{link}, map[string]string{"dir": "Music"})
+	require.NoError(t, err)
+	assert.Equal(t, []shareItem{
+		{Path: "Music/song.mp3", Size: 4567, ModTime: time.Unix(1718089915, 0), FsID: 214523779451683},
+	}, out)
+
+	_, err = f.Command(ctx, "share-list", []string{link}, map[string]string{"dir": "Music/potato.mp3"})
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+
+	_, err = f.Command(ctx, "share-list", []string{link}, map[string]string{"pwd": "abcd"})
+	var apiErr *api.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, -9, apiErr.ErrorCode)
+
+	calls = nil
+	_, err = f.Command(ctx, "share-import", []string{link, "Music/song.mp3"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/share/verify", "/share/list?root=1", "/share/list?root=1", "/share/list?dir=/shared/Music", "/share/transfer"}, calls)
+
+	out, err = f.Command(ctx, "shares", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []share{{
+		ID:       45286715219,
+		Link:     "https://pan.baidu.com/s/1AbCdEfGh",
+		Password: "wxyz",
+		Path:     "/shared/Music",
+		Created:  time.Unix(1718089990, 0),
+		Expires:  time.Unix(1718694790, 0),
+	}}, out)
+
+	_, err = f.Command(ctx, "share-cancel", []string{"45286715219"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, f.unshare(ctx, 960436170512339))
+	assert.Error(t, f.unshare(ctx, 1))
+}
+
+func TestChangeNotifyRunner(t *testing.T) {
+	ctx := context.Background()
+	var listing string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "listall", r.URL.Query().Get("method"))
+		_, _ = io.WriteString(w, listing)
+	}))
+	defer ts.Close()
+
+	cfg := xpansdk.NewConfiguration()
+	cfg.HTTPClient = ts.Client()
+	cfg.OperationServers["MultimediafileApiService.Xpanfilelistall"] = xpansdk.ServerConfigurations{{URL: ts.URL}}
+	f := &Fs{
+		opt:         Options{Enc: encoder.Base},
+		sdk:         xpansdk.NewAPIClient(cfg),
+		pacer:       fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep))),
+		tokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+	}
+
+	var notified []string
+	notifyFunc := func(remote string, _ fs.EntryType) {
+		notified = append(notified, remote)
+	}
+	listing = `{"errno":0,"has_more":0,"list":[{"fs_id":1,"path":"/a","server_mtime":100}]}`
+	state, err := f.changeNotifyStart(ctx)
+	require.NoError(t, err)
+
+	run := func(items string) []string {
+		listing = `{"errno":0,"has_more":0,"list":[` + items + `]}`
+		notified = nil
+		require.NoError(t, f.changeNotifyRunner(ctx, notifyFunc, state))
+		return notified
+	}
+
+	// Items in the same second as the last poll are found too
+	assert.Equal(t, []string{"c", "b"}, run(`{"fs_id":3,"path":"/c","server_mtime":101},{"fs_id":2,"path":"/b","server_mtime":100},{"fs_id":1,"path":"/a","server_mtime":100},{"fs_id":9,"path":"/old","server_mtime":99}`))
+	// But aren't notified twice
+	assert.Equal(t, []string{"d"}, run(`{"fs_id":4,"path":"/d","server_mtime":101},{"fs_id":3,"path":"/c","server_mtime":101},{"fs_id":2,"path":"/b","server_mtime":100}`))
+	assert.Empty(t, run(`{"fs_id":4,"path":"/d","server_mtime":101},{"fs_id":3,"path":"/c","server_mtime":101},{"fs_id":2,"path":"/b","server_mtime":100}`))
+	// A modified item is notified again
+	assert.Equal(t, []string{"c"}, run(`{"fs_id":3,"path":"/c","server_mtime":102},{"fs_id":4,"path":"/d","server_mtime":101}`))
+}
+
+func TestSharePeriod(t *testing.T) {
+	for _, test := range []struct {
+		expire fs.Duration
+		want   string
+	}{
+		{fs.DurationOff, "0"},
+		{0, "0"},
+		{fs.Duration(time.Hour), "1"},
+		{fs.Duration(24 * time.Hour), "1"},
+		{fs.Duration(25 * time.Hour), "7"},
+		{fs.Duration(30 * 24 * time.Hour), "30"},
+		{fs.Duration(100 * 24 * time.Hour), "365"},
+		{fs.Duration(1000 * 24 * time.Hour), "0"},
+	} {
+		assert.Equal(t, test.want, sharePeriod(test.expire), test.expire.String())
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	m := parseMetadata(&api.FileMeta{
+		ServerMtime: 1700000000,
+		ServerCtime: 1600000000,
+		Category:    api.CategoryVideo,
+		Thumbs:      map[string]string{"url1": "small", "url3": "large", "icon": "icon"},
+		MediaInfo:   &api.MediaInfo{Duration: 245, Width: 1920, Height: 1080},
+	})
+	assert.Equal(t, fs.Metadata{
+		"mtime":           time.Unix(1700000000, 0).Format(time.RFC3339),
+		"btime":           time.Unix(1600000000, 0).Format(time.RFC3339),
+		"category":        "video",
+		"duration":        "245",
+		"width":           "1920",
+		"height":          "1080",
+		"thumbnail-small": "small",
+		"thumbnail-large": "large",
+	}, m)
+	for key := range m {
+		assert.Contains(t, systemMetadataInfo, key)
+	}
+
+	// Images have their size at the top level
+	m = parseMetadata(&api.FileMeta{
+		Category:  api.CategoryImage,
+		Width:     640,
+		Height:    480,
+		DateTaken: 1500000000,
+	})
+	assert.Equal(t, "image", m["category"])
+	assert.Equal(t, "640", m["width"])
+	assert.Equal(t, "480", m["height"])
+	assert.Equal(t, time.Unix(1500000000, 0).Format(time.RFC3339), m["date-taken"])
+	assert.NotContains(t, m, "duration")
+}
+
+func TestParseVirtualRoot(t *testing.T) {
+	v, err := parseVirtualRoot("Music/Albums")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = parseVirtualRoot("{category}/audio/Music")
+	require.NoError(t, err)
+	assert.Equal(t, virtualCategory, v.kind)
+	assert.Equal(t, api.CategoryAudio, v.category)
+	assert.Equal(t, "Music", v.sub)
+
+	v, err = parseVirtualRoot("{search}/holiday")
+	require.NoError(t, err)
+	assert.Equal(t, virtualSearch, v.kind)
+	assert.Equal(t, "holiday", v.term)
+	assert.Equal(t, "", v.sub)
+
+	_, err = parseVirtualRoot("{category}/nonsense")
+	assert.Error(t, err)
+	_, err = parseVirtualRoot("{search}")
+	assert.Error(t, err)
+}
+
+func TestVirtualList(t *testing.T) {
+	ctx := context.Background()
+	f := &Fs{opt: Options{Enc: encoder.Base}}
+	f.virtual = &virtualRoot{kind: virtualSearch, term: "song"}
+	f.virtual.tree = newVirtualTree([]api.FileItem{
+		{FsID: 1, Path: "/song.mp3", Size: 1},
+		{FsID: 2, Path: "/Music/A/song1.mp3", Size: 2},
+		{FsID: 3, Path: "/Music/A/song2.mp3", Size: 3},
+		{FsID: 4, Path: "/Music/B/song3.mp3", Size: 4},
+		{FsID: 5, Path: "/Music/songs", Isdir: api.FileTypeFolder},
+	})
+	f.virtual.fetched = time.Now()
+
+	names := func(entries fs.DirEntries) (out []string) {
+		for _, entry := range entries {
+			out = append(out, entry.Remote())
+		}
+		return out
+	}
+
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Music", "song.mp3"}, names(entries))
+
+	entries, err = f.List(ctx, "Music")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Music/A", "Music/B"}, names(entries))
+
+	entries, err = f.List(ctx, "Music/A")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Music/A/song1.mp3", "Music/A/song2.mp3"}, names(entries))
+	assert.Equal(t, int64(2), entries[0].Size())
+
+	_, err = f.List(ctx, "Music/songs")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	o, err := f.NewObject(ctx, "Music/A/song2.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), o.Size())
+	_, err = f.NewObject(ctx, "Music/A")
+	assert.ErrorIs(t, err, fs.ErrorIsDir)
+	_, err = f.NewObject(ctx, "Music/A/potato.mp3")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+
+	// Root pointing into the tree
+	f.virtual.sub = "Music"
+	entries, err = f.List(ctx, "B")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"B/song3.mp3"}, names(entries))
+	o, err = f.NewObject(ctx, "B/song3.mp3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), o.Size())
+
+	// Empty results still have a root
+	f.virtual = &virtualRoot{kind: virtualSearch, term: "none", tree: newVirtualTree(nil), fetched: time.Now()}
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.ErrorIs(t, f.Mkdir(ctx, "dir"), errorReadOnly)
+}
+
+func TestParseTranscodeType(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", defaultTranscodeType, false},
+		{"480", "M3U8_AUTO_480", false},
+		{"1080", "M3U8_AUTO_1080", false},
+		{"MP3", "M3U8_MP3_128", false},
+		{"M3U8_FLV_264_480", "M3U8_FLV_264_480", false},
+		{"4k", "", true},
+	} {
+		got, err := parseTranscodeType(test.in)
+		if test.wantErr {
+			assert.Error(t, err, test.in)
+			continue
+		}
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
@@ -13,15 +13,3 @@ func TestIntegration(t *testing.T) {
 		NilObject:  (*Object)(nil),
 	})
 }
-
-
-
-
-
-
-
-
-<<<<<<< HEAD
-
-=======
->>>>>>> 924501008797cef4628c9b23822429374e50c84d
@@ -0,0 +1,225 @@
+package baidupan
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// Baidu Pan has no change feed in its open API, so changes are found
+// by polling. New and modified items are found by paging through the
+// recursive listing ordered by server_mtime, newest first, until an
+// item older than the last poll is seen. server_mtime only has a
+// resolution of a second, so items from the second of the last poll
+// are read again and skipped if they were seen then.
+//
+// If --baidupan-recycle-bin-api is set, deleted items are found by
+// looking for new entries in the recycle bin, which also removes them
+// from the fs_id and directory caches. Otherwise deletions are only
+// noticed when --dir-cache-time expires.
+//
+// Items moved out of a directory are only noticed at their new
+// location, so the old parent is refreshed when the directory cache
+// expires.
+
+// changeKey identifies a version of an item
+type changeKey struct {
+	fsid  int64
+	mtime int64
+}
+
+// changeState is the state kept between polls
+type changeState struct {
+	cursor   int64                  // newest server_mtime seen so far
+	seen     map[changeKey]struct{} // items seen with server_mtime == cursor
+	recycled map[int64]struct{}     // fs_ids seen in the recycle bin
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+// If the implementation uses polling, it should adhere to the given interval.
+//
+// Automatically restarts itself in case of unexpected behavior of the remote.
+//
+// Close the returned channel to stop being notified.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	go func() {
+		// get the starting point early so all changes from now on get processed
+		state, err := f.changeNotifyStart(ctx)
+		if err != nil {
+			fs.Infof(f, "Failed to start change notify: %s", err)
+		}
+
+		var ticker *time.Ticker
+		var tickerC <-chan time.Time
+		for {
+			select {
+			case pollInterval, ok := <-pollIntervalChan:
+				if !ok {
+					if ticker != nil {
+						ticker.Stop()
+					}
+					return
+				}
+				if ticker != nil {
+					ticker.Stop()
+					ticker, tickerC = nil, nil
+				}
+				if pollInterval != 0 {
+					ticker = time.NewTicker(pollInterval)
+					tickerC = ticker.C
+				}
+			case <-tickerC:
+				if state == nil {
+					state, err = f.changeNotifyStart(ctx)
+					if err != nil {
+						fs.Infof(f, "Failed to start change notify: %s", err)
+					}
+					continue
+				}
+				err = f.changeNotifyRunner(ctx, notifyFunc, state)
+				if err != nil {
+					fs.Infof(f, "Change notify listener failure: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// changeNotifyStart reads the newest server_mtime and the contents of
+// the recycle bin to start polling from
+func (f *Fs) changeNotifyStart(ctx context.Context) (*changeState, error) {
+	state := &changeState{
+		seen:     make(map[changeKey]struct{}),
+		recycled: make(map[int64]struct{}),
+	}
+	result, err := f.listAllByTime(ctx, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.List) > 0 {
+		item := result.List[0]
+		state.cursor = item.ServerMtime
+		state.seen[changeKey{item.FsID, item.ServerMtime}] = struct{}{}
+	}
+	if !f.opt.RecycleBinAPI {
+		return state, nil
+	}
+	items, err := f.listRecycled(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		state.recycled[item.FsID] = struct{}{}
+	}
+	return state, nil
+}
+
+// changeNotifyRunner polls for changes since the last call, calling
+// notifyFunc for each changed item and updating state.
+func (f *Fs) changeNotifyRunner(ctx context.Context, notifyFunc func(string, fs.EntryType), state *changeState) error {
+	notify := func(p string, isDir bool) {
+		remote, ok := f.remoteFromPath(p)
+		if !ok {
+			return
+		}
+		entryType := fs.EntryObject
+		if isDir {
+			entryType = fs.EntryDirectory
+		}
+		fs.Debugf(f, "Change notify: %q (%v)", remote, entryType)
+		notifyFunc(remote, entryType)
+	}
+
+	// New and modified items
+	newest := state.cursor
+	var found []changeKey
+	start := 0
+	for done := false; !done; {
+		result, err := f.listAllByTime(ctx, start, listAllLimit)
+		if err != nil {
+			return err
+		}
+		for _, item := range result.List {
+			if item.ServerMtime < state.cursor {
+				done = true
+				break
+			}
+			key := changeKey{item.FsID, item.ServerMtime}
+			if _, ok := state.seen[key]; ok {
+				continue
+			}
+			found = append(found, key)
+			newest = max(newest, item.ServerMtime)
+			notify(item.Path, item.IsDir())
+		}
+		if result.HasMore == 0 || len(result.List) == 0 {
+			break
+		}
+		start = int(result.Cursor)
+	}
+	if newest != state.cursor {
+		state.cursor = newest
+		state.seen = make(map[changeKey]struct{})
+	}
+	for _, key := range found {
+		if key.mtime == newest {
+			state.seen[key] = struct{}{}
+		}
+	}
+
+	// Deleted items
+	if !f.opt.RecycleBinAPI {
+		return nil
+	}
+	items, err := f.listRecycled(ctx)
+	if err != nil {
+		return err
+	}
+	recycled := make(map[int64]struct{}, len(items))
+	for _, item := range items {
+		recycled[item.FsID] = struct{}{}
+		if _, seen := state.recycled[item.FsID]; !seen {
+			isDir := item.Isdir == api.FileTypeFolder
+			f.ids.remove(item.Path)
+			if remote, ok := f.remoteFromPath(item.Path); ok && isDir {
+				f.dirCache.FlushDir(remote)
+			}
+			notify(item.Path, isDir)
+		}
+	}
+	state.recycled = recycled
+	return nil
+}
+
+// listAllByTime reads a page of the recursive listing of the root
+// ordered by server_mtime, newest first
+func (f *Fs) listAllByTime(ctx context.Context, start, limit int) (*api.FileListResponse, error) {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rootPath := f.makePath("")
+	var result api.FileListResponse
+	err = f.callJSON(ctx, func() (string, *http.Response, error) {
+		return f.sdk.MultimediafileApi.Xpanfilelistall(ctx).
+			AccessToken(accessToken).
+			Path(rootPath).
+			Recursion(1).
+			Web("1").
+			Order("time").
+			Desc(1).
+			Start(int32(start)).
+			Limit(int32(limit)).
+			Execute()
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.ErrorCode != 0 {
+		return nil, &result.Error
+	}
+	return &result, nil
+}
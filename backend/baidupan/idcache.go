@@ -0,0 +1,237 @@
+package baidupan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// idRecord is what the idCache stores for each path
+type idRecord struct {
+	FsID   int64 `json:"fs_id"`
+	IsDir  bool  `json:"isdir"`
+	loaded bool  // set if read from the persistent store and not seen since
+}
+
+// idCache remembers the fs_id of absolute Baidu Pan paths
+//
+// It is shared by all the Fs for the same remote, as the paths are
+// absolute, and can optionally be persisted in a lib/kv database so
+// it survives restarts.
+//
+// The fs_ids of files are only used as a hint and are checked with
+// filemetas before being trusted, as are the fs_ids of directories
+// read from the persistent store.
+type idCache struct {
+	name  string              // name of the remote
+	users int                 // number of Fs using this - protected by idCachesMu
+	mu    sync.RWMutex        // protects the fields below
+	paths map[string]idRecord // absolute path to record
+	ids   map[int64]string    // fs_id to absolute path
+	db    *kv.DB              // persistent store - may be nil
+}
+
+var (
+	idCachesMu sync.Mutex
+	idCaches   = map[string]*idCache{}
+)
+
+// getIDCache returns the idCache for the remote f, making it if
+// necessary.
+//
+// Each call should be matched with a call to close.
+func getIDCache(ctx context.Context, f *Fs) (*idCache, error) {
+	idCachesMu.Lock()
+	defer idCachesMu.Unlock()
+	c := idCaches[f.name]
+	if c == nil {
+		c = &idCache{
+			name:  f.name,
+			paths: make(map[string]idRecord),
+			ids:   make(map[int64]string),
+		}
+	}
+	if f.opt.PersistDirCache && c.getDB() == nil {
+		if !kv.Supported() {
+			fs.Logf(f, "Can't persist the directory cache on this OS")
+		} else {
+			db, err := kv.Start(ctx, "baidupan", f)
+			if err != nil {
+				return nil, err
+			}
+			c.mu.Lock()
+			c.db = db
+			c.mu.Unlock()
+		}
+	}
+	idCaches[f.name] = c
+	c.users++
+	return c, nil
+}
+
+// getDB returns the persistent store or nil
+func (c *idCache) getDB() *kv.DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.db
+}
+
+// get the record for p
+func (c *idCache) get(p string) (rec idRecord, ok bool) {
+	c.mu.RLock()
+	rec, ok = c.paths[p]
+	db := c.db
+	c.mu.RUnlock()
+	if ok || db == nil {
+		return rec, ok
+	}
+	op := &kvGetID{key: p}
+	if err := db.Do(false, op); err != nil {
+		if !errors.Is(err, kv.ErrEmpty) && !errors.Is(err, errNoRecord) {
+			fs.Debugf(p, "dir cache read failed: %v", err)
+		}
+		return rec, false
+	}
+	op.rec.loaded = true
+	c.mu.Lock()
+	c.paths[p] = op.rec
+	c.ids[op.rec.FsID] = p
+	c.mu.Unlock()
+	return op.rec, true
+}
+
+// pathOf returns the path of the item with fs_id if known
+func (c *idCache) pathOf(fsid int64) (p string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok = c.ids[fsid]
+	return p, ok
+}
+
+// put the record for p
+func (c *idCache) put(p string, rec idRecord) {
+	c.putAll(map[string]idRecord{p: rec})
+}
+
+// putAll puts all the records in recs, by path, writing the changed
+// ones to the persistent store in one transaction
+func (c *idCache) putAll(recs map[string]idRecord) {
+	changed := make(map[string]idRecord, len(recs))
+	c.mu.Lock()
+	for p, rec := range recs {
+		if old, ok := c.paths[p]; ok && old == rec {
+			continue
+		}
+		c.paths[p] = rec
+		c.ids[rec.FsID] = p
+		changed[p] = rec
+	}
+	db := c.db
+	c.mu.Unlock()
+	if db == nil || len(changed) == 0 {
+		return
+	}
+	if err := db.Do(true, &kvPutIDs{recs: changed}); err != nil {
+		fs.Debugf(nil, "dir cache write of %d records failed: %v", len(changed), err)
+	}
+}
+
+// remove p and, if it is a directory, everything under it
+func (c *idCache) remove(p string) {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	c.mu.Lock()
+	for key, rec := range c.paths {
+		if key == p || strings.HasPrefix(key, prefix) {
+			delete(c.paths, key)
+			delete(c.ids, rec.FsID)
+		}
+	}
+	db := c.db
+	c.mu.Unlock()
+	if db != nil {
+		if err := db.Do(true, &kvRemoveID{key: p}); err != nil && !errors.Is(err, kv.ErrEmpty) {
+			fs.Debugf(p, "dir cache remove failed: %v", err)
+		}
+	}
+}
+
+// close releases the cache, closing the persistent store and
+// forgetting the cache when the last Fs using it is closed
+func (c *idCache) close() error {
+	idCachesMu.Lock()
+	defer idCachesMu.Unlock()
+	c.users--
+	if c.users > 0 {
+		return nil
+	}
+	if idCaches[c.name] == c {
+		delete(idCaches, c.name)
+	}
+	c.mu.Lock()
+	db := c.db
+	c.db = nil
+	c.mu.Unlock()
+	if db == nil || db.IsStopped() {
+		return nil
+	}
+	return db.Stop(false)
+}
+
+var errNoRecord = errors.New("no record")
+
+// kvGetID: get the record for a path
+type kvGetID struct {
+	key string
+	rec idRecord
+}
+
+func (op *kvGetID) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if len(data) == 0 {
+		return errNoRecord
+	}
+	return json.Unmarshal(data, &op.rec)
+}
+
+// kvPutIDs: set the records for some paths
+type kvPutIDs struct {
+	recs map[string]idRecord
+}
+
+func (op *kvPutIDs) Do(ctx context.Context, b kv.Bucket) error {
+	for p, rec := range op.recs {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte(p), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvRemoveID: remove a path and everything under it
+type kvRemoveID struct {
+	key string
+}
+
+func (op *kvRemoveID) Do(ctx context.Context, b kv.Bucket) error {
+	prefix := strings.TrimSuffix(op.key, "/") + "/"
+	keys := [][]byte{[]byte(op.key)}
+	cur := b.Cursor()
+	for bkey, _ := cur.Seek([]byte(prefix)); bkey != nil && strings.HasPrefix(string(bkey), prefix); bkey, _ = cur.Next() {
+		keys = append(keys, append([]byte(nil), bkey...))
+	}
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package baidupan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// thumbnailSizes maps the names of the thumbnail sizes to the keys
+// filemetas uses for them
+var thumbnailSizes = map[string]string{
+	"small":  "url1",
+	"medium": "url2",
+	"large":  "url3",
+}
+
+var systemMetadataInfo = map[string]fs.MetadataHelp{
+	"mtime": {
+		Help:     "Time of last modification on the server.",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05Z07:00",
+		ReadOnly: true,
+	},
+	"btime": {
+		Help:     "Time the file was created on the server.",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05Z07:00",
+		ReadOnly: true,
+	},
+	"category": {
+		Help:     "Category Baidu Pan has put the file in: video, audio, image, document, application, torrent or other.",
+		Type:     "string",
+		Example:  "video",
+		ReadOnly: true,
+	},
+	"duration": {
+		Help:     "Duration of audio and video files in seconds.",
+		Type:     "int",
+		Example:  "245",
+		ReadOnly: true,
+	},
+	"width": {
+		Help:     "Width of images and videos in pixels.",
+		Type:     "int",
+		Example:  "1920",
+		ReadOnly: true,
+	},
+	"height": {
+		Help:     "Height of images and videos in pixels.",
+		Type:     "int",
+		Example:  "1080",
+		ReadOnly: true,
+	},
+	"date-taken": {
+		Help:     "Time a photo was taken.",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05Z07:00",
+		ReadOnly: true,
+	},
+	"thumbnail-small": {
+		Help:     "URL of a small thumbnail of images and videos. This expires after a few hours.",
+		Type:     "string",
+		Example:  "https://thumbnail0.baidupcs.com/thumbnail/...",
+		ReadOnly: true,
+	},
+	"thumbnail-medium": {
+		Help:     "URL of a medium thumbnail of images and videos. This expires after a few hours.",
+		Type:     "string",
+		Example:  "https://thumbnail0.baidupcs.com/thumbnail/...",
+		ReadOnly: true,
+	},
+	"thumbnail-large": {
+		Help:     "URL of a large thumbnail of images and videos. This expires after a few hours.",
+		Type:     "string",
+		Example:  "https://thumbnail0.baidupcs.com/thumbnail/...",
+		ReadOnly: true,
+	},
+}
+
+const metadataHelp = `Baidu Pan indexes media files on the server, so the duration and
+resolution of audio, video and image files can be read without
+downloading them. Which keys are set depends on the category of the file.
+
+Reading the metadata needs an extra API call per file.
+
+Metadata can't be written.`
+
+// parseMetadata converts the result of filemetas into fs.Metadata
+func parseMetadata(meta *api.FileMeta) fs.Metadata {
+	m := fs.Metadata{
+		"mtime": time.Unix(meta.ServerMtime, 0).Format(time.RFC3339),
+		"btime": time.Unix(meta.ServerCtime, 0).Format(time.RFC3339),
+	}
+	if name, ok := api.CategoryNames[meta.Category]; ok {
+		m["category"] = name
+	}
+	width, height := meta.Width, meta.Height
+	if info := meta.MediaInfo; info != nil {
+		if info.Duration > 0 {
+			m["duration"] = strconv.FormatInt(info.Duration, 10)
+		}
+		if info.Width > 0 && info.Height > 0 {
+			width, height = info.Width, info.Height
+		}
+	}
+	if width > 0 && height > 0 {
+		m["width"] = strconv.Itoa(width)
+		m["height"] = strconv.Itoa(height)
+	}
+	if meta.DateTaken > 0 {
+		m["date-taken"] = time.Unix(meta.DateTaken, 0).Format(time.RFC3339)
+	}
+	for size, key := range thumbnailSizes {
+		if u := meta.Thumbs[key]; u != "" {
+			m["thumbnail-"+size] = u
+		}
+	}
+	return m
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	meta, err := o.fileMetas(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadata(meta), nil
+}
+
+// thumbnail is the implementation of the thumbnail command
+//
+// It fetches the thumbnail of the file arg[0] into the local file
+// arg[1], or returns its URL if there is no arg[1].
+func (f *Fs) thumbnail(ctx context.Context, arg []string, opt map[string]string) (out any, err error) {
+	if len(arg) < 1 || len(arg) > 2 {
+		return nil, errors.New("need a file and optionally a local file to write the thumbnail to")
+	}
+	size := "large"
+	if s, ok := opt["size"]; ok {
+		size = s
+	}
+	key, ok := thumbnailSizes[size]
+	if !ok {
+		return nil, fmt.Errorf("unknown thumbnail size %q - use small, medium or large", size)
+	}
+	obj, err := f.NewObject(ctx, arg[0])
+	if err != nil {
+		return nil, err
+	}
+	meta, err := obj.(*Object).fileMetas(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+	thumbURL := meta.Thumbs[key]
+	if thumbURL == "" {
+		return nil, fmt.Errorf("%q has no thumbnail", arg[0])
+	}
+	if len(arg) == 1 {
+		return thumbURL, nil
+	}
+
+	opts := rest.Opts{
+		Method:  "GET",
+		RootURL: thumbURL,
+		ExtraHeaders: map[string]string{
+			"User-Agent": "pan.baidu.com",
+		},
+	}
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.Call(ctx, &opts)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch thumbnail: %w", err)
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	file, err := os.Create(arg[1])
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(file, &err)
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return nil, fmt.Errorf("couldn't write thumbnail: %w", err)
+	}
+	return nil, nil
+}
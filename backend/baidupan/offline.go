@@ -0,0 +1,296 @@
+package baidupan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// Baidu Pan can fetch http(s), ftp, ed2k and magnet links into the
+// netdisk itself using the cloud_dl API. Tasks run in the background
+// on Baidu's servers and are identified by a task_id.
+//
+// cloud_dl isn't part of the open API and only accepts the app_id of
+// apps Baidu has allowed to use it, so this is only enabled if the
+// user supplies one with --baidupan-offline-app-id.
+
+const (
+	// how often to check on a task addurl -o wait is waiting for
+	offlinePollInterval = 5 * time.Second
+	// number of tasks to fetch per page of list_task
+	offlineListLimit = 100
+)
+
+var errorNoOfflineAppID = errors.New("offline downloads need --baidupan-offline-app-id")
+
+// offlineTask is a task as shown to the user
+type offlineTask struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	Size         int64     `json:"size"`
+	FinishedSize int64     `json:"finished_size"`
+	SavePath     string    `json:"save_path"`
+	SourceURL    string    `json:"source_url,omitempty"`
+	Created      time.Time `json:"created"`
+	Finished     time.Time `json:"finished,omitzero"`
+}
+
+// offlineStatusText describes the status of a task
+func offlineStatusText(status string) string {
+	switch status {
+	case api.OfflineStatusSuccess:
+		return "success"
+	case api.OfflineStatusRunning:
+		return "running"
+	case api.OfflineStatusSystemError:
+		return "system error"
+	case api.OfflineStatusNotFound:
+		return "resource not found"
+	case api.OfflineStatusTimeout:
+		return "timed out"
+	case api.OfflineStatusFailed:
+		return "download failed"
+	case api.OfflineStatusNoSpace:
+		return "not enough space"
+	case api.OfflineStatusTargetExists:
+		return "target exists"
+	case api.OfflineStatusCancelled:
+		return "cancelled"
+	}
+	return "unknown status " + status
+}
+
+// newOfflineTask converts an api.OfflineTask for output
+func newOfflineTask(id string, task *api.OfflineTask) offlineTask {
+	if task.TaskID != "" {
+		id = task.TaskID
+	}
+	parseInt := func(s string) int64 {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		return i
+	}
+	parseTime := func(s string) time.Time {
+		if t := parseInt(s); t > 0 {
+			return time.Unix(t, 0)
+		}
+		return time.Time{}
+	}
+	return offlineTask{
+		ID:           parseInt(id),
+		Name:         task.TaskName,
+		Status:       offlineStatusText(task.Status),
+		Size:         parseInt(task.FileSize),
+		FinishedSize: parseInt(task.FinishedSize),
+		SavePath:     task.SavePath,
+		SourceURL:    task.SourceURL,
+		Created:      parseTime(task.CreateTime),
+		Finished:     parseTime(task.FinishTime),
+	}
+}
+
+// callOffline calls cloud_dl method with params, decoding the response
+// into result. apiErr should point to the api.OfflineError in result.
+func (f *Fs) callOffline(ctx context.Context, method string, params url.Values, result any, apiErr *api.OfflineError) error {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	params.Set("method", method)
+	if f.opt.OfflineAppID == "" {
+		return errorNoOfflineAppID
+	}
+	params.Set("app_id", f.opt.OfflineAppID)
+	params.Set("access_token", accessToken)
+	opts := rest.Opts{
+		Method:     "POST",
+		Path:       api.PathOffline,
+		Parameters: params,
+	}
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return err
+	}
+	if apiErr.ErrorCode != 0 {
+		return apiErr
+	}
+	return nil
+}
+
+// addOfflineTask asks Baidu to download sourceURL into the absolute
+// directory saveDir
+//
+// For magnet links selected is the 1 based indexes of the files to
+// download - all of them if empty.
+func (f *Fs) addOfflineTask(ctx context.Context, sourceURL, saveDir string, selected []int) (*api.OfflineAddResponse, error) {
+	params := url.Values{
+		"source_url": {sourceURL},
+		"save_path":  {saveDir},
+	}
+	if strings.HasPrefix(sourceURL, "magnet:") {
+		if len(selected) == 0 {
+			var info api.OfflineMagnetResponse
+			err := f.callOffline(ctx, "query_magnetinfo", url.Values{
+				"source_url": {sourceURL},
+				"save_path":  {saveDir},
+			}, &info, &info.OfflineError)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read magnet link: %w", err)
+			}
+			for i := range info.Total {
+				selected = append(selected, i+1)
+			}
+		}
+		idx := make([]string, len(selected))
+		for i, n := range selected {
+			idx[i] = strconv.Itoa(n)
+		}
+		params.Set("type", "4")
+		params.Set("selected_idx", strings.Join(idx, ","))
+	}
+	var result api.OfflineAddResponse
+	if err := f.callOffline(ctx, "add_task", params, &result, &result.OfflineError); err != nil {
+		return nil, fmt.Errorf("couldn't add offline download: %w", err)
+	}
+	return &result, nil
+}
+
+// queryOfflineTasks reads the tasks with the given ids
+func (f *Fs) queryOfflineTasks(ctx context.Context, ids []string) ([]offlineTask, error) {
+	var result api.OfflineQueryResponse
+	err := f.callOffline(ctx, "query_task", url.Values{
+		"task_ids": {strings.Join(ids, ",")},
+		"op_type":  {"1"},
+	}, &result, &result.OfflineError)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query offline downloads: %w", err)
+	}
+	tasks := make([]offlineTask, 0, len(result.TaskInfo))
+	for id, task := range result.TaskInfo {
+		tasks = append(tasks, newOfflineTask(id, &task))
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks, nil
+}
+
+// listOfflineTasks lists all the offline download tasks, newest first
+func (f *Fs) listOfflineTasks(ctx context.Context) (tasks []offlineTask, err error) {
+	for start := 0; ; start += offlineListLimit {
+		var result api.OfflineListResponse
+		err = f.callOffline(ctx, "list_task", url.Values{
+			"start":          {strconv.Itoa(start)},
+			"limit":          {strconv.Itoa(offlineListLimit)},
+			"asc":            {"0"},
+			"need_task_info": {"1"},
+			"status":         {"255"}, // all tasks
+		}, &result, &result.OfflineError)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list offline downloads: %w", err)
+		}
+		for _, task := range result.TaskInfo {
+			tasks = append(tasks, newOfflineTask("", &task))
+		}
+		if len(result.TaskInfo) < offlineListLimit || start+len(result.TaskInfo) >= result.Total {
+			break
+		}
+	}
+	return tasks, nil
+}
+
+// cancelOfflineTask cancels the task with id
+func (f *Fs) cancelOfflineTask(ctx context.Context, id int64) error {
+	var result api.OfflineError
+	return f.callOffline(ctx, "cancel_task", url.Values{
+		"task_id": {strconv.FormatInt(id, 10)},
+	}, &result, &result)
+}
+
+// waitOfflineTask polls the task with id until it finishes
+func (f *Fs) waitOfflineTask(ctx context.Context, id int64) error {
+	ticker := time.NewTicker(offlinePollInterval)
+	defer ticker.Stop()
+	for {
+		tasks, err := f.queryOfflineTasks(ctx, []string{strconv.FormatInt(id, 10)})
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 1 {
+			return fmt.Errorf("offline download task %d not found", id)
+		}
+		task := tasks[0]
+		switch task.Status {
+		case offlineStatusText(api.OfflineStatusSuccess):
+			return nil
+		case offlineStatusText(api.OfflineStatusRunning):
+			fs.Debugf(f, "Offline download task %d: %d/%d bytes", id, task.FinishedSize, task.Size)
+		default:
+			return fmt.Errorf("offline download task %d failed: %s", id, task.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// offlineAdd is the implementation of the addurl command
+func (f *Fs) offlineAdd(ctx context.Context, arg []string, opt map[string]string) (any, error) {
+	if len(arg) != 1 {
+		return nil, errors.New("need exactly 1 URL to add")
+	}
+	var selected []int
+	if s, ok := opt["select"]; ok {
+		for _, field := range strings.Split(s, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid file index %q in select", field)
+			}
+			selected = append(selected, n)
+		}
+	}
+	if err := f.Mkdir(ctx, ""); err != nil {
+		return nil, err
+	}
+	result, err := f.addOfflineTask(ctx, arg[0], f.makePath(""), selected)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := opt["wait"]; ok {
+		fs.Debugf(f, "Waiting for offline download task %d for %q", result.TaskID, arg[0])
+		if err := f.waitOfflineTask(ctx, result.TaskID); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]any{
+		"id":             result.TaskID,
+		"rapid_download": result.RapidDownload == 1,
+	}, nil
+}
+
+// offlineStatus is the implementation of the offline-status command
+func (f *Fs) offlineStatus(ctx context.Context, arg []string) (any, error) {
+	if len(arg) == 0 {
+		return f.listOfflineTasks(ctx)
+	}
+	for _, a := range arg {
+		if _, err := strconv.ParseInt(a, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid task id %q: %w", a, err)
+		}
+	}
+	return f.queryOfflineTasks(ctx, arg)
+}
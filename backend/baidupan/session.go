@@ -0,0 +1,221 @@
+package baidupan
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/lib/encoder"
+)
+
+const (
+	sessionDirMode  = 0700
+	sessionFileMode = 0600
+	// Baidu expires upload IDs after a while so don't try to
+	// resume sessions older than this.
+	sessionMaxAge = 24 * time.Hour
+)
+
+// uploadSession records the state of an in progress multipart upload
+// so that it can be resumed by a later Put or Update of the same
+// content.
+//
+// The session is persisted as a line of JSON written when the upload
+// starts, followed by the number of each block on its own line as it
+// is uploaded, so the block MD5s of big files aren't rewritten for
+// every block.
+type uploadSession struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ContentMD5 string    `json:"content_md5"`
+	BlockMD5s  []string  `json:"block_md5s"`
+	UploadID   string    `json:"upload_id"`
+	BlockList  []int     `json:"block_list"` // blocks precreate asked for
+	Created    time.Time `json:"created"`
+
+	mu         sync.Mutex
+	file       string           // where the session is persisted - "" for none
+	blocksDone map[int]struct{} // blocks uploaded so far
+}
+
+// sessionDir returns the directory upload sessions for this remote
+// are kept in
+func (f *Fs) sessionDir() string {
+	name := f.name
+	if idx := strings.Index(name, "{"); idx != -1 {
+		name = name[:idx]
+	}
+	return filepath.Join(config.GetCacheDir(), "baidupan", encoder.OS.FromStandardName(name), "uploads")
+}
+
+// sessionFile returns the file name the session for the given upload
+// is persisted in
+func (f *Fs) sessionFile(filePath string, size int64, contentMD5 string) string {
+	sum := md5.Sum(fmt.Appendf(nil, "%s\x00%d\x00%s", filePath, size, contentMD5))
+	return filepath.Join(f.sessionDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+// newUploadSession creates a session for an upload, persisting it if
+// resumable uploads are enabled.
+func (f *Fs) newUploadSession(filePath string, size int64, contentMD5 string, blockMD5s []string, uploadID string, blockList []int) *uploadSession {
+	s := &uploadSession{
+		Path:       filePath,
+		Size:       size,
+		ContentMD5: contentMD5,
+		BlockMD5s:  blockMD5s,
+		UploadID:   uploadID,
+		BlockList:  blockList,
+		Created:    time.Now(),
+		blocksDone: make(map[int]struct{}),
+	}
+	if f.opt.ResumeUploads {
+		s.file = f.sessionFile(filePath, size, contentMD5)
+		if err := s.save(); err != nil {
+			fs.Debugf(f, "Failed to save upload session for %q: %v", filePath, err)
+			s.file = ""
+		}
+	}
+	return s
+}
+
+// loadUploadSession finds a persisted session for this upload
+//
+// It returns nil if there isn't a usable session.
+func (f *Fs) loadUploadSession(filePath string, size int64, contentMD5 string, blockMD5s []string) *uploadSession {
+	if !f.opt.ResumeUploads {
+		return nil
+	}
+	file := f.sessionFile(filePath, size, contentMD5)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fs.Debugf(f, "Failed to read upload session for %q: %v", filePath, err)
+		}
+		return nil
+	}
+	s := &uploadSession{file: file}
+	err = s.decode(data)
+	switch {
+	case err != nil:
+		fs.Debugf(f, "Discarding corrupt upload session for %q: %v", filePath, err)
+	case time.Since(s.Created) > sessionMaxAge:
+		fs.Debugf(f, "Discarding expired upload session for %q", filePath)
+	case s.Path != filePath || s.Size != size || s.ContentMD5 != contentMD5 || !slices.Equal(s.BlockMD5s, blockMD5s) || s.UploadID == "":
+		fs.Debugf(f, "Discarding mismatched upload session for %q", filePath)
+	default:
+		// Drop a partly written block number so more can be
+		// appended
+		if n := bytes.LastIndexByte(data, '\n') + 1; n < len(data) {
+			if err := os.Truncate(file, int64(n)); err != nil {
+				fs.Debugf(f, "Failed to truncate upload session for %q: %v", filePath, err)
+				s.file = ""
+			}
+		}
+		return s
+	}
+	s.remove()
+	return nil
+}
+
+// decode reads a persisted session from data
+func (s *uploadSession) decode(data []byte) error {
+	header, blocks, _ := bytes.Cut(data, []byte("\n"))
+	err := json.Unmarshal(header, s)
+	if err != nil {
+		return err
+	}
+	s.blocksDone = make(map[int]struct{})
+	for {
+		// Stop at a line without a newline as rclone may have
+		// been stopped while writing it.
+		line, rest, found := bytes.Cut(blocks, []byte("\n"))
+		if !found {
+			break
+		}
+		blockNum, err := strconv.Atoi(string(line))
+		if err != nil {
+			return fmt.Errorf("bad block number %q: %w", line, err)
+		}
+		s.blocksDone[blockNum] = struct{}{}
+		blocks = rest
+	}
+	return nil
+}
+
+// save persists the session if it has a file
+//
+// Call before the session is shared.
+func (s *uploadSession) save() error {
+	if s.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := os.MkdirAll(filepath.Dir(s.file), sessionDirMode); err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, sessionFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// remaining returns the blocks which still need uploading
+func (s *uploadSession) remaining() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var todo []int
+	for _, blockNum := range s.BlockList {
+		if _, done := s.blocksDone[blockNum]; !done {
+			todo = append(todo, blockNum)
+		}
+	}
+	return todo
+}
+
+// done marks blockNum as uploaded and appends it to the persisted
+// session
+func (s *uploadSession) done(blockNum int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocksDone[blockNum] = struct{}{}
+	if s.file == "" {
+		return
+	}
+	out, err := os.OpenFile(s.file, os.O_WRONLY|os.O_APPEND, sessionFileMode)
+	if err == nil {
+		_, err = fmt.Fprintf(out, "%d\n", blockNum)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fs.Debugf(s.Path, "Failed to save upload session: %v", err)
+	}
+}
+
+// remove deletes the persisted session
+func (s *uploadSession) remove() {
+	if s.file == "" {
+		return
+	}
+	if err := os.Remove(s.file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fs.Debugf(s.Path, "Failed to remove upload session: %v", err)
+	}
+}
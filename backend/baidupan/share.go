@@ -0,0 +1,461 @@
+package baidupan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// Share links look like https://pan.baidu.com/s/1AbCd?pwd=wxyz where
+// "1AbCd" is the short url and "wxyz" the extraction code. The share
+// APIs mostly want the short url without its leading "1", which is
+// called the surl here.
+
+const (
+	// number of items to fetch per page of the share APIs
+	shareListLimit = 100
+	// base of the links to shares
+	shareLinkURL = "https://pan.baidu.com/s/"
+)
+
+// sharePeriods are the expiry times, in days, Baidu allows for share
+// links. 0 means the link never expires.
+var sharePeriods = []int{1, 7, 30, 365}
+
+// sharePeriod converts an expiry time into the period Baidu uses,
+// rounding up to the next period it supports.
+func sharePeriod(expire fs.Duration) string {
+	if expire >= fs.DurationOff || expire <= 0 {
+		return "0"
+	}
+	days := int(math.Ceil(time.Duration(expire).Hours() / 24))
+	for _, period := range sharePeriods {
+		if days <= period {
+			return strconv.Itoa(period)
+		}
+	}
+	fs.Logf(nil, "Baidu Pan share links can't expire after %d days - making a permanent link", sharePeriods[len(sharePeriods)-1])
+	return "0"
+}
+
+// shareRef identifies a share link
+type shareRef struct {
+	surl string // short url without the leading "1"
+	pwd  string // extraction code - may be empty
+}
+
+// parseShareURL reads a share link in one of the forms
+//
+//	https://pan.baidu.com/s/1AbCd?pwd=wxyz
+//	https://pan.baidu.com/share/init?surl=AbCd
+//	1AbCd
+//
+// pwd overrides any extraction code in the link if set.
+func parseShareURL(link, pwd string) (ref shareRef, err error) {
+	if !strings.Contains(link, "/") {
+		link = "/s/" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ref, fmt.Errorf("invalid share link %q: %w", link, err)
+	}
+	switch {
+	case strings.HasPrefix(u.Path, "/s/"):
+		ref.surl = strings.TrimPrefix(strings.TrimPrefix(u.Path, "/s/"), "1")
+	case u.Path == "/share/init":
+		ref.surl = u.Query().Get("surl")
+	}
+	if ref.surl == "" || strings.Contains(ref.surl, "/") {
+		return ref, fmt.Errorf("invalid share link %q", link)
+	}
+	ref.pwd = u.Query().Get("pwd")
+	if pwd != "" {
+		ref.pwd = pwd
+	}
+	return ref, nil
+}
+
+// shareSession is a share that has been opened for reading
+type shareSession struct {
+	shareRef
+	sekey   string // key from verify - empty if no extraction code
+	shareID int64
+	uk      int64
+}
+
+// callShare calls one of the share APIs, decoding the response into
+// result. apiErr should point to the api.Error in result.
+func (f *Fs) callShare(ctx context.Context, opts *rest.Opts, result any, apiErr *api.Error) error {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.Parameters == nil {
+		opts.Parameters = url.Values{}
+	}
+	opts.Parameters.Set("access_token", accessToken)
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, opts, nil, result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return err
+	}
+	if apiErr.ErrorCode != 0 {
+		return apiErr
+	}
+	return nil
+}
+
+// openShare checks the extraction code of a share and reads its ids
+func (f *Fs) openShare(ctx context.Context, ref shareRef) (*shareSession, error) {
+	s := &shareSession{shareRef: ref}
+	if ref.pwd != "" {
+		var verify api.ShareVerifyResponse
+		err := f.callShare(ctx, &rest.Opts{
+			Method:     "POST",
+			Path:       api.PathShareVerify,
+			Parameters: url.Values{"surl": {ref.surl}},
+			MultipartParams: url.Values{
+				"pwd": {ref.pwd},
+			},
+		}, &verify, &verify.Error)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't verify share extraction code: %w", err)
+		}
+		s.sekey = verify.Randsk
+	}
+	// The root listing tells us the share_id and uk
+	result, err := f.listSharePage(ctx, s, "", 1)
+	if err != nil {
+		return nil, err
+	}
+	s.shareID, s.uk = result.ShareID, result.UK
+	return s, nil
+}
+
+// listSharePage reads a page of the directory dir of the share,
+// which is the root if dir is empty
+func (f *Fs) listSharePage(ctx context.Context, s *shareSession, dir string, page int) (*api.ShareListResponse, error) {
+	params := url.Values{
+		"shorturl": {s.surl},
+		"page":     {strconv.Itoa(page)},
+		"num":      {strconv.Itoa(shareListLimit)},
+		"order":    {"name"},
+	}
+	if dir == "" {
+		params.Set("root", "1")
+	} else {
+		params.Set("dir", dir)
+	}
+	if s.sekey != "" {
+		params.Set("sekey", s.sekey)
+	}
+	var result api.ShareListResponse
+	err := f.callShare(ctx, &rest.Opts{
+		Method:     "GET",
+		Path:       api.PathShareList,
+		Parameters: params,
+	}, &result, &result.Error)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list share: %w", err)
+	}
+	return &result, nil
+}
+
+// listShareDir lists all the items in the directory dir of the share
+//
+// dir is the sharer's path as returned in api.ShareFile.Path or empty
+// for the root.
+func (f *Fs) listShareDir(ctx context.Context, s *shareSession, dir string) (items []api.ShareFile, err error) {
+	for page := 1; ; page++ {
+		result, err := f.listSharePage(ctx, s, dir, page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.List...)
+		if len(result.List) < shareListLimit {
+			break
+		}
+	}
+	return items, nil
+}
+
+// findShareItem finds the item at the path p relative to the root of
+// the share. It returns nil for the root.
+func (f *Fs) findShareItem(ctx context.Context, s *shareSession, p string) (*api.ShareFile, error) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil, nil
+	}
+	var item *api.ShareFile
+	for leaf := range strings.SplitSeq(p, "/") {
+		if item != nil && item.Isdir != api.FileTypeFolder {
+			return nil, fmt.Errorf("%q in share: %w", p, fs.ErrorIsFile)
+		}
+		dir := ""
+		if item != nil {
+			dir = item.Path
+		}
+		items, err := f.listShareDir(ctx, s, dir)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(items, func(item api.ShareFile) bool {
+			return item.ServerFilename == leaf
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("%q in share: %w", p, fs.ErrorObjectNotFound)
+		}
+		item = &items[i]
+	}
+	return item, nil
+}
+
+// shareItem is an item in someone else's share as shown to the user
+type shareItem struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"isdir"`
+	ModTime time.Time `json:"modtime"`
+	FsID    int64     `json:"fs_id"`
+}
+
+// shareList is the implementation of the share-list command
+func (f *Fs) shareList(ctx context.Context, arg []string, opt map[string]string) (any, error) {
+	if len(arg) != 1 {
+		return nil, errors.New("need exactly 1 share link")
+	}
+	ref, err := parseShareURL(arg[0], opt["pwd"])
+	if err != nil {
+		return nil, err
+	}
+	s, err := f.openShare(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	dir := opt["dir"]
+	item, err := f.findShareItem(ctx, s, dir)
+	if err != nil {
+		return nil, err
+	}
+	sharerDir := ""
+	if item != nil {
+		if item.Isdir != api.FileTypeFolder {
+			return nil, fmt.Errorf("%q in share: %w", dir, fs.ErrorIsFile)
+		}
+		sharerDir = item.Path
+	}
+	items, err := f.listShareDir(ctx, s, sharerDir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]shareItem, len(items))
+	for i, item := range items {
+		out[i] = shareItem{
+			Path:    path.Join(dir, item.ServerFilename),
+			Size:    item.Size,
+			IsDir:   item.Isdir == api.FileTypeFolder,
+			ModTime: time.Unix(item.ServerMtime, 0),
+			FsID:    item.FsID,
+		}
+	}
+	return out, nil
+}
+
+// shareImport is the implementation of the share-import command
+//
+// It copies the items in the share given by the paths in arg[1:], or
+// the whole share if there are none, into the root of f.
+func (f *Fs) shareImport(ctx context.Context, arg []string, opt map[string]string) (any, error) {
+	if len(arg) == 0 {
+		return nil, errors.New("need a share link")
+	}
+	ref, err := parseShareURL(arg[0], opt["pwd"])
+	if err != nil {
+		return nil, err
+	}
+	s, err := f.openShare(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	var fsids []int64
+	if len(arg) == 1 {
+		items, err := f.listShareDir(ctx, s, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			fsids = append(fsids, item.FsID)
+		}
+	} else {
+		for _, p := range arg[1:] {
+			item, err := f.findShareItem(ctx, s, p)
+			if err != nil {
+				return nil, err
+			}
+			if item == nil {
+				return nil, errors.New("use no paths to import the whole share")
+			}
+			fsids = append(fsids, item.FsID)
+		}
+	}
+	if len(fsids) == 0 {
+		return nil, errors.New("share is empty")
+	}
+	if err := f.Mkdir(ctx, ""); err != nil {
+		return nil, err
+	}
+	fsidList, err := json.Marshal(fsids)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{
+		"shareid": {strconv.FormatInt(s.shareID, 10)},
+		"from":    {strconv.FormatInt(s.uk, 10)},
+		"ondup":   {"newcopy"},
+	}
+	if s.sekey != "" {
+		params.Set("sekey", s.sekey)
+	}
+	var result api.Error
+	err = f.callShare(ctx, &rest.Opts{
+		Method:     "POST",
+		Path:       api.PathShareTransfer,
+		Parameters: params,
+		MultipartParams: url.Values{
+			"fsidlist": {string(fsidList)},
+			"path":     {f.makePath("")},
+		},
+	}, &result, &result)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't import share: %w", err)
+	}
+	f.dirCache.FlushDir("")
+	return nil, nil
+}
+
+// listShareRecords lists the user's own share links
+func (f *Fs) listShareRecords(ctx context.Context) (records []api.ShareRecord, err error) {
+	for page := 1; ; page++ {
+		var result api.ShareRecordResponse
+		err = f.callShare(ctx, &rest.Opts{
+			Method: "GET",
+			Path:   api.PathShareRecord,
+			Parameters: url.Values{
+				"page":  {strconv.Itoa(page)},
+				"num":   {strconv.Itoa(shareListLimit)},
+				"order": {"ctime"},
+				"desc":  {"1"},
+			},
+		}, &result, &result.Error)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list shares: %w", err)
+		}
+		records = append(records, result.List...)
+		if len(result.List) < shareListLimit {
+			break
+		}
+	}
+	return records, nil
+}
+
+// cancelShares cancels the user's own share links with the given ids
+func (f *Fs) cancelShares(ctx context.Context, shareIDs []int64) error {
+	idList, err := json.Marshal(shareIDs)
+	if err != nil {
+		return err
+	}
+	var result api.Error
+	err = f.callShare(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   api.PathShareCancel,
+		MultipartParams: url.Values{
+			"shareid_list": {string(idList)},
+		},
+	}, &result, &result)
+	if err != nil {
+		return fmt.Errorf("couldn't cancel shares: %w", err)
+	}
+	return nil
+}
+
+// unshare cancels all the share links of the item with fsid
+func (f *Fs) unshare(ctx context.Context, fsid int64) error {
+	records, err := f.listShareRecords(ctx)
+	if err != nil {
+		return err
+	}
+	var shareIDs []int64
+	for _, record := range records {
+		if slices.Contains(record.FsIDs, fsid) {
+			shareIDs = append(shareIDs, record.ShareID)
+		}
+	}
+	if len(shareIDs) == 0 {
+		return errors.New("no share links found")
+	}
+	return f.cancelShares(ctx, shareIDs)
+}
+
+// share is one of the user's own share links as shown to the user
+type share struct {
+	ID       int64     `json:"id"`
+	Link     string    `json:"link"`
+	Password string    `json:"password,omitempty"`
+	Path     string    `json:"path"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitzero"`
+}
+
+// shares is the implementation of the shares command
+func (f *Fs) shares(ctx context.Context) (any, error) {
+	records, err := f.listShareRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]share, len(records))
+	for i, record := range records {
+		out[i] = share{
+			ID:       record.ShareID,
+			Link:     shareLinkURL + record.ShortURL,
+			Password: record.Passwd,
+			Path:     record.TypicalPath,
+			Created:  time.Unix(record.Ctime, 0),
+		}
+		if record.ExpiredTime > 0 {
+			out[i].Expires = time.Unix(record.ExpiredTime, 0)
+		}
+	}
+	return out, nil
+}
+
+// shareCancel is the implementation of the share-cancel command
+func (f *Fs) shareCancel(ctx context.Context, arg []string) (any, error) {
+	if len(arg) == 0 {
+		return nil, errors.New("need at least 1 share id to cancel")
+	}
+	shareIDs := make([]int64, len(arg))
+	for i, a := range arg {
+		id, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid share id %q: %w", a, err)
+		}
+		shareIDs[i] = id
+	}
+	return nil, f.cancelShares(ctx, shareIDs)
+}
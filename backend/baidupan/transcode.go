@@ -0,0 +1,222 @@
+package baidupan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// Baidu transcodes videos and audio on request and serves them as HLS
+// streams. The streams aren't throttled like downloads of the raw
+// file, so they are useful for playback. Accounts without a membership
+// must wait for an advert to "play" before the stream is served: the
+// first request returns an adToken and how long to wait, then the
+// request is repeated with the adToken.
+
+const (
+	// errno returned when the adToken is needed
+	errnoTranscodeAdvert = 133
+	// errno returned while Baidu is still transcoding the file
+	errnoTranscoding = 31341
+	// how long to wait between tries while the file is transcoding
+	transcodeRetryInterval = 2 * time.Second
+	// how many times to try while the file is transcoding
+	transcodeRetries = 15
+	// default stream type
+	defaultTranscodeType = "M3U8_AUTO_720"
+	// User-Agent the streaming API wants
+	transcodeUserAgent = "xpanvideo;netdisk;iPhone13;ios-iphone;15.1;ts"
+)
+
+// transcodeTypes maps the names of the resolutions to stream types
+var transcodeTypes = map[string]string{
+	"480":  "M3U8_AUTO_480",
+	"720":  "M3U8_AUTO_720",
+	"1080": "M3U8_AUTO_1080",
+	"mp3":  "M3U8_MP3_128",
+}
+
+// TranscodeOption can be passed to Object.Open to read the M3U8
+// playlist of a transcoded HLS stream of the file instead of its
+// contents.
+type TranscodeOption struct {
+	Type string // stream type, e.g. M3U8_AUTO_720 - "" for the default
+}
+
+// Header formats the option as an http header
+func (o *TranscodeOption) Header() (key string, value string) {
+	return "", ""
+}
+
+// String formats the option into human-readable form
+func (o *TranscodeOption) String() string {
+	return fmt.Sprintf("TranscodeOption(%q)", o.Type)
+}
+
+// Mandatory returns whether the option must be parsed or can be ignored
+func (o *TranscodeOption) Mandatory() bool {
+	return false
+}
+
+// streamingResponse is the JSON returned by the streaming API when it
+// doesn't return a playlist
+type streamingResponse struct {
+	api.Error
+	AdTime  int    `json:"adTime"` // seconds to wait before using AdToken
+	AdToken string `json:"adToken"`
+}
+
+// parseTranscodeType converts a resolution name or stream type into a
+// stream type
+func parseTranscodeType(s string) (string, error) {
+	if s == "" {
+		return defaultTranscodeType, nil
+	}
+	if t, ok := transcodeTypes[strings.ToLower(s)]; ok {
+		return t, nil
+	}
+	if strings.HasPrefix(s, "M3U8_") {
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown transcode type %q - use 480, 720, 1080, mp3 or a M3U8_* stream type", s)
+}
+
+// streamOnce asks for the playlist of the file at the absolute path
+// filePath. It returns the playlist or the JSON response if there
+// wasn't one.
+func (f *Fs) streamOnce(ctx context.Context, filePath, streamType, adToken string) (playlist []byte, result *streamingResponse, err error) {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := url.Values{
+		"method":       {"streaming"},
+		"access_token": {accessToken},
+		"path":         {filePath},
+		"type":         {streamType},
+	}
+	if adToken != "" {
+		params.Set("adToken", adToken)
+	}
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       api.PathFileList,
+		Parameters: params,
+		ExtraHeaders: map[string]string{
+			"User-Agent": transcodeUserAgent,
+		},
+		IgnoreStatus: true,
+	}
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.Call(ctx, &opts)
+		if err != nil {
+			return f.shouldRetry(ctx, resp, err)
+		}
+		playlist, err = rest.ReadBody(resp)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(playlist), []byte("#EXTM3U")) {
+		return playlist, nil, nil
+	}
+	result = new(streamingResponse)
+	if err = json.Unmarshal(playlist, result); err != nil {
+		return nil, nil, fmt.Errorf("unexpected response from streaming API (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if result.ErrorCode == 0 {
+		return nil, nil, fmt.Errorf("no playlist returned from streaming API (HTTP %d)", resp.StatusCode)
+	}
+	return nil, result, nil
+}
+
+// transcode returns the M3U8 playlist of the file at the absolute path
+// filePath transcoded to streamType, waiting for the advert and for
+// Baidu to transcode the file if necessary.
+func (f *Fs) transcode(ctx context.Context, filePath, streamType string) ([]byte, error) {
+	adToken := ""
+	for try := 1; ; try++ {
+		playlist, result, err := f.streamOnce(ctx, filePath, streamType, adToken)
+		if err != nil {
+			return nil, err
+		}
+		if playlist != nil {
+			return playlist, nil
+		}
+		var wait time.Duration
+		switch {
+		case result.ErrorCode == errnoTranscodeAdvert && adToken == "" && result.AdToken != "":
+			adToken = result.AdToken
+			wait = time.Duration(result.AdTime) * time.Second
+			fs.Debugf(f, "Waiting %v before streaming %q", wait, filePath)
+		case result.ErrorCode == errnoTranscoding && try < transcodeRetries:
+			wait = transcodeRetryInterval
+			fs.Debugf(f, "Waiting for %q to be transcoded", filePath)
+		default:
+			return nil, fmt.Errorf("couldn't transcode: %w", &result.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// openTranscoded returns the playlist of the object transcoded to
+// streamType
+func (o *Object) openTranscoded(ctx context.Context, streamType string) (io.ReadCloser, error) {
+	streamType, err := parseTranscodeType(streamType)
+	if err != nil {
+		return nil, err
+	}
+	// Read the path from the server as the remote may not be the real
+	// path, e.g. in a {category} root
+	meta, err := o.getFileMeta(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := o.fs.transcode(ctx, meta.Path, streamType)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(playlist)), nil
+}
+
+// transcodeCommand is the implementation of the transcode command
+func (f *Fs) transcodeCommand(ctx context.Context, arg []string, opt map[string]string) (any, error) {
+	if len(arg) != 1 {
+		return nil, errors.New("need exactly 1 file to transcode")
+	}
+	streamType := opt["type"]
+	if streamType == "" {
+		streamType = opt["resolution"]
+	}
+	obj, err := f.NewObject(ctx, arg[0])
+	if err != nil {
+		return nil, err
+	}
+	in, err := obj.Open(ctx, &TranscodeOption{Type: streamType})
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := io.ReadAll(in)
+	_ = in.Close()
+	if err != nil {
+		return nil, err
+	}
+	return string(playlist), nil
+}
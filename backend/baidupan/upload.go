@@ -9,14 +9,24 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path"
 	"strconv"
+	"sync"
 
 	"github.com/rclone/rclone/backend/baidupan/api"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"golang.org/x/sync/errgroup"
 	xpansdk "open-sdk-go/openxpanapi"
 )
 
 // uploadFile uploads a file to Baidu Pan using multipart upload
+//
+// The block MD5s must be known before precreate so the input is read
+// once to hash it, spooling to disk if it is bigger than
+// hash_memory_limit, then the blocks which need uploading are read
+// back one at a time.
 func (f *Fs) uploadFile(ctx context.Context, in io.Reader, size int64, remote string, options ...fs.OpenOption) (*api.CreateFileResponse, error) {
 	filePath := f.makePath(remote)
 
@@ -24,33 +34,28 @@ func (f *Fs) uploadFile(ctx context.Context, in io.Reader, size int64, remote st
 		return nil, fmt.Errorf("cannot upload file with unknown size")
 	}
 
-	// Read the entire file into memory for small files, or use a buffer for large files
-	var buf bytes.Buffer
-	if size <= int64(f.opt.ChunkSize)*4 {
-		// For small files, read everything
-		if _, err := io.Copy(&buf, in); err != nil {
-			return nil, fmt.Errorf("failed to buffer file: %w", err)
-		}
-		in = &buf
-	} else {
-		// For large files, we need to use TeeReader to calculate hashes
-		if _, err := io.Copy(&buf, io.TeeReader(in, &buf)); err != nil {
-			return nil, fmt.Errorf("failed to buffer file: %w", err)
-		}
-		in = &buf
-	}
+	// Do the accounting as the blocks are uploaded rather than
+	// while the input is being hashed
+	in, acc := accounting.UnWrapAccounting(in)
 
-	// Calculate content MD5
-	contentMD5, err := calculateMD5(bytes.NewReader(buf.Bytes()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to calculate MD5: %w", err)
-	}
-
-	// Calculate block MD5s
+	// Calculate content MD5 and block MD5s
 	blockSize := int64(f.opt.ChunkSize)
-	blockMD5s, err := calculateBlockMD5s(bytes.NewReader(buf.Bytes()), size, blockSize)
+	contentMD5, blockMD5s, spool, cleanup, err := readBlockMD5s(in, size, blockSize, int64(f.opt.HashMemoryLimit))
+	defer cleanup()
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate block MD5s: %w", err)
+		return nil, fmt.Errorf("failed to calculate MD5s: %w", err)
+	}
+	fs.Debugf(f, "Upload %s: content MD5 %s, %d blocks", remote, contentMD5, len(blockMD5s))
+
+	// Resume a previous upload of the same content if we can
+	if session := f.loadUploadSession(filePath, size, contentMD5, blockMD5s); session != nil {
+		fs.Debugf(f, "Resuming upload of %s: %d/%d blocks left", remote, len(session.remaining()), len(session.BlockList))
+		result, err := f.uploadSessionBlocks(ctx, spool, session, acc)
+		if err == nil || ctx.Err() != nil {
+			return result, err
+		}
+		fs.Debugf(f, "Resumed upload of %s failed, restarting: %v", remote, err)
+		session.remove()
 	}
 
 	// Step 1: Precreate - check if rapid upload is possible
@@ -62,29 +67,93 @@ func (f *Fs) uploadFile(ctx context.Context, in io.Reader, size int64, remote st
 	// If rapid upload succeeded (return_type == 2), we're done
 	if precreateResp.ReturnType == 2 {
 		fs.Debugf(f, "Rapid upload succeeded for %s", remote)
+		if acc != nil {
+			acc.AccountReadN(size)
+		}
 		// Get file info by listing
 		return f.createFileFinish(ctx, filePath, size, precreateResp.UploadID, blockMD5s)
 	}
 
-	// Step 2: Upload blocks that need uploading
-	if len(precreateResp.BlockList) > 0 {
-		err = f.uploadBlocks(ctx, bytes.NewReader(buf.Bytes()), size, filePath, precreateResp.UploadID, precreateResp.BlockList, blockMD5s)
+	// Step 2 and 3: Upload blocks that need uploading then create file
+	session := f.newUploadSession(filePath, size, contentMD5, blockMD5s, precreateResp.UploadID, precreateResp.BlockList)
+	return f.uploadSessionBlocks(ctx, spool, session, acc)
+}
+
+// uploadSessionBlocks uploads the blocks of session which haven't been
+// done yet then creates the file, removing the session on success.
+//
+// If acc is set the blocks are accounted to it as they are uploaded.
+// Blocks uploaded by a previous session aren't accounted again as
+// they aren't sent.
+func (f *Fs) uploadSessionBlocks(ctx context.Context, in io.ReaderAt, session *uploadSession, acc *accounting.Account) (*api.CreateFileResponse, error) {
+	todo := session.remaining()
+	if len(todo) > 0 {
+		err := f.uploadBlocks(ctx, in, session.Size, session.Path, session.UploadID, todo, session.BlockMD5s, func(blockNum int, n int64) error {
+			session.done(blockNum)
+			if acc != nil {
+				return acc.AccountRead(int(n))
+			}
+			return nil
+		})
 		if err != nil {
 			return nil, fmt.Errorf("upload blocks failed: %w", err)
 		}
 	}
 
-	// Step 3: Create file
-	return f.createFileFinish(ctx, filePath, size, precreateResp.UploadID, blockMD5s)
+	result, err := f.createFileFinish(ctx, session.Path, session.Size, session.UploadID, session.BlockMD5s)
+	if err != nil {
+		return nil, err
+	}
+	session.remove()
+	return result, nil
 }
 
-// calculateMD5 calculates the MD5 hash of a reader
-func calculateMD5(in io.Reader) (string, error) {
-	hash := md5.New()
-	if _, err := io.Copy(hash, in); err != nil {
-		return "", err
+// readBlockMD5s reads in calculating the content MD5 and the MD5 of
+// each blockSize block, returning an io.ReaderAt which will read the
+// same contents.
+//
+// Inputs bigger than limit are spooled to a temporary file so
+// memory use stays bounded whatever the size of the upload.
+//
+// The cleanup function should be called when spool is finished with
+// regardless of whether this function returned an error or not.
+func readBlockMD5s(in io.Reader, size, blockSize, limit int64) (contentMD5 string, blockMD5s []string, spool io.ReaderAt, cleanup func(), err error) {
+	// nothing to clean up by default
+	cleanup = func() {}
+
+	var (
+		out  io.Writer
+		buf  *bytes.Buffer
+		file *os.File
+	)
+	if size > limit {
+		file, err = os.CreateTemp("", cachePrefix)
+		if err != nil {
+			return "", nil, nil, cleanup, err
+		}
+		_ = os.Remove(file.Name()) // Delete the file - may not work on Windows
+		cleanup = func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name()) // delete the spool file - may be deleted already
+		}
+		out = file
+	} else {
+		buf = bytes.NewBuffer(make([]byte, 0, size))
+		out = buf
 	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+
+	contentHash := md5.New()
+	in = io.TeeReader(in, io.MultiWriter(contentHash, out))
+	blockMD5s, err = calculateBlockMD5s(in, size, blockSize)
+	if err != nil {
+		return "", nil, nil, cleanup, err
+	}
+	if file != nil {
+		spool = file
+	} else {
+		spool = bytes.NewReader(buf.Bytes())
+	}
+	return hex.EncodeToString(contentHash.Sum(nil)), blockMD5s, spool, cleanup, nil
 }
 
 // calculateBlockMD5s calculates MD5 hashes for each block
@@ -98,11 +167,14 @@ func calculateBlockMD5s(in io.Reader, size int64, blockSize int64) ([]string, er
 			blockLen = remaining
 		}
 
-		limitReader := io.LimitReader(in, blockLen)
 		blockHash := md5.New()
-		if _, err := io.Copy(blockHash, limitReader); err != nil {
+		n, err := io.Copy(blockHash, io.LimitReader(in, blockLen))
+		if err != nil {
 			return nil, err
 		}
+		if n != blockLen {
+			return nil, fmt.Errorf("short read: expecting %d bytes but got %d: %w", size, size-remaining+n, io.ErrUnexpectedEOF)
+		}
 
 		blockMD5s = append(blockMD5s, hex.EncodeToString(blockHash.Sum(nil)))
 		remaining -= blockLen
@@ -162,69 +234,68 @@ func (f *Fs) precreate(ctx context.Context, path string, size int64, contentMD5
 	return converted, nil
 }
 
-// uploadBlocks uploads the specified blocks
-func (f *Fs) uploadBlocks(ctx context.Context, in io.Reader, size int64, path string, uploadID string, blockList []int, blockMD5s []string) error {
-	var seeker io.ReadSeeker
-	if rs, ok := in.(io.ReadSeeker); ok {
-		seeker = rs
-	} else {
-		data, err := io.ReadAll(in)
-		if err != nil {
-			return fmt.Errorf("failed to buffer upload data: %w", err)
-		}
-		seeker = bytes.NewReader(data)
-	}
+// blockRange returns the offset and length of block blockNum
+func blockRange(size, blockSize int64, blockNum int) (offset, length int64) {
+	offset = int64(blockNum) * blockSize
+	length = min(blockSize, size-offset)
+	return offset, length
+}
 
+// uploadBlocks uploads the specified blocks
+//
+// Up to upload_concurrency blocks are uploaded at once. done is called
+// with the block number and length after each block is uploaded.
+func (f *Fs) uploadBlocks(ctx context.Context, in io.ReaderAt, size int64, path string, uploadID string, blockList []int, blockMD5s []string, done func(blockNum int, n int64) error) error {
 	blockSize := int64(f.opt.ChunkSize)
 
 	for _, blockNum := range blockList {
-		if blockNum >= len(blockMD5s) {
+		if blockNum < 0 || blockNum >= len(blockMD5s) {
 			return fmt.Errorf("block number %d out of range", blockNum)
 		}
+	}
 
-		offset := int64(blockNum) * blockSize
-		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
-			return fmt.Errorf("failed to seek to block %d: %w", blockNum, err)
-		}
-
-		// Read the block
-		blockLen := blockSize
-		if offset+blockSize > size {
-			blockLen = size - offset
-		}
-
-		blockData := make([]byte, blockLen)
-		if _, err := io.ReadFull(seeker, blockData); err != nil {
-			return fmt.Errorf("failed to read block %d: %w", blockNum, err)
-		}
-
-		// Upload the block
-		err := f.uploadBlock(ctx, path, blockData, uploadID, blockNum)
-		if err != nil {
-			return fmt.Errorf("failed to upload block %d: %w", blockNum, err)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(max(f.opt.UploadConcurrency, 1))
+	for _, blockNum := range blockList {
+		if gCtx.Err() != nil {
+			break
 		}
-
-		fs.Debugf(f, "Uploaded block %d/%d", blockNum+1, len(blockMD5s))
+		offset, blockLen := blockRange(size, blockSize, blockNum)
+		g.Go(func() error {
+			// Upload the block
+			err := f.uploadBlock(gCtx, path, io.NewSectionReader(in, offset, blockLen), uploadID, blockNum)
+			if err != nil {
+				return fmt.Errorf("failed to upload block %d: %w", blockNum, err)
+			}
+			fs.Debugf(f, "Uploaded block %d/%d", blockNum+1, len(blockMD5s))
+			return done(blockNum, blockLen)
+		})
 	}
 
-	return nil
+	return g.Wait()
 }
 
 // uploadBlock uploads a single block
-func (f *Fs) uploadBlock(ctx context.Context, path string, data []byte, uploadID string, blockNum int) error {
+//
+// The block is read from the start on each attempt so the upload can
+// be retried.
+func (f *Fs) uploadBlock(ctx context.Context, path string, block io.ReadSeeker, uploadID string, blockNum int) error {
 	accessToken, err := f.getAccessToken(ctx)
 	if err != nil {
 		return err
 	}
 
 	_, err = callTyped(f, ctx, func() (string, *http.Response, error) {
+		if _, err := block.Seek(0, io.SeekStart); err != nil {
+			return "", nil, err
+		}
 		return f.sdk.FileuploadApi.Pcssuperfile2(ctx).
 			AccessToken(accessToken).
 			Partseq(strconv.Itoa(blockNum)).
 			Path(path).
 			Uploadid(uploadID).
 			Type_("tmpfile").
-			FileReader(bytes.NewReader(data), fmt.Sprintf("chunk-%d", blockNum)).
+			FileReader(block, fmt.Sprintf("chunk-%d", blockNum)).
 			Execute()
 	})
 	return err
@@ -279,3 +350,116 @@ func (f *Fs) createFileFinish(ctx context.Context, path string, size int64, uplo
 
 	return result, nil
 }
+
+// chunkWriter uploads a file in blocks for fs.OpenChunkWriter
+//
+// The block MD5s aren't known in advance so precreate is called with
+// placeholder MD5s, which means rapid upload is never attempted. The
+// real MD5s are worked out as the blocks are written and passed to
+// create to finish the file.
+type chunkWriter struct {
+	f         *Fs
+	remote    string
+	path      string
+	size      int64
+	uploadID  string
+	mu        sync.Mutex
+	blockMD5s []string
+}
+
+// OpenChunkWriter returns the chunk size and a ChunkWriter
+//
+// Pass in the remote and the src object
+// You can also use options to hint at the desired chunk size
+func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	size := src.Size()
+	if size < 0 {
+		return info, nil, fmt.Errorf("cannot upload file with unknown size")
+	}
+
+	// Create parent directories if needed
+	dirPath := path.Dir(remote)
+	if dirPath != "" && dirPath != "." {
+		if err := f.Mkdir(ctx, dirPath); err != nil {
+			return info, nil, err
+		}
+	}
+
+	blockSize := int64(f.opt.ChunkSize)
+	blocks := max((size+blockSize-1)/blockSize, 1)
+	placeholders := make([]string, blocks)
+	for i := range placeholders {
+		placeholders[i] = placeholderBlockMD5
+	}
+
+	filePath := f.makePath(remote)
+	precreateResp, err := f.precreate(ctx, filePath, size, "", placeholders)
+	if err != nil {
+		return info, nil, fmt.Errorf("precreate failed: %w", err)
+	}
+	if precreateResp.ReturnType == 2 {
+		return info, nil, fmt.Errorf("unexpected rapid upload for %q", remote)
+	}
+
+	w := &chunkWriter{
+		f:         f,
+		remote:    remote,
+		path:      filePath,
+		size:      size,
+		uploadID:  precreateResp.UploadID,
+		blockMD5s: make([]string, blocks),
+	}
+	info = fs.ChunkWriterInfo{
+		ChunkSize:   blockSize,
+		Concurrency: f.opt.UploadConcurrency,
+	}
+	fs.Debugf(w.f, "open chunk writer: started upload of %q: %d blocks, upload ID %q", remote, blocks, w.uploadID)
+	return info, w, nil
+}
+
+// WriteChunk will write chunk number with reader bytes, where chunk number >= 0
+func (w *chunkWriter) WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (int64, error) {
+	if chunkNumber < 0 || chunkNumber >= len(w.blockMD5s) {
+		return 0, fmt.Errorf("block number %d out of range", chunkNumber)
+	}
+	blockHash := md5.New()
+	n, err := io.Copy(blockHash, reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read block %d: %w", chunkNumber, err)
+	}
+	err = w.f.uploadBlock(ctx, w.path, reader, w.uploadID, chunkNumber)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload block %d: %w", chunkNumber, err)
+	}
+	w.mu.Lock()
+	w.blockMD5s[chunkNumber] = hex.EncodeToString(blockHash.Sum(nil))
+	w.mu.Unlock()
+	fs.Debugf(w.f, "Uploaded block %d/%d of %q", chunkNumber+1, len(w.blockMD5s), w.remote)
+	return n, nil
+}
+
+// Close complete chunked writer finalising the file.
+func (w *chunkWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, blockMD5 := range w.blockMD5s {
+		if blockMD5 == "" {
+			if w.size == 0 && i == 0 {
+				w.blockMD5s[0] = emptyMD5
+				continue
+			}
+			return fmt.Errorf("block %d of %q was not uploaded", i, w.remote)
+		}
+	}
+	_, err := w.f.createFileFinish(ctx, w.path, w.size, w.uploadID, w.blockMD5s)
+	return err
+}
+
+// Abort chunk write
+//
+// Baidu Pan has no way of cancelling an upload - the uploaded blocks
+// are expired by the server.
+func (w *chunkWriter) Abort(ctx context.Context) error {
+	fs.Debugf(w.f, "Abandoning upload of %q with upload ID %q", w.remote, w.uploadID)
+	return nil
+}
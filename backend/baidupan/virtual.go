@@ -0,0 +1,255 @@
+package baidupan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/baidupan/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// A root starting with {category}/name or {search}/term shows the
+// files Baidu has indexed in that category, or whose names match the
+// search term, as a read-only tree. The files appear at their real
+// paths so the tree is a sparse copy of the netdisk.
+
+const (
+	virtualCategory = "{category}"
+	virtualSearch   = "{search}"
+	// how long to use the results of the category list or search for
+	virtualCacheTime = time.Minute
+	// number of items to fetch per page of categorylist and search
+	virtualListLimit = 1000
+)
+
+var errorReadOnly = errors.New("baidupan {category} and {search} roots are read only")
+
+// virtualRoot is the state of a {category} or {search} root
+type virtualRoot struct {
+	kind     string // virtualCategory or virtualSearch
+	term     string // category name or search term
+	category int    // category number for virtualCategory
+	sub      string // directory within the tree the root points to
+
+	mu      sync.Mutex
+	tree    *virtualTree
+	fetched time.Time
+}
+
+// virtualTree holds the results of a category list or search
+type virtualTree struct {
+	files map[string][]api.FileItem // directory to files in it
+	dirs  map[string][]string       // directory to names of subdirectories
+}
+
+// parseVirtualRoot returns the virtualRoot for root or nil if root
+// isn't virtual.
+func parseVirtualRoot(root string) (*virtualRoot, error) {
+	kind, after, _ := strings.Cut(root, "/")
+	if kind != virtualCategory && kind != virtualSearch {
+		return nil, nil
+	}
+	term, sub, _ := strings.Cut(after, "/")
+	if term == "" {
+		return nil, fmt.Errorf("%s needs a name after it, e.g. %s/audio", kind, kind)
+	}
+	v := &virtualRoot{
+		kind: kind,
+		term: term,
+		sub:  sub,
+	}
+	if kind == virtualCategory {
+		for category, name := range api.CategoryNames {
+			if name == term {
+				v.category = category
+			}
+		}
+		if v.category == 0 {
+			return nil, fmt.Errorf("unknown category %q", term)
+		}
+	}
+	return v, nil
+}
+
+// newVirtualTree builds the directory tree of the files in items
+func newVirtualTree(items []api.FileItem) *virtualTree {
+	t := &virtualTree{
+		files: make(map[string][]api.FileItem),
+		dirs:  make(map[string][]string),
+	}
+	seen := make(map[string]struct{})
+	for _, item := range items {
+		if item.IsDir() {
+			continue
+		}
+		dir := strings.Trim(path.Dir(item.Path), "/")
+		t.files[dir] = append(t.files[dir], item)
+		// Add the parent directories which haven't been seen yet
+		for dir != "" {
+			if _, ok := seen[dir]; ok {
+				break
+			}
+			seen[dir] = struct{}{}
+			parent := path.Dir(dir)
+			if parent == "." {
+				parent = ""
+			}
+			t.dirs[parent] = append(t.dirs[parent], path.Base(dir))
+			dir = parent
+		}
+	}
+	return t
+}
+
+// getTree returns the tree, fetching it if it is too old
+func (v *virtualRoot) getTree(ctx context.Context, f *Fs) (*virtualTree, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.tree != nil && time.Since(v.fetched) < virtualCacheTime {
+		return v.tree, nil
+	}
+	var items []api.FileItem
+	var err error
+	if v.kind == virtualCategory {
+		items, err = f.listCategory(ctx, v.category)
+	} else {
+		items, err = f.search(ctx, v.term)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.tree = newVirtualTree(items)
+	v.fetched = time.Now()
+	return v.tree, nil
+}
+
+// list the directory dir relative to the root
+func (v *virtualRoot) list(ctx context.Context, f *Fs, dir string) (entries fs.DirEntries, err error) {
+	t, err := v.getTree(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	treeDir := f.opt.Enc.FromStandardPath(path.Join(v.sub, dir))
+	if treeDir == "." {
+		treeDir = ""
+	}
+	files, subdirs := t.files[treeDir], t.dirs[treeDir]
+	if files == nil && subdirs == nil && treeDir != "" {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, name := range subdirs {
+		remote := path.Join(dir, f.opt.Enc.ToStandardName(name))
+		entries = append(entries, fs.NewDir(remote, time.Time{}))
+	}
+	for _, item := range files {
+		remote := path.Join(dir, f.opt.Enc.ToStandardName(path.Base(item.Path)))
+		entries = append(entries, f.itemToDirEntry(remote, &item))
+	}
+	return entries, nil
+}
+
+// find returns the file at remote relative to the root
+//
+// It returns fs.ErrorIsDir if remote is a directory.
+func (v *virtualRoot) find(ctx context.Context, f *Fs, remote string) (*api.FileItem, error) {
+	t, err := v.getTree(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	treePath := f.opt.Enc.FromStandardPath(path.Join(v.sub, remote))
+	dir, leaf := path.Split(treePath)
+	dir = strings.TrimSuffix(dir, "/")
+	for i := range t.files[dir] {
+		if path.Base(t.files[dir][i].Path) == leaf {
+			return &t.files[dir][i], nil
+		}
+	}
+	if slices.Contains(t.dirs[dir], leaf) {
+		return nil, fs.ErrorIsDir
+	}
+	return nil, fs.ErrorObjectNotFound
+}
+
+// listCategory lists all the files in category
+func (f *Fs) listCategory(ctx context.Context, category int) (items []api.FileItem, err error) {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for start := 0; ; {
+		opts := rest.Opts{
+			Method: "GET",
+			Path:   api.PathMultimedia,
+			Parameters: url.Values{
+				"method":       {"categorylist"},
+				"access_token": {accessToken},
+				"category":     {strconv.Itoa(category)},
+				"parent_path":  {"/"},
+				"recursion":    {"1"},
+				"show_dir":     {"0"},
+				"start":        {strconv.Itoa(start)},
+				"limit":        {strconv.Itoa(virtualListLimit)},
+			},
+		}
+		var result api.FileListResponse
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
+			return f.shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list category: %w", err)
+		}
+		if result.ErrorCode != 0 {
+			return nil, fmt.Errorf("couldn't list category: %w", &result.Error)
+		}
+		items = append(items, result.List...)
+		if result.HasMore == 0 || len(result.List) == 0 {
+			break
+		}
+		start += len(result.List)
+	}
+	return items, nil
+}
+
+// search finds all the files whose names contain term
+func (f *Fs) search(ctx context.Context, term string) (items []api.FileItem, err error) {
+	accessToken, err := f.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for page := 1; ; page++ {
+		var result api.FileListResponse
+		err = f.callJSON(ctx, func() (string, *http.Response, error) {
+			return f.sdk.FileinfoApi.Xpanfilesearch(ctx).
+				AccessToken(accessToken).
+				Key(term).
+				Dir("/").
+				Recursion("1").
+				Page(strconv.Itoa(page)).
+				Num(strconv.Itoa(virtualListLimit)).
+				Execute()
+		}, &result)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't search: %w", err)
+		}
+		if result.ErrorCode != 0 {
+			return nil, fmt.Errorf("couldn't search: %w", &result.Error)
+		}
+		items = append(items, result.List...)
+		if result.HasMore == 0 || len(result.List) == 0 {
+			break
+		}
+	}
+	return items, nil
+}
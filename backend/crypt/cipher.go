@@ -24,6 +24,8 @@ import (
 	"github.com/rclone/rclone/lib/readers"
 	"github.com/rclone/rclone/lib/version"
 	"github.com/rfjakob/eme"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/nacl/secretbox"
 	"golang.org/x/crypto/scrypt"
 )
@@ -38,6 +40,10 @@ const (
 	blockHeaderSize     = secretbox.Overhead
 	blockDataSize       = 64 * 1024
 	blockSize           = blockHeaderSize + blockDataSize
+	publicKeyFileMagic  = "RCLONE\x00\x01"
+	publicKeySize       = curve25519.PointSize
+	sealedKeySize       = 32 + box.AnonymousOverhead
+	publicKeyHeaderSize = fileHeaderSize + sealedKeySize
 )
 
 // Errors returned by cipher
@@ -56,13 +62,16 @@ var (
 	ErrorNotAnEncryptedFile      = errors.New("not an encrypted file - does not match suffix")
 	ErrorBadSeek                 = errors.New("Seek beyond end of file")
 	ErrorSuffixMissingDot        = errors.New("suffix config setting should include a '.'")
+	ErrorNoPrivateKey            = errors.New("can't decrypt file - private_key is needed to read files encrypted with public_key")
+	ErrorBadFileKey              = errors.New("failed to open the file key - wrong private_key?")
 	defaultSalt                  = []byte{0xA8, 0x0D, 0xF4, 0x3A, 0x8F, 0xBD, 0x03, 0x08, 0xA7, 0xCA, 0xB8, 0x3E, 0x58, 0x1F, 0x86, 0xB1}
 	obfuscQuoteRune              = '!'
 )
 
 // Global variables
 var (
-	fileMagicBytes = []byte(fileMagic)
+	fileMagicBytes          = []byte(fileMagic)
+	publicKeyFileMagicBytes = []byte(publicKeyFileMagic)
 )
 
 // ReadSeekCloser is the interface of the read handles
@@ -181,6 +190,9 @@ type Cipher struct {
 	dirNameEncrypt  bool
 	passBadBlocks   bool // if set passed bad blocks as zeroed blocks
 	encryptedSuffix string
+	publicKey       *[publicKeySize]byte // if set file keys are sealed to this
+	privateKey      *[publicKeySize]byte // private key for publicKey if known
+	headerSize      int                  // size of the header of new files
 }
 
 // newCipher initialises the cipher.  If salt is "" then it uses a built in salt val
@@ -191,6 +203,7 @@ func newCipher(mode NameEncryptionMode, password, salt string, dirNameEncrypt bo
 		cryptoRand:      rand.Reader,
 		dirNameEncrypt:  dirNameEncrypt,
 		encryptedSuffix: ".bin",
+		headerSize:      fileHeaderSize,
 	}
 	c.buffers.New = func() any {
 		return new([blockSize]byte)
@@ -220,6 +233,76 @@ func (c *Cipher) setPassBadBlocks(passBadBlocks bool) {
 	c.passBadBlocks = passBadBlocks
 }
 
+// setKeyPair makes the cipher encrypt each new file with a random key
+// sealed to publicKey instead of the key derived from the password.
+//
+// Files are decrypted according to the magic in their header, so files
+// written with the password before the key pair was set can still be
+// read.
+//
+// privateKey may be nil in which case files can be written but not read.
+func (c *Cipher) setKeyPair(publicKey, privateKey *[publicKeySize]byte) error {
+	if privateKey != nil {
+		derived, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+		if err != nil {
+			return err
+		}
+		if publicKey == nil {
+			publicKey = new([publicKeySize]byte)
+			copy(publicKey[:], derived)
+		} else if !bytes.Equal(publicKey[:], derived) {
+			return errors.New("private key doesn't match public key")
+		}
+	}
+	c.publicKey = publicKey
+	c.privateKey = privateKey
+	c.headerSize = publicKeyHeaderSize
+	return nil
+}
+
+// NewKeyPair makes a new X25519 key pair for use with public_key and
+// private_key
+func NewKeyPair() (publicKey, privateKey *[publicKeySize]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+// fileKey is the random key a file is encrypted with in public key
+// mode
+type fileKey struct {
+	key    [32]byte
+	sealed [sealedKeySize]byte // key sealed to the public key
+}
+
+// newFileKey makes a new random file key sealed to the public key
+func (c *Cipher) newFileKey() (*fileKey, error) {
+	fk := new(fileKey)
+	n, err := readers.ReadFill(c.cryptoRand, fk.key[:])
+	if n != len(fk.key) {
+		return nil, fmt.Errorf("short read of file key: %w", err)
+	}
+	sealed, err := box.SealAnonymous(nil, fk.key[:], c.publicKey, c.cryptoRand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal file key: %w", err)
+	}
+	copy(fk.sealed[:], sealed)
+	return fk, nil
+}
+
+// openFileKey reads the file key from its sealed form
+func (c *Cipher) openFileKey(sealed []byte) (*fileKey, error) {
+	if c.privateKey == nil {
+		return nil, ErrorNoPrivateKey
+	}
+	key, ok := box.OpenAnonymous(nil, sealed, c.publicKey, c.privateKey)
+	if !ok || len(key) != 32 {
+		return nil, ErrorBadFileKey
+	}
+	fk := new(fileKey)
+	copy(fk.key[:], key)
+	copy(fk.sealed[:], sealed)
+	return fk, nil
+}
+
 // Key creates all the internal keys from the password passed in using
 // scrypt.
 //
@@ -683,6 +766,8 @@ type encrypter struct {
 	in       io.Reader
 	c        *Cipher
 	nonce    nonce
+	key      *[32]byte // key to encrypt the data with
+	fileKey  *fileKey  // file key in public key mode
 	buf      *[blockSize]byte
 	readBuf  *[blockSize]byte
 	bufIndex int
@@ -691,14 +776,38 @@ type encrypter struct {
 }
 
 // newEncrypter creates a new file handle encrypting on the fly
+//
+// In public key mode the data is encrypted with a new file key.
 func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
+	var fk *fileKey
+	if c.publicKey != nil {
+		var err error
+		fk, err = c.newFileKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.newEncrypterFileKey(in, nonce, fk)
+}
+
+// newEncrypterFileKey creates a new file handle encrypting on the fly
+//
+// The data is encrypted with fk and the public key header if fk is
+// set, otherwise with the key from the password and the original
+// header. This is used to encrypt data the same way as an existing
+// file.
+func (c *Cipher) newEncrypterFileKey(in io.Reader, nonce *nonce, fk *fileKey) (*encrypter, error) {
 	fh := &encrypter{
 		in:      in,
 		c:       c,
-		buf:     c.getBlock(),
-		readBuf: c.getBlock(),
+		key:     &c.dataKey,
 		bufSize: fileHeaderSize,
 	}
+	if fk != nil {
+		fh.key = &fk.key
+		fh.fileKey = fk
+		fh.bufSize = publicKeyHeaderSize
+	}
 	// Initialise nonce
 	if nonce != nil {
 		fh.nonce = *nonce
@@ -708,10 +817,20 @@ func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
 			return nil, err
 		}
 	}
+	fh.buf = c.getBlock()
+	fh.readBuf = c.getBlock()
 	// Copy magic into buffer
-	copy((*fh.buf)[:], fileMagicBytes)
+	if fh.fileKey != nil {
+		copy((*fh.buf)[:], publicKeyFileMagicBytes)
+	} else {
+		copy((*fh.buf)[:], fileMagicBytes)
+	}
 	// Copy nonce into buffer
 	copy((*fh.buf)[fileMagicSize:], fh.nonce[:])
+	// Copy sealed file key into buffer
+	if fh.fileKey != nil {
+		copy((*fh.buf)[fileHeaderSize:], fh.fileKey.sealed[:])
+	}
 	return fh, nil
 }
 
@@ -734,7 +853,7 @@ func (fh *encrypter) Read(p []byte) (n int, err error) {
 		// possibly err != nil here, but we will process the
 		// data and the next call to ReadFill will return 0, err
 		// Encrypt the block using the nonce
-		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), &fh.c.dataKey)
+		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), fh.key)
 		fh.bufIndex = 0
 		fh.bufSize = blockHeaderSize + n
 		fh.nonce.increment()
@@ -779,6 +898,9 @@ type decrypter struct {
 	rc           io.ReadCloser
 	nonce        nonce
 	initialNonce nonce
+	key          *[32]byte // key to decrypt the data with
+	fileKey      *fileKey  // file key if the file has a public key header
+	headerSize   int       // size of the header of the file
 	c            *Cipher
 	buf          *[blockSize]byte
 	readBuf      *[blockSize]byte
@@ -792,11 +914,13 @@ type decrypter struct {
 // newDecrypter creates a new file handle decrypting on the fly
 func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
 	fh := &decrypter{
-		rc:      rc,
-		c:       c,
-		buf:     c.getBlock(),
-		readBuf: c.getBlock(),
-		limit:   -1,
+		rc:         rc,
+		c:          c,
+		key:        &c.dataKey,
+		headerSize: fileHeaderSize,
+		buf:        c.getBlock(),
+		readBuf:    c.getBlock(),
+		limit:      -1,
 	}
 	// Read file header (magic + nonce)
 	readBuf := (*fh.readBuf)[:fileHeaderSize]
@@ -807,13 +931,35 @@ func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
 	} else if err != io.EOF && err != nil {
 		return nil, fh.finishAndClose(err)
 	}
-	// check the magic
-	if !bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes) {
+	// check the magic - in public key mode files written with the
+	// password before the key pair was set may be read too
+	isPublicKey := c.publicKey != nil && bytes.Equal(readBuf[:fileMagicSize], publicKeyFileMagicBytes)
+	if !isPublicKey && !bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes) {
 		return nil, fh.finishAndClose(ErrorEncryptedBadMagic)
 	}
 	// retrieve the nonce
-	fh.nonce.fromBuf(readBuf[fileMagicSize:])
+	fh.nonce.fromBuf(readBuf[fileMagicSize:fileHeaderSize])
 	fh.initialNonce = fh.nonce
+	if !isPublicKey {
+		return fh, nil
+	}
+	// read and open the sealed file key
+	if c.privateKey == nil {
+		return nil, fh.finishAndClose(ErrorNoPrivateKey)
+	}
+	fh.headerSize = publicKeyHeaderSize
+	sealed := (*fh.readBuf)[fileHeaderSize:publicKeyHeaderSize]
+	n, err = readers.ReadFill(fh.rc, sealed)
+	if n < sealedKeySize && err == io.EOF {
+		return nil, fh.finishAndClose(ErrorEncryptedFileTooShort)
+	} else if err != io.EOF && err != nil {
+		return nil, fh.finishAndClose(err)
+	}
+	fh.fileKey, err = c.openFileKey(sealed)
+	if err != nil {
+		return nil, fh.finishAndClose(err)
+	}
+	fh.key = &fh.fileKey.key
 	return fh, nil
 }
 
@@ -829,11 +975,11 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 	} else if offset == 0 {
 		// If no offset open the header + limit worth of the file
 		_, underlyingLimit, _, _ := calculateUnderlying(offset, limit)
-		rc, err = open(ctx, 0, int64(fileHeaderSize)+underlyingLimit)
+		rc, err = open(ctx, 0, int64(c.headerSize)+underlyingLimit)
 		setLimit = true
 	} else {
 		// Otherwise just read the header to start with
-		rc, err = open(ctx, 0, int64(fileHeaderSize))
+		rc, err = open(ctx, 0, int64(c.headerSize))
 		doRangeSeek = true
 	}
 	if err != nil {
@@ -877,7 +1023,7 @@ func (fh *decrypter) fillBuffer() (err error) {
 		return ErrorEncryptedFileBadHeader
 	}
 	// Decrypt the block using the nonce
-	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), &fh.c.dataKey)
+	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), fh.key)
 	if !ok {
 		if err != nil && err != io.EOF {
 			return err // return pending error as it is likely more accurate
@@ -988,6 +1134,8 @@ func (fh *decrypter) RangeSeek(ctx context.Context, offset int64, whence int, li
 	}
 
 	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(offset, limit)
+	// calculateUnderlying assumes the standard header size
+	underlyingOffset += int64(fh.headerSize - fileHeaderSize)
 
 	// Move the nonce on the correct number of blocks from the start
 	fh.nonce = fh.initialNonce
@@ -1119,17 +1267,38 @@ func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset
 
 // EncryptedSize calculates the size of the data when encrypted
 func (c *Cipher) EncryptedSize(size int64) int64 {
+	return encryptedSize(size, c.headerSize)
+}
+
+// encryptedSize calculates the size of the data when encrypted with
+// a header of headerSize
+func encryptedSize(size int64, headerSize int) int64 {
 	blocks, residue := size/blockDataSize, size%blockDataSize
-	encryptedSize := int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	encryptedSize := int64(headerSize) + blocks*(blockHeaderSize+blockDataSize)
 	if residue != 0 {
 		encryptedSize += blockHeaderSize + residue
 	}
 	return encryptedSize
 }
 
+// isEncryptedSize returns true if encrypted is the size of size bytes
+// of data encrypted with any header the cipher can read
+func (c *Cipher) isEncryptedSize(encrypted, size int64) bool {
+	return encrypted == encryptedSize(size, c.headerSize) || encrypted == encryptedSize(size, fileHeaderSize)
+}
+
 // DecryptedSize calculates the size of the data when decrypted
+//
+// This assumes the file has the header new files are written with.
+// Use decryptedSize for files with a different header.
 func (c *Cipher) DecryptedSize(size int64) (int64, error) {
-	size -= int64(fileHeaderSize)
+	return decryptedSize(size, c.headerSize)
+}
+
+// decryptedSize calculates the size of the data when decrypted from
+// a file with a header of headerSize
+func decryptedSize(size int64, headerSize int) (int64, error) {
+	size -= int64(headerSize)
 	if size < 0 {
 		return 0, ErrorEncryptedFileTooShort
 	}
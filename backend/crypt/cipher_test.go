@@ -1640,3 +1640,98 @@ func TestKey(t *testing.T) {
 	assert.Equal(t, [32]byte{}, c.nameKey)
 	assert.Equal(t, [16]byte{}, c.nameTweak)
 }
+
+func TestPublicKey(t *testing.T) {
+	publicKey, privateKey, err := NewKeyPair()
+	require.NoError(t, err)
+	_, otherPrivateKey, err := NewKeyPair()
+	require.NoError(t, err)
+
+	newPublicKeyCipher := func(publicKey, privateKey *[publicKeySize]byte) *Cipher {
+		c, err := newCipher(NameEncryptionStandard, "potato", "", true, base32.HexEncoding)
+		require.NoError(t, err)
+		require.NoError(t, c.setKeyPair(publicKey, privateKey))
+		return c
+	}
+	writer := newPublicKeyCipher(publicKey, nil)
+	reader := newPublicKeyCipher(nil, privateKey)
+	assert.Equal(t, publicKey, reader.publicKey)
+
+	// Mismatched keys are rejected
+	c, err := newCipher(NameEncryptionStandard, "potato", "", true, base32.HexEncoding)
+	require.NoError(t, err)
+	assert.Error(t, c.setKeyPair(publicKey, otherPrivateKey))
+
+	// Names are encrypted with the password in the same way
+	plain, err := newCipher(NameEncryptionStandard, "potato", "", true, base32.HexEncoding)
+	require.NoError(t, err)
+	assert.Equal(t, plain.EncryptFileName("potato/sausage"), writer.EncryptFileName("potato/sausage"))
+
+	for _, size := range []int64{0, 1, blockDataSize - 1, blockDataSize, blockDataSize + 1, 150000} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			plaintext, err := io.ReadAll(newRandomSource(size))
+			require.NoError(t, err)
+
+			// Encrypt with only the public key
+			encrypted, err := writer.EncryptData(bytes.NewBuffer(plaintext))
+			require.NoError(t, err)
+			ciphertext, err := io.ReadAll(encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, publicKeyFileMagicBytes, ciphertext[:fileMagicSize])
+			assert.Equal(t, writer.EncryptedSize(size), int64(len(ciphertext)))
+			assert.Equal(t, plain.EncryptedSize(size)+sealedKeySize, int64(len(ciphertext)))
+			decryptedSize, err := reader.DecryptedSize(int64(len(ciphertext)))
+			require.NoError(t, err)
+			assert.Equal(t, size, decryptedSize)
+
+			// Can't read it without the private key
+			_, err = writer.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+			assert.ErrorIs(t, err, ErrorNoPrivateKey)
+
+			// Can't read it with the password alone
+			_, err = plain.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+			assert.ErrorIs(t, err, ErrorEncryptedBadMagic)
+
+			// Or with the wrong private key
+			other := newPublicKeyCipher(nil, otherPrivateKey)
+			_, err = other.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+			assert.ErrorIs(t, err, ErrorBadFileKey)
+
+			// Can read it with the private key
+			decrypted, err := reader.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+			require.NoError(t, err)
+			out, err := io.ReadAll(decrypted)
+			require.NoError(t, err)
+			assert.Equal(t, plaintext, out)
+
+			// Check seeking works with the bigger header
+			open := func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+				end := int64(len(ciphertext))
+				if underlyingLimit >= 0 {
+					end = min(underlyingOffset+underlyingLimit, end)
+				}
+				return io.NopCloser(bytes.NewBuffer(ciphertext[underlyingOffset:end])), nil
+			}
+			for _, offset := range []int64{0, 1, blockDataSize - 1, blockDataSize, blockDataSize + 1} {
+				if offset >= size {
+					continue
+				}
+				rc, err := reader.DecryptDataSeek(context.Background(), open, offset, -1)
+				require.NoError(t, err)
+				out, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				assert.Equal(t, plaintext[offset:], out, offset)
+				require.NoError(t, rc.Close())
+			}
+
+			// Check the encryption can be reproduced from the file key
+			d, err := reader.newDecrypter(io.NopCloser(bytes.NewBuffer(ciphertext)))
+			require.NoError(t, err)
+			fh, err := writer.newEncrypterFileKey(bytes.NewBuffer(plaintext), &d.nonce, d.fileKey)
+			require.NoError(t, err)
+			reencrypted, err := io.ReadAll(fh)
+			require.NoError(t, err)
+			assert.Equal(t, ciphertext, reencrypted)
+		})
+	}
+}
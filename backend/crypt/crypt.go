@@ -2,12 +2,15 @@
 package crypt
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/fs"
@@ -30,7 +33,11 @@ func init() {
 		NewFs:       NewFs,
 		CommandHelp: commandHelp,
 		MetadataInfo: &fs.MetadataInfo{
-			Help: `Any metadata supported by the underlying remote is read and written.`,
+			Help: `Any metadata supported by the underlying remote is read and written.
+
+If metadata_sidecar is set then the modification time, mime type and
+user metadata of files are stored in an encrypted sidecar so they are
+preserved even if the underlying remote can't store them.`,
 		},
 		Options: []fs.Option{{
 			Name:     "remote",
@@ -172,6 +179,79 @@ Setting suffix to "none" will result in an empty suffix. This may be useful
 when the path length is critical.`,
 			Default:  ".bin",
 			Advanced: true,
+		}, {
+			Name: "metadata_sidecar",
+			Help: `Store the modification time and metadata of files in encrypted sidecars.
+
+If this is set then each file has a small encrypted object stored next
+to it holding its modification time, size, mime type and metadata (if
+--metadata is in use). Crypt reads these back in preference to the
+values stored by the underlying remote, so they are preserved even if
+the underlying remote can't store them, and they are encrypted and
+authenticated in the same way as the file data.
+
+Reading the modification time or metadata of a file needs the sidecar
+to be read, which takes an extra transaction per file.
+
+File names ending in ".cryptmeta" are reserved for the sidecars and
+can't be used when this is set.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "public_key",
+			Help: `Public key to encrypt file data to.
+
+If this is set then each file is encrypted with its own random key
+which is sealed to this X25519 public key and stored in the file
+header. Only the public key is needed to upload files, so a machine
+which only writes backups can't read them. The private_key is needed
+to read them back.
+
+File names are still encrypted with the key made from the password.
+
+Make a key pair with "rclone backend keygen crypt:".`,
+			Advanced: true,
+		}, {
+			Name: "private_key",
+			Help: `Private key to decrypt file data with.
+
+This is the private half of public_key and is needed to read files
+encrypted with it. If public_key isn't set it is worked out from this.`,
+			IsPassword: true,
+			Advanced:   true,
+		}, {
+			Name: "dedup",
+			Help: `Split files into chunks and store each chunk only once.
+
+If this is set then the data of each file is split into chunks at
+boundaries found from its contents, so files which are mostly the
+same, or different versions of the same file, share most of their
+chunks. Each chunk is encrypted and stored in the ".rclone_chunks"
+directory at the root of the remote under a name made from a keyed
+hash of its contents, so a chunk which is already stored isn't
+uploaded again. The object stored for each file is an encrypted list
+of its chunks.
+
+The size of each file is stored encrypted in the name of the object
+stored for it so listings don't need to read the list of chunks.
+
+Chunks which no file refers to any more are removed by "rclone cleanup".
+
+Files in the remote which weren't written with this set are skipped.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "dedup_chunk_size",
+			Help: `Average size of the chunks when dedup is set.
+
+Chunks are between a quarter of this and four times this size.
+Smaller chunks find more duplicate data but need more transactions to
+upload and download.
+
+Changing this won't corrupt existing files but they won't share chunks
+with files written with a different chunk size.`,
+			Default:  fs.SizeSuffix(1024 * 1024),
+			Advanced: true,
 		}},
 	})
 }
@@ -206,9 +286,49 @@ func newCipherForConfig(opt *Options) (*Cipher, error) {
 	}
 	cipher.setEncryptedSuffix(opt.Suffix)
 	cipher.setPassBadBlocks(opt.PassBadBlocks)
+	if opt.PublicKey != "" || opt.PrivateKey != "" {
+		if opt.NoDataEncryption {
+			return nil, errors.New("public_key and private_key can't be used with no_data_encryption")
+		}
+		var publicKey, privateKey *[publicKeySize]byte
+		if opt.PublicKey != "" {
+			publicKey, err = decodeKey(opt.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode public_key: %w", err)
+			}
+		}
+		if opt.PrivateKey != "" {
+			revealed, err := obscure.Reveal(opt.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt private_key: %w", err)
+			}
+			privateKey, err = decodeKey(revealed)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode private_key: %w", err)
+			}
+		}
+		err = cipher.setKeyPair(publicKey, privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("bad key pair: %w", err)
+		}
+	}
 	return cipher, nil
 }
 
+// decodeKey decodes a base64 encoded X25519 key
+func decodeKey(s string) (*[publicKeySize]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != publicKeySize {
+		return nil, fmt.Errorf("key should be %d bytes but is %d", publicKeySize, len(b))
+	}
+	key := new([publicKeySize]byte)
+	copy(key[:], b)
+	return key, nil
+}
+
 // NewCipher constructs a Cipher for the given config
 func NewCipher(m configmap.Mapper) (*Cipher, error) {
 	// Parse config into Options struct
@@ -263,6 +383,19 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		opt:    *opt,
 		cipher: cipher,
 	}
+	if opt.Dedup {
+		// err may be fs.ErrorIsFile which must be returned
+		var dedupErr error
+		f.dedup, dedupErr = newDedupStore(ctx, opt, cipher)
+		if dedupErr != nil {
+			return nil, dedupErr
+		}
+		// The manifest isn't at the encrypted name of the file
+		// so look for it in the parent directory
+		if rpath != "" && err == nil && f.findRootManifest(ctx) {
+			err = fs.ErrorIsFile
+		}
+	}
 	cache.PinUntilFinalized(f.Fs, f)
 	// Correct root if definitely pointing to a file
 	if err == fs.ErrorIsFile {
@@ -294,6 +427,22 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		PartialUploads:           true,
 	}).Fill(ctx, f).Mask(ctx, wrappedFs).WrapsFs(f, wrappedFs)
 
+	// The sidecars can store these whatever the wrapped Fs supports
+	if opt.MetadataSidecar {
+		f.features.ReadMimeType = true
+		f.features.WriteMimeType = true
+		f.features.ReadMetadata = true
+		f.features.WriteMetadata = true
+		f.features.UserMetadata = true
+	}
+
+	// CleanUp removes the unused chunks and the manifests can't
+	// be shared
+	if opt.Dedup {
+		f.features.CleanUp = f.CleanUp
+		f.features.PublicLink = nil
+	}
+
 	// Enable ListP always
 	f.features.ListP = f.ListP
 
@@ -302,18 +451,23 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote                  string `config:"remote"`
-	FilenameEncryption      string `config:"filename_encryption"`
-	DirectoryNameEncryption bool   `config:"directory_name_encryption"`
-	NoDataEncryption        bool   `config:"no_data_encryption"`
-	Password                string `config:"password"`
-	Password2               string `config:"password2"`
-	ServerSideAcrossConfigs bool   `config:"server_side_across_configs"`
-	ShowMapping             bool   `config:"show_mapping"`
-	PassBadBlocks           bool   `config:"pass_bad_blocks"`
-	FilenameEncoding        string `config:"filename_encoding"`
-	Suffix                  string `config:"suffix"`
-	StrictNames             bool   `config:"strict_names"`
+	Remote                  string        `config:"remote"`
+	FilenameEncryption      string        `config:"filename_encryption"`
+	DirectoryNameEncryption bool          `config:"directory_name_encryption"`
+	NoDataEncryption        bool          `config:"no_data_encryption"`
+	Password                string        `config:"password"`
+	Password2               string        `config:"password2"`
+	ServerSideAcrossConfigs bool          `config:"server_side_across_configs"`
+	ShowMapping             bool          `config:"show_mapping"`
+	PassBadBlocks           bool          `config:"pass_bad_blocks"`
+	FilenameEncoding        string        `config:"filename_encoding"`
+	Suffix                  string        `config:"suffix"`
+	StrictNames             bool          `config:"strict_names"`
+	MetadataSidecar         bool          `config:"metadata_sidecar"`
+	PublicKey               string        `config:"public_key"`
+	PrivateKey              string        `config:"private_key"`
+	Dedup                   bool          `config:"dedup"`
+	DedupChunkSize          fs.SizeSuffix `config:"dedup_chunk_size"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -325,6 +479,7 @@ type Fs struct {
 	opt      Options
 	features *fs.Features // optional features
 	cipher   *Cipher
+	dedup    *dedupStore // set if dedup is in use
 }
 
 // Name of the remote (as passed into NewFs)
@@ -350,7 +505,14 @@ func (f *Fs) String() string {
 // Encrypt an object file name to entries.
 func (f *Fs) add(entries *fs.DirEntries, obj fs.Object) error {
 	remote := obj.Remote()
-	decryptedRemote, err := f.cipher.DecryptFileName(remote)
+	if f.isDedupDir(remote) {
+		return nil
+	}
+	encrypted, _, isManifest := f.parseManifestRemote(remote)
+	if !isManifest {
+		encrypted = remote
+	}
+	decryptedRemote, err := f.cipher.DecryptFileName(encrypted)
 	if err != nil {
 		if f.opt.StrictNames {
 			return fmt.Errorf("%s: undecryptable file name detected: %v", remote, err)
@@ -358,6 +520,10 @@ func (f *Fs) add(entries *fs.DirEntries, obj fs.Object) error {
 		fs.Logf(remote, "Skipping undecryptable file name: %v", err)
 		return nil
 	}
+	if f.dedup != nil && !isManifest && !(f.opt.MetadataSidecar && isSidecar(decryptedRemote)) {
+		fs.Logf(decryptedRemote, "Skipping file which wasn't written with dedup")
+		return nil
+	}
 	if f.opt.ShowMapping {
 		fs.Logf(decryptedRemote, "Encrypts to %q", remote)
 	}
@@ -368,6 +534,9 @@ func (f *Fs) add(entries *fs.DirEntries, obj fs.Object) error {
 // Encrypt a directory file name to entries.
 func (f *Fs) addDir(ctx context.Context, entries *fs.DirEntries, dir fs.Directory) error {
 	remote := dir.Remote()
+	if f.isDedupDir(remote) {
+		return nil
+	}
 	decryptedRemote, err := f.cipher.DecryptDirName(remote)
 	if err != nil {
 		if f.opt.StrictNames {
@@ -384,7 +553,9 @@ func (f *Fs) addDir(ctx context.Context, entries *fs.DirEntries, dir fs.Director
 }
 
 // Encrypt some directory entries.  This alters entries returning it as newEntries.
-func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries) (newEntries fs.DirEntries, err error) {
+//
+// complete should be set if entries is the whole of a directory.
+func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries, complete bool) (newEntries fs.DirEntries, err error) {
 	newEntries = entries[:0] // in place filter
 	errors := 0
 	var firsterr error
@@ -407,6 +578,12 @@ func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries) (newEntr
 	if firsterr != nil {
 		return nil, fmt.Errorf("there were %v undecryptable name errors. first error: %v", errors, firsterr)
 	}
+	if f.dedup != nil {
+		newEntries = newestManifests(ctx, newEntries)
+	}
+	if f.opt.MetadataSidecar {
+		newEntries = attachSidecars(newEntries, complete)
+	}
 	return newEntries, nil
 }
 
@@ -437,23 +614,37 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 // callback returns an error then the listing will stop
 // immediately.
 func (f *Fs) ListP(ctx context.Context, dir string, callback fs.ListRCallback) error {
-	wrappedCallback := func(entries fs.DirEntries) error {
-		entries, err := f.encryptEntries(ctx, entries)
+	listP := f.Fs.Features().ListP
+	encryptedDir := f.cipher.EncryptDirName(dir)
+	// Sidecars and older dedup manifests need to be in the same
+	// tranche as their files so read the whole directory
+	if listP == nil || f.opt.MetadataSidecar || f.dedup != nil {
+		var entries fs.DirEntries
+		var err error
+		if listP == nil {
+			entries, err = f.Fs.List(ctx, encryptedDir)
+		} else {
+			err = listP(ctx, encryptedDir, func(newEntries fs.DirEntries) error {
+				entries = append(entries, newEntries...)
+				return nil
+			})
+		}
+		if err != nil {
+			return err
+		}
+		entries, err = f.encryptEntries(ctx, entries, true)
 		if err != nil {
 			return err
 		}
 		return callback(entries)
 	}
-	listP := f.Fs.Features().ListP
-	encryptedDir := f.cipher.EncryptDirName(dir)
-	if listP == nil {
-		entries, err := f.Fs.List(ctx, encryptedDir)
+	return listP(ctx, encryptedDir, func(entries fs.DirEntries) error {
+		entries, err := f.encryptEntries(ctx, entries, false)
 		if err != nil {
 			return err
 		}
-		return wrappedCallback(entries)
-	}
-	return listP(ctx, encryptedDir, wrappedCallback)
+		return callback(entries)
+	})
 }
 
 // ListR lists the objects and directories of the Fs starting
@@ -474,7 +665,7 @@ func (f *Fs) ListP(ctx context.Context, dir string, callback fs.ListRCallback) e
 // of listing recursively that doing a directory traversal.
 func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
 	return f.Fs.Features().ListR(ctx, f.cipher.EncryptDirName(dir), func(entries fs.DirEntries) error {
-		newEntries, err := f.encryptEntries(ctx, entries)
+		newEntries, err := f.encryptEntries(ctx, entries, false)
 		if err != nil {
 			return err
 		}
@@ -484,17 +675,63 @@ func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (
 
 // NewObject finds the Object at remote.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	if f.opt.MetadataSidecar && isSidecar(remote) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if f.dedup != nil {
+		return f.findManifest(ctx, remote)
+	}
 	o, err := f.Fs.NewObject(ctx, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(o), nil
+	obj := f.newObject(o)
+	if err = obj.findHeaderSize(ctx); err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
 
 type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
 
 // put implements Put or PutStream
 func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption, put putFn) (fs.Object, error) {
+	if err := f.checkRemote(src.Remote()); err != nil {
+		return nil, err
+	}
+	old := f.oldManifest(ctx, src.Remote())
+	o, err := f.putData(ctx, in, src, options, put)
+	if err != nil {
+		return o, err
+	}
+	if f.dedup != nil {
+		f.removeOldManifest(ctx, old, o.(*Object))
+	}
+	if !f.opt.MetadataSidecar {
+		return o, nil
+	}
+	return o, f.putSidecar(ctx, o.(*Object), src, options)
+}
+
+// checkRemote checks the decrypted remote can be uploaded
+func (f *Fs) checkRemote(remote string) error {
+	if f.opt.MetadataSidecar && isSidecar(remote) {
+		return fmt.Errorf("can't upload %q: names ending in %q are reserved for metadata sidecars", remote, sidecarSuffix)
+	}
+	if f.dedup != nil && isDedupDir(path.Join(f.root, remote)) {
+		return fmt.Errorf("can't upload %q: %q is reserved for dedup chunks", remote, dedupDir)
+	}
+	return nil
+}
+
+// isDedupDir returns true if the encrypted remote from a listing of
+// the wrapped Fs is in the directory the dedup chunks are stored in
+func (f *Fs) isDedupDir(remote string) bool {
+	return f.dedup != nil && f.root == "" && isDedupDir(remote)
+}
+
+// putData encrypts and uploads the data of put
+func (f *Fs) putData(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption, put putFn) (_ fs.Object, err error) {
 	ci := fs.GetConfig(ctx)
 
 	if f.opt.NoDataEncryption {
@@ -505,6 +742,19 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 		return o, err
 	}
 
+	// Upload the chunks and store the manifest instead of the data
+	var manifest *dedupManifest
+	var manifestSize int64
+	if f.dedup != nil {
+		var data []byte
+		manifest, data, err = f.dedup.upload(ctx, f, in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload dedup chunks: %w", err)
+		}
+		in = bytes.NewReader(data)
+		manifestSize = int64(len(data))
+	}
+
 	// Encrypt the data into wrappedIn
 	wrappedIn, encrypter, err := f.cipher.encryptData(in)
 	if err != nil {
@@ -533,7 +783,13 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Transfer the data
-	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce), options...)
+	info := f.newObjectInfo(src, encrypter.nonce)
+	info.fileKey = encrypter.fileKey
+	if manifest != nil {
+		info.manifestSize = manifestSize
+		info.dedupSize = manifest.Size
+	}
+	o, err := put(ctx, wrappedIn, info, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -559,7 +815,9 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 		}
 	}
 
-	return f.newObject(o), nil
+	dst := f.newObject(o)
+	dst.manifest = manifest
+	return dst, nil
 }
 
 // Put in to the remote path with the modTime given of the given size
@@ -576,6 +834,14 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 	return f.put(ctx, in, src, options, f.Fs.Features().PutStream)
 }
 
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	if f.opt.MetadataSidecar {
+		return time.Nanosecond
+	}
+	return f.Fs.Precision()
+}
+
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
 	return hash.Set(hash.None)
@@ -655,14 +921,24 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		return nil, fs.ErrorCantCopy
 	}
 	o, ok := src.(*Object)
-	if !ok {
+	if !ok || !f.sameDedup(o.f) {
 		return nil, fs.ErrorCantCopy
 	}
-	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
+	old := f.oldManifest(ctx, remote)
+	oResult, err := do(ctx, o.Object, f.dstRemote(o, remote))
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(oResult), nil
+	dst := f.newObject(oResult)
+	if f.dedup != nil {
+		f.removeOldManifest(ctx, old, dst)
+	}
+	if f.opt.MetadataSidecar {
+		if err = f.copySidecar(ctx, o, dst, false); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
 }
 
 // Move src to this remote using server-side move operations.
@@ -680,14 +956,58 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		return nil, fs.ErrorCantMove
 	}
 	o, ok := src.(*Object)
-	if !ok {
+	if !ok || !f.sameDedup(o.f) {
 		return nil, fs.ErrorCantMove
 	}
-	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
+	old := f.oldManifest(ctx, remote)
+	oResult, err := do(ctx, o.Object, f.dstRemote(o, remote))
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(oResult), nil
+	dst := f.newObject(oResult)
+	if f.dedup != nil {
+		f.removeOldManifest(ctx, old, dst)
+	}
+	if f.opt.MetadataSidecar {
+		// The data has been moved so don't return an error as
+		// the move can't be retried
+		if err = f.copySidecar(ctx, o, dst, true); err != nil {
+			fs.Errorf(dst, "Failed to move metadata sidecar: %v", err)
+		}
+	}
+	return dst, nil
+}
+
+// dstRemote returns the encrypted remote to copy or move src to so
+// it is at the decrypted remote
+func (f *Fs) dstRemote(src *Object, remote string) string {
+	if f.dedup != nil {
+		return f.manifestRemote(f.cipher.EncryptFileName(remote), src.Size())
+	}
+	return f.cipher.EncryptFileName(remote)
+}
+
+// oldManifest returns the manifest of any file at the decrypted
+// remote if dedup is set so it can be removed when it is replaced, or
+// nil if there isn't one
+func (f *Fs) oldManifest(ctx context.Context, remote string) fs.Object {
+	if f.dedup == nil {
+		return nil
+	}
+	o, err := f.findManifest(ctx, remote)
+	if err != nil {
+		return nil
+	}
+	return o.Object
+}
+
+// sameDedup returns true if files can be copied server-side from src
+// to f, which needs them to use the same dedup chunks if dedup is set
+func (f *Fs) sameDedup(src *Fs) bool {
+	if f.dedup == nil && src.dedup == nil {
+		return true
+	}
+	return f.dedup != nil && src.dedup != nil && f.opt.Remote == src.opt.Remote && f.dedup.key == src.dedup.key
 }
 
 // DirMove moves src, srcRemote to this remote at dstRemote
@@ -708,6 +1028,10 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 		fs.Debugf(srcFs, "Can't move directory - not same remote type")
 		return fs.ErrorCantDirMove
 	}
+	if !f.sameDedup(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - dedup chunks not stored in the same place")
+		return fs.ErrorCantDirMove
+	}
 	return do(ctx, srcFs.Fs, f.cipher.EncryptDirName(srcRemote), f.cipher.EncryptDirName(dstRemote))
 }
 
@@ -720,24 +1044,54 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	if do == nil {
 		return nil, errors.New("can't PutUnchecked")
 	}
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
-	if err != nil {
+	if err := f.checkRemote(src.Remote()); err != nil {
 		return nil, err
 	}
-	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce))
-	if err != nil {
-		return nil, err
+	var dst *Object
+	if f.dedup != nil {
+		o, err := f.putData(ctx, in, src, options, do)
+		if err != nil {
+			return nil, err
+		}
+		dst = o.(*Object)
+	} else {
+		wrappedIn, encrypter, err := f.cipher.encryptData(in)
+		if err != nil {
+			return nil, err
+		}
+		info := f.newObjectInfo(src, encrypter.nonce)
+		info.fileKey = encrypter.fileKey
+		o, err := do(ctx, wrappedIn, info)
+		if err != nil {
+			return nil, err
+		}
+		dst = f.newObject(o)
 	}
-	return f.newObject(o), nil
+	if f.opt.MetadataSidecar {
+		if err := f.putSidecar(ctx, dst, src, options); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
 }
 
 // CleanUp the trash in the Fs
 //
 // Implement this if you have a way of emptying the trash or
 // otherwise cleaning up old versions of files.
+//
+// If dedup is set this removes the chunks no file refers to.
 func (f *Fs) CleanUp(ctx context.Context) error {
+	if f.dedup != nil {
+		if err := f.dedup.gc(ctx, f); err != nil {
+			return err
+		}
+	}
 	do := f.Fs.Features().CleanUp
 	if do == nil {
+		if f.dedup != nil {
+			return nil
+		}
 		return errors.New("not supported by underlying remote")
 	}
 	return do(ctx)
@@ -780,8 +1134,10 @@ func (f *Fs) DecryptFileName(encryptedFileName string) (string, error) {
 // computeHashWithNonce takes the nonce and encrypts the contents of
 // src with it, and calculates the hash given by HashType on the fly
 //
+// In public key mode fileKey is the key the file was encrypted with.
+//
 // Note that we break lots of encapsulation in this function.
-func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Object, hashType hash.Type) (hashStr string, err error) {
+func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, fileKey *fileKey, src fs.Object, hashType hash.Type) (hashStr string, err error) {
 	// Open the src for input
 	in, err := src.Open(ctx)
 	if err != nil {
@@ -790,7 +1146,7 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	defer fs.CheckClose(in, &err)
 
 	// Now encrypt the src with the nonce
-	out, err := f.cipher.newEncrypter(in, &nonce)
+	out, err := f.cipher.newEncrypterFileKey(in, &nonce, fileKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to make encrypter: %w", err)
 	}
@@ -816,10 +1172,13 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 	if f.opt.NoDataEncryption {
 		return src.Hash(ctx, hashType)
 	}
+	if f.dedup != nil {
+		return "", errors.New("can't compute the hash of files stored with dedup")
+	}
 
 	// Read the nonce - opening the file is sufficient to read the nonce in
 	// use a limited read so we only read the header
-	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(f.cipher.headerSize) - 1})
 	if err != nil {
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
@@ -828,7 +1187,7 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		_ = in.Close()
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	nonce := d.nonce
+	nonce, fileKey := d.nonce, d.fileKey
 	// fs.Debugf(o, "Read nonce % 2x", nonce)
 
 	// Check nonce isn't all zeros
@@ -848,7 +1207,7 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		return "", fmt.Errorf("failed to close nonce read: %w", err)
 	}
 
-	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+	return f.computeHashWithNonce(ctx, nonce, fileKey, src, hashType)
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -906,6 +1265,10 @@ func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryT
 		case fs.EntryDirectory:
 			decrypted, err = f.cipher.DecryptDirName(path)
 		case fs.EntryObject:
+			// Dedup manifests are named after the file and its size
+			if file, _, ok := f.parseManifestRemote(path); ok {
+				path = file
+			}
 			decrypted, err = f.cipher.DecryptFileName(path)
 		default:
 			fs.Errorf(path, "crypt ChangeNotify: ignoring unknown EntryType %d", entryType)
@@ -915,6 +1278,10 @@ func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryT
 			fs.Logf(f, "ChangeNotify was unable to decrypt %q: %s", path, err)
 			return
 		}
+		if f.opt.MetadataSidecar && entryType == fs.EntryObject && isSidecar(decrypted) {
+			// A changed sidecar means the file has changed
+			decrypted = strings.TrimSuffix(decrypted, sidecarSuffix)
+		}
 		notifyFunc(decrypted, entryType)
 	}
 	do(ctx, wrappedNotifyFunc, pollIntervalChan)
@@ -946,6 +1313,58 @@ Usage examples:
 ` + "```console" + `
 rclone backend decode crypt: encryptedfile1 [encryptedfile2...]
 rclone rc backend/command command=decode fs=crypt: encryptedfile1 [encryptedfile2...]
+` + "```",
+	},
+	{
+		Name:  "rekey",
+		Short: "Re-encrypt the remote with a new password.",
+		Long: `This re-encrypts the names and contents of all the files in the crypt
+remote with a new password, in place on the underlying remote.
+
+Each file is uploaded encrypted with the new password, checked against
+the original in the same way as cryptcheck does and then the original
+is removed. If no_data_encryption is set the files are copied
+server-side if possible.
+
+Progress is recorded in a journal so if the rekey is interrupted it
+can be run again with the same passwords to carry on where it left
+off. Until it finishes the remote must be used with the old password.
+
+When it has finished the passwords in the config file are changed to
+the new ones if the remote is defined there, otherwise they must be
+changed by hand.
+
+It must be run on the root of the crypt remote.
+
+Usage examples:
+
+` + "```console" + `
+rclone backend rekey crypt: -o password=NEWPASSWORD
+rclone backend rekey crypt: -o password=NEWPASSWORD -o password2=NEWSALT
+rclone rc backend/command command=rekey fs=crypt: -o password=NEWPASSWORD
+` + "```",
+		Opts: map[string]string{
+			"password":  "The new password.",
+			"password2": "The new password2 (salt) - if not set no salt is used.",
+			"journal":   "Path of the journal - default is in the cache directory.",
+		},
+	},
+	{
+		Name:  "keygen",
+		Short: "Make a new key pair for public_key and private_key.",
+		Long: `This makes a new random X25519 key pair for use with the public_key
+and private_key options and prints them base64 encoded.
+
+Keep the private key safe - without it the files can't be read back.
+The private key is printed in plain text, so it should be entered with
+"rclone config" or obscured with "rclone obscure" before putting it in
+the config file.
+
+Usage examples:
+
+` + "```console" + `
+rclone backend keygen crypt:
+rclone rc backend/command command=keygen fs=crypt:
 ` + "```",
 	},
 }
@@ -978,6 +1397,17 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			out = append(out, encryptedFileName)
 		}
 		return out, nil
+	case "rekey":
+		return f.rekeyCommand(ctx, opt)
+	case "keygen":
+		publicKey, privateKey, err := NewKeyPair()
+		if err != nil {
+			return nil, fmt.Errorf("failed to make key pair: %w", err)
+		}
+		return map[string]string{
+			"public_key":  base64.StdEncoding.EncodeToString(publicKey[:]),
+			"private_key": base64.StdEncoding.EncodeToString(privateKey[:]),
+		}, nil
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -989,13 +1419,28 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 type Object struct {
 	fs.Object
 	f *Fs
+
+	mu          sync.Mutex   // protects the sidecar fields and headerLen
+	sidecar     *sidecarInfo // contents of the sidecar if read
+	sidecarRead bool         // set if sidecar has been read
+	sidecarObj  fs.Object    // sidecar found when listing, if any
+	headerLen   int          // size of the encryption header or 0 if not known
+
+	manifestMu sync.Mutex     // protects manifest and dedupSize
+	manifest   *dedupManifest // dedup manifest if read
+	dedupSize  int64          // size from the name of the dedup manifest or -1 if not known
 }
 
 func (f *Fs) newObject(o fs.Object) *Object {
-	return &Object{
-		Object: o,
-		f:      f,
+	obj := &Object{
+		Object:    o,
+		f:         f,
+		dedupSize: -1,
+	}
+	if _, size, ok := f.parseManifestRemote(o.Remote()); ok {
+		obj.dedupSize = size
 	}
+	return obj
 }
 
 // Fs returns read only access to the Fs that this object is part of
@@ -1014,6 +1459,9 @@ func (o *Object) String() string {
 // Remote returns the remote path
 func (o *Object) Remote() string {
 	remote := o.Object.Remote()
+	if file, _, ok := o.f.parseManifestRemote(remote); ok {
+		remote = file
+	}
 	decryptedName, err := o.f.cipher.DecryptFileName(remote)
 	if err != nil {
 		fs.Debugf(remote, "Undecryptable file name: %v", err)
@@ -1024,10 +1472,15 @@ func (o *Object) Remote() string {
 
 // Size returns the size of the file
 func (o *Object) Size() int64 {
+	if o.f.dedup != nil {
+		o.manifestMu.Lock()
+		defer o.manifestMu.Unlock()
+		return o.dedupSize
+	}
 	size := o.Object.Size()
 	if !o.f.opt.NoDataEncryption {
 		var err error
-		size, err = o.f.cipher.DecryptedSize(size)
+		size, err = decryptedSize(size, o.headerSize(size))
 		if err != nil {
 			fs.Debugf(o, "Bad size for decrypt: %v", err)
 		}
@@ -1035,6 +1488,83 @@ func (o *Object) Size() int64 {
 	return size
 }
 
+// headerSize returns the size of the encryption header of o, which
+// has the encrypted size given
+//
+// In public key mode files written before the key pair was set have
+// the shorter password header. If both header sizes fit the size and
+// findHeaderSize hasn't read the header, o is assumed to have the
+// header of new files.
+func (o *Object) headerSize(size int64) int {
+	headerLen, ok := o.knownHeaderSize(size)
+	if !ok {
+		return o.f.cipher.headerSize
+	}
+	return headerLen
+}
+
+// knownHeaderSize returns the size of the encryption header of o,
+// which has the encrypted size given, if it is known without reading
+// the header
+func (o *Object) knownHeaderSize(size int64) (headerLen int, ok bool) {
+	if o.f.cipher.headerSize == fileHeaderSize {
+		return fileHeaderSize, true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.headerLen != 0 {
+		return o.headerLen, true
+	}
+	_, errPublicKey := decryptedSize(size, publicKeyHeaderSize)
+	_, errPassword := decryptedSize(size, fileHeaderSize)
+	switch {
+	case errPassword != nil:
+		o.headerLen = publicKeyHeaderSize
+	case errPublicKey != nil:
+		o.headerLen = fileHeaderSize
+	default:
+		return 0, false
+	}
+	return o.headerLen, true
+}
+
+// findHeaderSize reads the magic of o to find the size of its
+// encryption header if it can't be told from the encrypted size
+func (o *Object) findHeaderSize(ctx context.Context) error {
+	if o.f.opt.NoDataEncryption || o.f.dedup != nil {
+		return nil
+	}
+	if _, ok := o.knownHeaderSize(o.Object.Size()); ok {
+		return nil
+	}
+	magic, err := o.readMagic(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read header to find size: %w", err)
+	}
+	o.mu.Lock()
+	o.headerLen = publicKeyHeaderSize
+	if magic == fileMagic {
+		o.headerLen = fileHeaderSize
+	}
+	o.mu.Unlock()
+	return nil
+}
+
+// readMagic reads the magic from the start of the encrypted file
+func (o *Object) readMagic(ctx context.Context) (magic string, err error) {
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileMagicSize) - 1})
+	if err != nil {
+		return "", err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, fileMagicSize)
+	_, err = io.ReadFull(in, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
 // Hash returns the selected checksum of the file
 // If no checksum is available it returns ""
 func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
@@ -1051,6 +1581,10 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	if o.f.opt.NoDataEncryption {
 		return o.Object.Open(ctx, options...)
 	}
+	// Find the size of listed files for the range options
+	if err = o.findHeaderSize(ctx); err != nil {
+		return nil, err
+	}
 
 	var openOptions []fs.OpenOption
 	var offset, limit int64 = 0, -1
@@ -1065,26 +1599,35 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 			openOptions = append(openOptions, option)
 		}
 	}
-	rc, err = o.f.cipher.DecryptDataSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+	if o.f.dedup != nil {
+		return o.openDedup(ctx, offset, limit, openOptions)
+	}
+	rc, err = o.f.cipher.DecryptDataSeek(ctx, openRangeSeek(o.Object, openOptions), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// openRangeSeek returns a function to open ranges of the underlying
+// object o with openOptions
+func openRangeSeek(o fs.Object, openOptions []fs.OpenOption) OpenRangeSeek {
+	return func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
 		if underlyingOffset == 0 && underlyingLimit < 0 {
 			// Open with no seek
-			return o.Object.Open(ctx, openOptions...)
+			return o.Open(ctx, openOptions...)
 		}
 		// Open stream with a range of underlyingOffset, underlyingLimit
 		end := int64(-1)
 		if underlyingLimit >= 0 {
 			end = underlyingOffset + underlyingLimit - 1
-			if end >= o.Object.Size() {
+			if end >= o.Size() {
 				end = -1
 			}
 		}
 		newOpenOptions := append(openOptions, &fs.RangeOption{Start: underlyingOffset, End: end})
-		return o.Object.Open(ctx, newOpenOptions...)
-	}, offset, limit)
-	if err != nil {
-		return nil, err
+		return o.Open(ctx, newOpenOptions...)
 	}
-	return rc, nil
 }
 
 // Update in to the object with the modTime given of the given size
@@ -1092,8 +1635,67 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	update := func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 		return o.Object, o.Object.Update(ctx, in, src, options...)
 	}
-	_, err := o.f.put(ctx, in, src, options, update)
-	return err
+	if o.f.dedup != nil {
+		// The manifest is named after the size of the file so
+		// put a new one and remove the old one if that changes
+		src = fs.NewOverrideRemote(src, o.Remote())
+		update = o.f.Fs.Put
+	}
+	newO, err := o.f.putData(ctx, in, src, options, update)
+	if err != nil {
+		return err
+	}
+	if o.f.dedup != nil {
+		old := o.Object
+		o.setManifest(newO.(*Object))
+		o.f.removeOldManifest(ctx, old, o)
+	}
+	// The data has been rewritten with the header of new files
+	o.mu.Lock()
+	o.headerLen = o.f.cipher.headerSize
+	o.mu.Unlock()
+	if !o.f.opt.MetadataSidecar {
+		return nil
+	}
+	return o.f.putSidecar(ctx, o, src, options)
+}
+
+// ModTime returns the modification time of the object
+//
+// This is read from the sidecar if there is one
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	info, err := o.readSidecar(ctx)
+	if err != nil {
+		fs.Errorf(o, "Failed to read modification time: %v", err)
+	}
+	if info != nil {
+		return info.ModTime
+	}
+	return o.Object.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of the object
+//
+// This is stored in the sidecar if metadata_sidecar is set
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if !o.f.opt.MetadataSidecar {
+		return o.Object.SetModTime(ctx, modTime)
+	}
+	return o.updateSidecar(ctx, func(info *sidecarInfo) {
+		info.ModTime = modTime
+	})
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	err := o.Object.Remove(ctx)
+	if err != nil {
+		return err
+	}
+	if !o.f.opt.MetadataSidecar {
+		return nil
+	}
+	return o.f.removeSidecar(ctx, o.Remote())
 }
 
 // newDir returns a dir with the Name decrypted
@@ -1142,8 +1744,11 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 // This encrypts the remote name and adjusts the size
 type ObjectInfo struct {
 	fs.ObjectInfo
-	f     *Fs
-	nonce nonce
+	f            *Fs
+	nonce        nonce
+	fileKey      *fileKey // file key in public key mode
+	manifestSize int64    // size of the dedup manifest uploaded instead of src if set
+	dedupSize    int64    // size of src if a dedup manifest is uploaded instead
 }
 
 func (f *Fs) newObjectInfo(src fs.ObjectInfo, nonce nonce) *ObjectInfo {
@@ -1161,11 +1766,18 @@ func (o *ObjectInfo) Fs() fs.Info {
 
 // Remote returns the remote path
 func (o *ObjectInfo) Remote() string {
-	return o.f.cipher.EncryptFileName(o.ObjectInfo.Remote())
+	remote := o.f.cipher.EncryptFileName(o.ObjectInfo.Remote())
+	if o.manifestSize > 0 {
+		remote = o.f.manifestRemote(remote, o.dedupSize)
+	}
+	return remote
 }
 
 // Size returns the size of the file
 func (o *ObjectInfo) Size() int64 {
+	if o.manifestSize > 0 {
+		return o.f.cipher.EncryptedSize(o.manifestSize)
+	}
 	size := o.ObjectInfo.Size()
 	if size < 0 {
 		return size
@@ -1179,6 +1791,10 @@ func (o *ObjectInfo) Size() int64 {
 // Hash returns the selected checksum of the file
 // If no checksum is available it returns ""
 func (o *ObjectInfo) Hash(ctx context.Context, hash hash.Type) (string, error) {
+	if o.manifestSize > 0 {
+		// The data uploaded isn't the data of src
+		return "", nil
+	}
 	var srcObj fs.Object
 	var ok bool
 	// Get the underlying object if there is one
@@ -1195,7 +1811,7 @@ func (o *ObjectInfo) Hash(ctx context.Context, hash hash.Type) (string, error) {
 	if srcObj.Fs().Features().IsLocal {
 		// Read the data and encrypt it to calculate the hash
 		fs.Debugf(o, "Computing %v hash of encrypted source", hash)
-		return o.f.computeHashWithNonce(ctx, o.nonce, srcObj, hash)
+		return o.f.computeHashWithNonce(ctx, o.nonce, o.fileKey, srcObj, hash)
 	}
 	return "", nil
 }
@@ -1275,18 +1891,37 @@ func (o *Object) GetTier() string {
 // Metadata returns metadata for an object
 //
 // It should return nil if there is no Metadata
-func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
-	do, ok := o.Object.(fs.Metadataer)
-	if !ok {
-		return nil, nil
+func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error) {
+	if do, ok := o.Object.(fs.Metadataer); ok {
+		metadata, err = do.Metadata(ctx)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return do.Metadata(ctx)
+	info, err := o.readSidecar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return metadata, nil
+	}
+	metadata.Merge(info.Metadata)
+	metadata.Set("mtime", info.ModTime.Format(time.RFC3339Nano))
+	if info.MimeType != "" {
+		metadata.Set("content-type", info.MimeType)
+	}
+	return metadata, nil
 }
 
 // SetMetadata sets metadata for an Object
 //
 // It should return fs.ErrorNotImplemented if it can't set metadata
 func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	if o.f.opt.MetadataSidecar {
+		return o.updateSidecar(ctx, func(info *sidecarInfo) {
+			info.setMetadata(metadata)
+		})
+	}
 	do, ok := o.Object.(fs.SetMetadataer)
 	if !ok {
 		return fs.ErrorNotImplemented
@@ -1298,8 +1933,15 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 // known, or "" if not
 //
 // This is deliberately unsupported so we don't leak mime type info by
-// default.
+// default, unless it is stored in an encrypted sidecar.
 func (o *Object) MimeType(ctx context.Context) string {
+	info, err := o.readSidecar(ctx)
+	if err != nil {
+		fs.Errorf(o, "Failed to read mime type: %v", err)
+	}
+	if info != nil {
+		return info.MimeType
+	}
 	return ""
 }
 
@@ -6,12 +6,18 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"maps"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/lib/random"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -61,7 +67,7 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 	var outBuf bytes.Buffer
 	enc, err := f.cipher.newEncrypter(inBuf, nil)
 	require.NoError(t, err)
-	nonce := enc.nonce // read the nonce at the start
+	nonce, fileKey := enc.nonce, enc.fileKey // read the nonce and key at the start
 	_, err = io.Copy(&outBuf, enc)
 	require.NoError(t, err)
 
@@ -71,9 +77,10 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 		oi = fs.NewOverrideRemote(oi, "new_remote")
 	}
 
-	// wrap the object in a crypt for upload using the nonce we
-	// saved from the encrypter
+	// wrap the object in a crypt for upload using the nonce and
+	// file key we saved from the encrypter
 	src := f.newObjectInfo(oi, nonce)
+	src.fileKey = fileKey
 
 	// Test ObjectInfo methods
 	if !f.opt.NoDataEncryption {
@@ -100,6 +107,9 @@ func testComputeHash(t *testing.T, f *Fs) {
 	if hashType == hash.None {
 		t.Skipf("%v: does not support hashes", f.Fs)
 	}
+	if f.dedup != nil {
+		t.Skip("ComputeHash isn't supported with dedup")
+	}
 
 	localFs := makeTempLocalFs(t)
 
@@ -119,9 +129,667 @@ func testComputeHash(t *testing.T, f *Fs) {
 	assert.Equal(t, remoteObjHash, computedHash)
 }
 
+// Test the modification time and metadata are read from the sidecar
+func testMetadataSidecar(t *testing.T, f *Fs) {
+	if !f.opt.MetadataSidecar {
+		t.Skip("metadata_sidecar not set")
+	}
+	var (
+		contents = random.String(100)
+		path     = "sidecar_test"
+		ctx      = context.Background()
+		t1       = time.Date(2012, time.December, 17, 18, 32, 31, 123456789, time.UTC)
+		t2       = time.Date(2020, time.March, 1, 2, 3, 4, 0, time.UTC)
+	)
+
+	obj := uploadFile(t, f, path, contents)
+	o := obj.(*Object)
+
+	// Change the modification time on the underlying remote as if
+	// it couldn't store it
+	require.NoError(t, o.Object.SetModTime(ctx, t2))
+
+	// The original is read back from the sidecar
+	newObj, err := f.NewObject(ctx, path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC), newObj.ModTime(ctx).UTC())
+	assert.Equal(t, int64(len(contents)), newObj.Size())
+
+	// SetModTime writes the sidecar
+	require.NoError(t, newObj.SetModTime(ctx, t1))
+	newObj, err = f.NewObject(ctx, path)
+	require.NoError(t, err)
+	assert.True(t, t1.Equal(newObj.ModTime(ctx)))
+	metadata, err := newObj.(*Object).Metadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, t1.Format(time.RFC3339Nano), metadata["mtime"])
+
+	// The sidecar isn't listed or found
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, isSidecar(entry.Remote()), entry.Remote())
+	}
+	_, err = f.NewObject(ctx, path+sidecarSuffix)
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// The sidecar is found from the listing
+	for _, entry := range entries {
+		if entry.Remote() == path {
+			assert.NotNil(t, entry.(*Object).sidecarObj)
+			assert.True(t, t1.Equal(entry.(*Object).ModTime(ctx)))
+		}
+	}
+
+	// Files listed without a sidecar are known not to have one
+	_ = uploadFile(t, f, "no_sidecar_test", contents)
+	require.NoError(t, f.removeSidecar(ctx, "no_sidecar_test"))
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	found := false
+	for _, entry := range entries {
+		if entry.Remote() == "no_sidecar_test" {
+			found = true
+			assert.True(t, entry.(*Object).sidecarRead)
+			assert.Nil(t, entry.(*Object).sidecar)
+		}
+	}
+	assert.True(t, found)
+
+	// Names ending in the suffix can't be uploaded
+	src := object.NewStaticObjectInfo(path+sidecarSuffix, t1, 0, true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewBufferString(""), src)
+	assert.Error(t, err)
+
+	// A sidecar which doesn't match the size of the data is ignored
+	info := &sidecarInfo{ModTime: t1, Size: 1}
+	require.NoError(t, f.writeSidecar(ctx, path, info))
+	newObj, err = f.NewObject(ctx, path)
+	require.NoError(t, err)
+	assert.True(t, t2.Equal(newObj.ModTime(ctx)))
+}
+
+// Test the sidecar is encrypted and authenticated
+func testSidecarEncoding(t *testing.T, f *Fs) {
+	info := &sidecarInfo{
+		Version:  sidecarVersion,
+		ModTime:  time.Date(2012, time.December, 17, 18, 32, 31, 123456789, time.UTC),
+		Size:     100,
+		MimeType: "audio/flac",
+		Metadata: fs.Metadata{"artist": "potato"},
+	}
+	data, err := f.encodeSidecar(info)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "potato")
+
+	got, err := f.decodeSidecar(data)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime.Equal(got.ModTime))
+	got.ModTime = info.ModTime
+	assert.Equal(t, info, got)
+
+	data[len(data)-1] ^= 1
+	_, err = f.decodeSidecar(data)
+	assert.Error(t, err)
+}
+
 // InternalTest is called by fstests.Run to extra tests
 func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("ObjectInfo", func(t *testing.T) { testObjectInfo(t, f, false) })
 	t.Run("ObjectInfoWrap", func(t *testing.T) { testObjectInfo(t, f, true) })
 	t.Run("ComputeHash", func(t *testing.T) { testComputeHash(t, f) })
+	t.Run("MetadataSidecar", func(t *testing.T) { testMetadataSidecar(t, f) })
+	t.Run("SidecarEncoding", func(t *testing.T) { testSidecarEncoding(t, f) })
+}
+
+// Make a crypt Fs on the local directory dir for testing
+func makeTestCrypt(t *testing.T, dir string, config configmap.Simple) *Fs {
+	config["remote"] = dir
+	for k, v := range map[string]string{
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"directory_name_encryption": "true",
+		"filename_encoding":         "base32",
+		"suffix":                    ".bin",
+	} {
+		if _, ok := config[k]; !ok {
+			config[k] = v
+		}
+	}
+	f, err := NewFs(context.Background(), "rekeytest", "", config)
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+func testRekey(t *testing.T, config configmap.Simple, interrupt bool) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	journal := filepath.Join(t.TempDir(), "journal.json")
+	t1 := time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC)
+	files := map[string]string{
+		"file1.txt":         "potato",
+		"dir/file2.txt":     random.String(1000),
+		"dir/sub/file3.txt": "",
+	}
+	f := makeTestCrypt(t, dir, config)
+	for remote, contents := range files {
+		src := object.NewStaticObjectInfo(remote, t1, int64(len(contents)), true, nil, nil)
+		_, err := f.Put(ctx, bytes.NewBufferString(contents), src)
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Mkdir(ctx, "empty"))
+
+	opt := map[string]string{
+		"password": "new potato",
+		"journal":  journal,
+	}
+	if interrupt {
+		// Rekey one file and stop before removing the original
+		newOpt := f.opt
+		newOpt.Password = obscure.MustObscure(opt["password"])
+		newCipher, err := newCipherForConfig(&newOpt)
+		require.NoError(t, err)
+		j, err := loadRekeyJournal(journal, rekeyCheck(f.cipher, newCipher))
+		require.NoError(t, err)
+		r := &rekeyer{f: f, newF: &Fs{Fs: f.Fs, name: f.name, opt: newOpt, cipher: newCipher}, journal: j}
+		name := f.cipher.EncryptFileName("file1.txt")
+		oldBase, err := f.Fs.NewObject(ctx, name)
+		require.NoError(t, err)
+		entry := &rekeyEntry{State: rekeyCopied, Target: newCipher.EncryptFileName("file1.txt")}
+		entry.Final = entry.Target
+		if entry.Target == name {
+			entry.Target += rekeyTempSuffix
+		}
+		require.NoError(t, r.copyData(ctx, "file1.txt", oldBase, entry.Target))
+		require.NoError(t, j.set(name, entry))
+
+		// A different password can't use the journal
+		_, err = f.Command(ctx, "rekey", nil, map[string]string{"password": "wrong", "journal": journal})
+		assert.ErrorContains(t, err, "different remote or password")
+	}
+
+	out, err := f.Command(ctx, "rekey", nil, opt)
+	require.NoError(t, err)
+	result := out.(*rekeyResult)
+	assert.Equal(t, int64(len(files)), result.Files)
+	assert.False(t, result.ConfigSaved)
+	_, err = os.Stat(journal)
+	assert.True(t, os.IsNotExist(err))
+
+	// The old password can't read the files
+	config["password"] = obscure.MustObscure("potato")
+	oldF := makeTestCrypt(t, dir, config)
+	for remote, contents := range files {
+		o, err := oldF.NewObject(ctx, remote)
+		if err != nil || oldF.opt.NoDataEncryption || contents == "" {
+			continue
+		}
+		in, err := o.Open(ctx)
+		require.NoError(t, err)
+		_, err = io.ReadAll(in)
+		assert.Error(t, err, remote)
+		_ = in.Close()
+	}
+
+	// The new password reads the same files
+	config["password"] = obscure.MustObscure("new potato")
+	for _, newF := range []*Fs{f, makeTestCrypt(t, dir, config)} {
+		for remote, contents := range files {
+			o, err := newF.NewObject(ctx, remote)
+			require.NoError(t, err, remote)
+			in, err := o.Open(ctx)
+			require.NoError(t, err)
+			got, err := io.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.Equal(t, contents, string(got), remote)
+			if newF.opt.MetadataSidecar {
+				assert.True(t, t1.Equal(o.ModTime(ctx)), remote)
+			}
+		}
+		entries, err := newF.List(ctx, "")
+		require.NoError(t, err)
+		assert.Len(t, entries, 3, entries) // file1.txt, dir, empty
+	}
+
+	// Nothing is left on the underlying remote but the new files
+	count := 0
+	require.NoError(t, walk.ListR(ctx, f.Fs, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			_, err := f.cipher.DecryptFileName(entry.Remote())
+			assert.NoError(t, err, entry.Remote())
+			count++
+		}
+		return nil
+	}))
+	if f.opt.MetadataSidecar {
+		assert.Equal(t, 2*len(files), count)
+	} else {
+		assert.Equal(t, len(files), count)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config configmap.Simple
+	}{
+		{"Standard", configmap.Simple{}},
+		{"Off", configmap.Simple{"filename_encryption": "off"}},
+		{"NoDataEncryption", configmap.Simple{"no_data_encryption": "true"}},
+		{"Sidecar", configmap.Simple{"metadata_sidecar": "true"}},
+		{"SidecarOff", configmap.Simple{"metadata_sidecar": "true", "filename_encryption": "off"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testRekey(t, maps.Clone(test.config), false)
+		})
+		t.Run(test.name+"Interrupted", func(t *testing.T) {
+			testRekey(t, maps.Clone(test.config), true)
+		})
+	}
+}
+
+// Test a remote with only the public key can write files which only
+// the remote with the private key can read.
+func TestPublicKeyWriteOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	contents := random.String(1000)
+	t1 := time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC)
+
+	keys, err := makeTestCrypt(t, dir, configmap.Simple{}).Command(ctx, "keygen", nil, nil)
+	require.NoError(t, err)
+	keyPair := keys.(map[string]string)
+
+	writer := makeTestCrypt(t, dir, configmap.Simple{
+		"public_key": keyPair["public_key"],
+	})
+	reader := makeTestCrypt(t, dir, configmap.Simple{
+		"private_key": obscure.MustObscure(keyPair["private_key"]),
+	})
+
+	src := object.NewStaticObjectInfo("file.txt", t1, int64(len(contents)), true, nil, nil)
+	obj, err := writer.Put(ctx, bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), obj.Size())
+
+	// The writer can list the file but not read it
+	obj, err = writer.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), obj.Size())
+	_, err = obj.Open(ctx)
+	assert.ErrorIs(t, err, ErrorNoPrivateKey)
+
+	// The reader can read it
+	obj, err = reader.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, contents, string(got))
+
+	// A mismatched key pair is rejected
+	otherKeys, err := writer.Command(ctx, "keygen", nil, nil)
+	require.NoError(t, err)
+	_, err = NewFs(ctx, "publickeytest", "", configmap.Simple{
+		"remote":                    dir,
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"directory_name_encryption": "true",
+		"filename_encoding":         "base32",
+		"suffix":                    ".bin",
+		"public_key":                keyPair["public_key"],
+		"private_key":               obscure.MustObscure(otherKeys.(map[string]string)["private_key"]),
+	})
+	assert.ErrorContains(t, err, "doesn't match")
+}
+
+// Test files written with the password can still be read once a key
+// pair is set.
+func TestPublicKeyOldFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	t1 := time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC)
+
+	plain := makeTestCrypt(t, dir, configmap.Simple{})
+	keys, err := plain.Command(ctx, "keygen", nil, nil)
+	require.NoError(t, err)
+	keyPair := keys.(map[string]string)
+	writer := makeTestCrypt(t, dir, configmap.Simple{
+		"public_key": keyPair["public_key"],
+	})
+	reader := makeTestCrypt(t, dir, configmap.Simple{
+		"private_key": obscure.MustObscure(keyPair["private_key"]),
+	})
+
+	sizes := []int{0, 1000, 150000}
+	for _, size := range sizes {
+		contents := random.String(size)
+		remote := fmt.Sprintf("file%d.txt", size)
+		src := object.NewStaticObjectInfo(remote, t1, int64(size), true, nil, nil)
+		_, err := plain.Put(ctx, bytes.NewBufferString(contents), src)
+		require.NoError(t, err)
+
+		// Both key pair remotes find the right size
+		obj, err := writer.NewObject(ctx, remote)
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), obj.Size())
+		obj, err = reader.NewObject(ctx, remote)
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), obj.Size())
+
+		// Listings don't read the header so may assume the new
+		// one, but opening the file reads it
+		entries, err := reader.List(ctx, "")
+		require.NoError(t, err)
+		var listed fs.Object
+		for _, entry := range entries {
+			if entry.Remote() == remote {
+				listed = entry.(fs.Object)
+			}
+		}
+		require.NotNil(t, listed)
+		if _, ok := listed.(*Object).knownHeaderSize(listed.(*Object).Object.Size()); !ok {
+			assert.Equal(t, int64(size-sealedKeySize), listed.Size())
+		}
+		in, err := listed.Open(ctx)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.Equal(t, int64(size), listed.Size())
+
+		// And can read it, including with a seek
+		in, err = obj.Open(ctx)
+		require.NoError(t, err)
+		got, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.Equal(t, contents, string(got))
+		if size > 0 {
+			offset := int64(size / 2)
+			in, err = obj.Open(ctx, &fs.SeekOption{Offset: offset})
+			require.NoError(t, err)
+			got, err = io.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.Equal(t, contents[offset:], string(got))
+		}
+
+		// Updating it writes the public key header
+		contents = random.String(size + 1)
+		src = object.NewStaticObjectInfo(remote, t1, int64(size+1), true, nil, nil)
+		require.NoError(t, obj.Update(ctx, bytes.NewBufferString(contents), src))
+		assert.Equal(t, int64(size+1), obj.Size())
+		in, err = obj.Open(ctx)
+		require.NoError(t, err)
+		got, err = io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.Equal(t, contents, string(got))
+		obj, err = plain.NewObject(ctx, remote)
+		require.NoError(t, err)
+		_, err = obj.Open(ctx)
+		assert.ErrorIs(t, err, ErrorEncryptedBadMagic)
+	}
+}
+
+// Count the dedup chunks stored in dir
+func countChunks(t *testing.T, dir string) (n int) {
+	err := filepath.Walk(filepath.Join(dir, dedupDir), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func TestDedupChunks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	t1 := time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC)
+	f := makeTestCrypt(t, dir, configmap.Simple{
+		"dedup":            "true",
+		"dedup_chunk_size": "64k",
+	})
+	require.NotNil(t, f.dedup)
+
+	put := func(remote, contents string) fs.Object {
+		src := object.NewStaticObjectInfo(remote, t1, int64(len(contents)), true, nil, nil)
+		obj, err := f.Put(ctx, bytes.NewBufferString(contents), src)
+		require.NoError(t, err)
+		return obj
+	}
+	read := func(remote string, options ...fs.OpenOption) string {
+		obj, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		in, err := obj.Open(ctx, options...)
+		require.NoError(t, err)
+		got, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		return string(got)
+	}
+
+	// Upload a file and a copy of it with a few bytes inserted at the start
+	contents1 := random.String(1024 * 1024)
+	contents2 := random.String(100) + contents1
+	obj1 := put("file1.bin", contents1)
+	chunks1 := countChunks(t, dir)
+	assert.Greater(t, chunks1, 4)
+	obj2 := put("dir/file2.bin", contents2)
+	chunks2 := countChunks(t, dir)
+	assert.LessOrEqual(t, chunks2-chunks1, 2, "most chunks should be shared")
+
+	// The chunks directory isn't listed
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// Check the files read back properly
+	assert.Equal(t, int64(len(contents1)), obj1.Size())
+	assert.Equal(t, int64(len(contents2)), obj2.Size())
+	obj, err := f.NewObject(ctx, "dir/file2.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents2)), obj.Size())
+	assert.Equal(t, contents1, read("file1.bin"))
+	assert.Equal(t, contents2, read("dir/file2.bin"))
+	assert.Equal(t, contents2[300000:700000], read("dir/file2.bin", &fs.RangeOption{Start: 300000, End: 699999}))
+	assert.Equal(t, contents2[1000000:], read("dir/file2.bin", &fs.SeekOption{Offset: 1000000}))
+
+	// Server-side moves keep the chunks
+	obj1, err = f.Move(ctx, obj1, "file3.bin")
+	require.NoError(t, err)
+	assert.Equal(t, chunks2, countChunks(t, dir))
+	assert.Equal(t, contents1, read("file3.bin"))
+
+	// CleanUp doesn't remove new chunks
+	require.NoError(t, obj2.Remove(ctx))
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, chunks2, countChunks(t, dir))
+
+	// Or chunks which are still used
+	f.dedup.minAge = 0
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, chunks1, countChunks(t, dir))
+	assert.Equal(t, contents1, read("file3.bin"))
+
+	// Or anything if a manifest can't be read
+	bad := filepath.Join(dir, f.manifestRemote(f.cipher.EncryptFileName("bad.bin"), 6))
+	require.NoError(t, os.WriteFile(bad, []byte("potato"), 0666))
+	require.NoError(t, obj1.Remove(ctx))
+	assert.Error(t, f.CleanUp(ctx))
+	assert.Equal(t, chunks1, countChunks(t, dir))
+
+	// Until it is removed, but files which weren't written with
+	// dedup are skipped
+	require.NoError(t, os.Remove(bad))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, f.cipher.EncryptFileName("plain.bin")), []byte("potato"), 0666))
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, 0, countChunks(t, dir))
+
+	// Old chunks which are reused are refreshed so a concurrent
+	// CleanUp doesn't remove them
+	f.dedup.minAge = dedupGCMinAge
+	put("file4.bin", contents1)
+	old := time.Now().Add(-2 * dedupGCMinAge)
+	chunkDir := filepath.Join(dir, dedupDir)
+	walkChunks := func(walk func(path string, info os.FileInfo) error) {
+		err := filepath.Walk(chunkDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			return walk(path, info)
+		})
+		require.NoError(t, err)
+	}
+	walkChunks(func(path string, info os.FileInfo) error {
+		return os.Chtimes(path, old, old)
+	})
+	f.dedup.known.Clear()
+	put("file5.bin", contents1)
+	walkChunks(func(path string, info os.FileInfo) error {
+		assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute, path)
+		return nil
+	})
+}
+
+func TestDedupManifestNames(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	t1 := time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC)
+	f := makeTestCrypt(t, dir, configmap.Simple{
+		"dedup":            "true",
+		"dedup_chunk_size": "64k",
+	})
+
+	put := func(remote string, size int) {
+		src := object.NewStaticObjectInfo(remote, t1, int64(size), true, nil, nil)
+		_, err := f.Put(ctx, bytes.NewBufferString(random.String(size)), src)
+		require.NoError(t, err)
+	}
+	// Read the sizes from the names of the manifests by decrypted remote
+	manifests := func() map[string][]int64 {
+		entries, err := f.Fs.List(ctx, "")
+		require.NoError(t, err)
+		sizes := make(map[string][]int64)
+		for _, entry := range entries {
+			if file, size, ok := f.parseManifestRemote(entry.Remote()); ok {
+				remote, err := f.cipher.DecryptFileName(file)
+				require.NoError(t, err)
+				sizes[remote] = append(sizes[remote], size)
+			}
+		}
+		return sizes
+	}
+	list := func() (sizes map[string]int64, manifestsRead int) {
+		entries, err := f.List(ctx, "")
+		require.NoError(t, err)
+		sizes = make(map[string]int64)
+		for _, entry := range entries {
+			o := entry.(*Object)
+			sizes[o.Remote()] = o.Size()
+			if o.manifest != nil {
+				manifestsRead++
+			}
+		}
+		return sizes, manifestsRead
+	}
+
+	// Other names aren't manifests
+	_, _, ok := f.parseManifestRemote(f.cipher.EncryptFileName("file.txt"))
+	assert.False(t, ok)
+	_, _, ok = f.parseManifestRemote(f.cipher.EncryptFileName("file.txt") + ".0123456789abcdefghijklmnop")
+	assert.False(t, ok)
+
+	// Listings read the sizes from the names without reading the manifests
+	put("file.txt", 1000)
+	assert.Equal(t, map[string][]int64{"file.txt": {1000}}, manifests())
+	sizes, manifestsRead := list()
+	assert.Equal(t, map[string]int64{"file.txt": 1000}, sizes)
+	assert.Equal(t, 0, manifestsRead)
+
+	// Updating the file renames its manifest
+	obj, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), obj.Size())
+	src := object.NewStaticObjectInfo("file.txt", t1, 2000, true, nil, nil)
+	require.NoError(t, obj.Update(ctx, bytes.NewBufferString(random.String(2000)), src))
+	assert.Equal(t, int64(2000), obj.Size())
+	assert.Equal(t, "file.txt", obj.Remote())
+	assert.Equal(t, map[string][]int64{"file.txt": {2000}}, manifests())
+
+	// And so does putting it again
+	put("file.txt", 3000)
+	assert.Equal(t, map[string][]int64{"file.txt": {3000}}, manifests())
+	put("file.txt", 3000)
+	assert.Equal(t, map[string][]int64{"file.txt": {3000}}, manifests())
+
+	// Manifests are moved and removed with their files
+	put("removed.txt", 500)
+	put("moved.txt", 10)
+	obj, err = f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	moved, err := f.Move(ctx, obj, "moved.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3000), moved.Size())
+	assert.Equal(t, "moved.txt", moved.Remote())
+	obj, err = f.NewObject(ctx, "removed.txt")
+	require.NoError(t, err)
+	require.NoError(t, obj.Remove(ctx))
+	assert.Equal(t, map[string][]int64{"moved.txt": {3000}}, manifests())
+	_, err = f.NewObject(ctx, "file.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+
+	// If an old manifest wasn't removed the newest one is used
+	stale := filepath.Join(dir, f.manifestRemote(f.cipher.EncryptFileName("moved.txt"), 5))
+	require.NoError(t, os.WriteFile(stale, []byte("potato"), 0666))
+	require.NoError(t, os.Chtimes(stale, t1.Add(-time.Hour), t1.Add(-time.Hour)))
+	sizes, manifestsRead = list()
+	assert.Equal(t, map[string]int64{"moved.txt": 3000}, sizes)
+	assert.Equal(t, 0, manifestsRead)
+	obj, err = f.NewObject(ctx, "moved.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3000), obj.Size())
+
+	// Files which weren't written with dedup aren't listed
+	require.NoError(t, os.WriteFile(filepath.Join(dir, f.cipher.EncryptFileName("plain.txt")), []byte("potato"), 0666))
+	sizes, _ = list()
+	assert.Equal(t, map[string]int64{"moved.txt": 3000}, sizes)
+	_, err = f.NewObject(ctx, "plain.txt")
+	assert.Equal(t, fs.ErrorObjectNotFound, err)
+}
+
+// Test a remote with only the public key can read the sizes of dedup
+// files from the names of their manifests
+func TestDedupSizesPublicKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	contents := random.String(1000)
+	t1 := time.Date(2012, time.December, 17, 18, 32, 31, 0, time.UTC)
+
+	keys, err := makeTestCrypt(t, dir, configmap.Simple{}).Command(ctx, "keygen", nil, nil)
+	require.NoError(t, err)
+	writer := makeTestCrypt(t, dir, configmap.Simple{
+		"dedup":            "true",
+		"dedup_chunk_size": "64k",
+		"public_key":       keys.(map[string]string)["public_key"],
+	})
+
+	src := object.NewStaticObjectInfo("file.txt", t1, int64(len(contents)), true, nil, nil)
+	_, err = writer.Put(ctx, bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+
+	entries, err := writer.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(len(contents)), entries[0].Size())
+	obj, err := writer.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), obj.Size())
 }
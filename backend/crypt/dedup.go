@@ -0,0 +1,686 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// If dedup is set then the data of each file is split into chunks at
+// boundaries found from its content, so an insertion or deletion only
+// changes the chunks around it. Each chunk is encrypted and stored in
+// dedupDir at the root of the remote under a name made from a keyed
+// hash of its contents, so identical chunks are only stored once.
+//
+// The object stored for the file is an encrypted manifest listing its
+// chunks. Chunks which no manifest refers to are removed by CleanUp.
+//
+// The manifest is named after the encrypted name of the file with a
+// suffix of the encrypted size of the file, so listings can find the
+// sizes without reading the manifests. Only the name key is needed to
+// read it so this works with public_key too. As the name depends on
+// the size, a file which changes size has a new manifest written and
+// the old one removed.
+
+const (
+	// dedupDir is the directory at the root of the remote the
+	// chunks are stored in
+	dedupDir = ".rclone_chunks"
+	// dedupManifestVersion is the version of the manifest format
+	dedupManifestVersion = 1
+	// dedupGCMinAge is how old an unreferenced chunk must be before
+	// CleanUp removes it, so chunks of files being uploaded aren't
+	// removed before their manifests are written. Existing chunks
+	// which are reused have their modification time updated if it
+	// is older than half of this.
+	dedupGCMinAge = time.Hour
+	// dedupMinChunkSize is the smallest dedup_chunk_size allowed
+	dedupMinChunkSize = 64 * 1024
+	// dedupSizeMagic fills the rest of the block the size is
+	// encrypted in so manifest names can be recognized
+	dedupSizeMagic = "rclsize\x00"
+	// dedupSizeLen is the length of the encoded size in a manifest name
+	dedupSizeLen = 26
+)
+
+// dedupSizeEncoding encodes the encrypted size in manifest names
+var dedupSizeEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// dedupChunk is a chunk in a manifest
+type dedupChunk struct {
+	Hash string `json:"h"` // keyed hash of the contents in hex
+	Size int64  `json:"n"` // size of the decrypted contents
+}
+
+// dedupManifest is the contents of the object stored for each file
+type dedupManifest struct {
+	Version int          `json:"v"`
+	Size    int64        `json:"size"`
+	Chunks  []dedupChunk `json:"chunks"`
+}
+
+// dedupStore splits files into chunks and stores them
+type dedupStore struct {
+	fs      fs.Fs         // where the chunks are stored
+	key     [32]byte      // key for the chunk hashes
+	gear    [256]uint64   // gear table for finding chunk boundaries
+	minSize int           // smallest chunk
+	avgSize int           // chunks bigger than this are cut more easily
+	maxSize int           // biggest chunk
+	maskS   uint64        // boundary mask below avgSize
+	maskL   uint64        // boundary mask above avgSize
+	minAge  time.Duration // see dedupGCMinAge
+	known   sync.Map      // hash of chunks known to be stored => time.Time they were last modified
+}
+
+// newDedupStore makes the dedupStore for the config
+func newDedupStore(ctx context.Context, opt *Options, cipher *Cipher) (*dedupStore, error) {
+	if opt.NoDataEncryption {
+		return nil, errors.New("dedup can't be used with no_data_encryption")
+	}
+	avgSize := int64(opt.DedupChunkSize)
+	if avgSize < dedupMinChunkSize {
+		return nil, fmt.Errorf("dedup_chunk_size must be at least %v", fs.SizeSuffix(dedupMinChunkSize))
+	}
+	store, err := cache.Get(ctx, fspath.JoinRootPath(opt.Remote, dedupDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make remote for dedup chunks: %w", err)
+	}
+	d := &dedupStore{
+		fs:      store,
+		minSize: int(avgSize / 4),
+		avgSize: int(avgSize),
+		maxSize: int(avgSize * 4),
+		minAge:  dedupGCMinAge,
+	}
+	cache.PinUntilFinalized(store, d)
+	// Derive the keys from the data key so the chunk names and
+	// boundaries don't reveal anything about the contents
+	mac := hmac.New(sha256.New, cipher.dataKey[:])
+	_, _ = mac.Write([]byte("rclone crypt dedup"))
+	copy(d.key[:], mac.Sum(nil))
+	var counter [4]byte
+	for i := range d.gear {
+		mac = hmac.New(sha256.New, d.key[:])
+		binary.BigEndian.PutUint32(counter[:], uint32(i))
+		_, _ = mac.Write(counter[:])
+		d.gear[i] = binary.BigEndian.Uint64(mac.Sum(nil))
+	}
+	// The top bits of the gear hash depend on the last 64 bytes so
+	// use them for the masks. Normalize the chunk sizes by making
+	// boundaries harder to find below avgSize and easier above.
+	logAvg := bits.Len64(uint64(avgSize)) - 1
+	d.maskS = ^uint64(0) << (64 - (logAvg + 1))
+	d.maskL = ^uint64(0) << (64 - (logAvg - 1))
+	return d, nil
+}
+
+// cut returns the length of the chunk at the start of data
+//
+// If data is shorter than maxSize it is taken to be the end of the
+// file.
+func (d *dedupStore) cut(data []byte) int {
+	n := len(data)
+	if n <= d.minSize {
+		return n
+	}
+	avgSize := min(d.avgSize, n)
+	maxSize := min(d.maxSize, n)
+	var h uint64
+	i := d.minSize
+	for ; i < avgSize; i++ {
+		h = (h << 1) + d.gear[data[i]]
+		if h&d.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < maxSize; i++ {
+		h = (h << 1) + d.gear[data[i]]
+		if h&d.maskL == 0 {
+			return i + 1
+		}
+	}
+	return maxSize
+}
+
+// hash returns the keyed hash of the chunk in hex
+func (d *dedupStore) hash(chunk []byte) string {
+	mac := hmac.New(sha256.New, d.key[:])
+	_, _ = mac.Write(chunk)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chunkRemote returns the remote of the chunk with hash in the store
+func chunkRemote(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// isDedupDir returns true if remote is in dedupDir
+func isDedupDir(remote string) bool {
+	return remote == dedupDir || strings.HasPrefix(remote, dedupDir+"/")
+}
+
+// upload splits in into chunks, uploads the ones which aren't stored
+// already and returns the manifest of the file and its encoding
+func (d *dedupStore) upload(ctx context.Context, f *Fs, in io.Reader) (m *dedupManifest, data []byte, err error) {
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Transfers)
+	m = &dedupManifest{
+		Version: dedupManifestVersion,
+		Chunks:  []dedupChunk{},
+	}
+	buf := make([]byte, d.maxSize)
+	n := 0
+	for gCtx.Err() == nil {
+		var read int
+		read, err = io.ReadFull(in, buf[n:])
+		n += read
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		} else if err != nil {
+			break
+		}
+		if n == 0 {
+			break
+		}
+		cut := d.cut(buf[:n])
+		chunk := bytes.Clone(buf[:cut])
+		hash := d.hash(chunk)
+		m.Chunks = append(m.Chunks, dedupChunk{Hash: hash, Size: int64(cut)})
+		m.Size += int64(cut)
+		g.Go(func() error {
+			return d.putChunk(gCtx, f, hash, chunk)
+		})
+		n = copy(buf, buf[cut:n])
+	}
+	if gErr := g.Wait(); err == nil {
+		err = gErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err = json.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, data, nil
+}
+
+// fresh returns true if a chunk last modified at modTime won't be
+// removed by CleanUp before the manifest referring to it is written
+func (d *dedupStore) fresh(modTime time.Time) bool {
+	return time.Since(modTime) < d.minAge/2
+}
+
+// putChunk encrypts and uploads the chunk if it isn't stored already
+//
+// If the chunk is stored already but old enough that a CleanUp
+// running at the same time might remove it, its modification time is
+// updated so that it is kept.
+func (d *dedupStore) putChunk(ctx context.Context, f *Fs, hash string, chunk []byte) error {
+	if modTime, ok := d.known.Load(hash); ok && d.fresh(modTime.(time.Time)) {
+		return nil
+	}
+	remote := chunkRemote(hash)
+	o, err := d.fs.NewObject(ctx, remote)
+	if err == nil && f.cipher.isEncryptedSize(o.Size(), int64(len(chunk))) {
+		modTime := o.ModTime(ctx)
+		if d.fresh(modTime) {
+			d.known.Store(hash, modTime)
+			return nil
+		}
+		now := time.Now()
+		err = o.SetModTime(ctx, now)
+		if err == nil {
+			d.known.Store(hash, now)
+			return nil
+		}
+		// Upload the chunk again to update its modification time
+		fs.Debugf(o, "dedup: uploading chunk again as failed to update its modification time: %v", err)
+	} else if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		return fmt.Errorf("failed to find chunk %s: %w", hash, err)
+	}
+	in, err := f.cipher.EncryptData(bytes.NewReader(chunk))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	src := object.NewStaticObjectInfo(remote, now, f.cipher.EncryptedSize(int64(len(chunk))), true, nil, d.fs)
+	if _, err = d.fs.Put(ctx, in, src); err != nil {
+		return fmt.Errorf("failed to upload chunk %s: %w", hash, err)
+	}
+	d.known.Store(hash, now)
+	return nil
+}
+
+// decodeManifest decodes and checks the contents of a manifest
+func decodeManifest(data []byte) (*dedupManifest, error) {
+	m := new(dedupManifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to decode dedup manifest: %w", err)
+	}
+	if m.Version != dedupManifestVersion {
+		return nil, fmt.Errorf("unknown dedup manifest version %d", m.Version)
+	}
+	var size int64
+	for _, chunk := range m.Chunks {
+		if len(chunk.Hash) < 2 || chunk.Size <= 0 {
+			return nil, errors.New("corrupted dedup manifest: bad chunk")
+		}
+		size += chunk.Size
+	}
+	if size != m.Size {
+		return nil, fmt.Errorf("corrupted dedup manifest: chunks total %d bytes but file is %d bytes", size, m.Size)
+	}
+	return m, nil
+}
+
+// readManifest returns the manifest of the object, reading it if
+// necessary
+func (o *Object) readManifest(ctx context.Context) (*dedupManifest, error) {
+	o.manifestMu.Lock()
+	defer o.manifestMu.Unlock()
+	if o.manifest != nil {
+		return o.manifest, nil
+	}
+	in, err := o.Object.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup manifest: %w", err)
+	}
+	rc, err := o.f.cipher.DecryptData(in) // closes in on error
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt dedup manifest: %w", err)
+	}
+	data, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dedup manifest: %w", err)
+	}
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if o.dedupSize >= 0 && m.Size != o.dedupSize {
+		return nil, fmt.Errorf("corrupted dedup manifest: file is %d bytes but its name says %d", m.Size, o.dedupSize)
+	}
+	o.manifest = m
+	return m, nil
+}
+
+// setManifest makes the object refer to the manifest of newO which
+// has been written in place of its own
+func (o *Object) setManifest(newO *Object) {
+	o.manifestMu.Lock()
+	o.Object = newO.Object
+	o.manifest = newO.manifest
+	o.dedupSize = newO.dedupSize
+	o.manifestMu.Unlock()
+}
+
+// manifestRemote returns the name of the manifest of the file with
+// the encrypted remote of size
+func (f *Fs) manifestRemote(remote string, size int64) string {
+	var block [16]byte
+	binary.BigEndian.PutUint64(block[:8], uint64(size))
+	copy(block[8:], dedupSizeMagic)
+	f.cipher.block.Encrypt(block[:], block[:])
+	return remote + "." + strings.ToLower(dedupSizeEncoding.EncodeToString(block[:]))
+}
+
+// parseManifestRemote returns the encrypted remote of the file and
+// its size if remote is the name of a manifest
+func (f *Fs) parseManifestRemote(remote string) (file string, size int64, ok bool) {
+	if f.dedup == nil {
+		return "", 0, false
+	}
+	i := strings.LastIndexByte(remote, '.')
+	if i < 0 || len(remote)-i-1 != dedupSizeLen {
+		return "", 0, false
+	}
+	block, err := dedupSizeEncoding.DecodeString(strings.ToUpper(remote[i+1:]))
+	if err != nil || len(block) != 16 {
+		return "", 0, false
+	}
+	f.cipher.block.Decrypt(block, block)
+	size = int64(binary.BigEndian.Uint64(block[:8]))
+	if string(block[8:]) != dedupSizeMagic || size < 0 {
+		return "", 0, false
+	}
+	return remote[:i], size, true
+}
+
+// findManifest finds the manifest of the file at the decrypted remote
+//
+// As the name of the manifest depends on the size of the file this
+// lists the directory it is in.
+func (f *Fs) findManifest(ctx context.Context, remote string) (*Object, error) {
+	encrypted := f.cipher.EncryptFileName(remote)
+	dir := path.Dir(encrypted)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := f.Fs.List(ctx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var found fs.DirEntries
+	for _, entry := range entries {
+		obj, ok := entry.(fs.Object)
+		if !ok {
+			continue
+		}
+		if file, _, ok := f.parseManifestRemote(obj.Remote()); ok && file == encrypted {
+			found = append(found, f.newObject(obj))
+		}
+	}
+	found = newestManifests(ctx, found)
+	if len(found) == 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return found[0].(*Object), nil
+}
+
+// findRootManifest returns true if the root of f is a file, in which
+// case the wrapped Fs is changed to point at its parent directory
+func (f *Fs) findRootManifest(ctx context.Context) bool {
+	dir := path.Dir(f.root)
+	if dir == "." || dir == "/" {
+		dir = ""
+	}
+	parentFs, err := cache.Get(ctx, fspath.JoinRootPath(f.opt.Remote, f.cipher.EncryptDirName(dir)))
+	if err != nil {
+		return false
+	}
+	parent := *f
+	parent.Fs = parentFs
+	if _, err := parent.findManifest(ctx, path.Base(f.root)); err != nil {
+		return false
+	}
+	f.Fs = parentFs
+	return true
+}
+
+// newestManifests removes all but the newest manifest of each file
+// from entries. This alters entries returning it as newEntries.
+//
+// There is more than one if replacing the manifest of a file with one
+// for a different size was interrupted before the old one was
+// removed.
+func newestManifests(ctx context.Context, entries fs.DirEntries) (newEntries fs.DirEntries) {
+	newEntries = entries[:0] // in place filter
+	seen := make(map[string]int)
+	for _, entry := range entries {
+		o, ok := entry.(*Object)
+		if !ok {
+			newEntries = append(newEntries, entry)
+			continue
+		}
+		remote := o.Remote()
+		i, ok := seen[remote]
+		if !ok {
+			seen[remote] = len(newEntries)
+			newEntries = append(newEntries, entry)
+			continue
+		}
+		fs.Debugf(o, "dedup: found more than one manifest, using the newest")
+		if o.Object.ModTime(ctx).After(newEntries[i].(*Object).Object.ModTime(ctx)) {
+			newEntries[i] = o
+		}
+	}
+	return newEntries
+}
+
+// removeOldManifest removes the manifest old of the file o has
+// replaced if it was stored under a different name
+//
+// Errors are only logged as listings use the newest manifest.
+func (f *Fs) removeOldManifest(ctx context.Context, old fs.Object, o *Object) {
+	if old == nil || old.Remote() == o.Object.Remote() {
+		return
+	}
+	if err := old.Remove(ctx); err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		fs.Errorf(o, "dedup: failed to remove old manifest: %v", err)
+	}
+}
+
+// openDedup opens the chunks of the object for read from offset
+// reading limit bytes or to the end if limit < 0
+func (o *Object) openDedup(ctx context.Context, offset, limit int64, options []fs.OpenOption) (io.ReadCloser, error) {
+	m, err := o.readManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunks := m.Chunks
+	for len(chunks) > 0 && offset >= chunks[0].Size {
+		offset -= chunks[0].Size
+		chunks = chunks[1:]
+	}
+	return &dedupReader{
+		ctx:     ctx,
+		f:       o.f,
+		chunks:  chunks,
+		offset:  offset,
+		limit:   limit,
+		options: options,
+	}, nil
+}
+
+// dedupReader reads the contents of a file from its chunks
+type dedupReader struct {
+	ctx     context.Context
+	f       *Fs
+	chunks  []dedupChunk    // chunks still to be opened
+	offset  int64           // offset into the first chunk
+	limit   int64           // bytes left to read or -1 for all
+	options []fs.OpenOption // options for opening the chunks
+	in      io.ReadCloser   // the chunk being read
+}
+
+// openNext opens the next chunk for read
+func (r *dedupReader) openNext() error {
+	chunk := r.chunks[0]
+	limit := chunk.Size - r.offset
+	if r.limit >= 0 && r.limit < limit {
+		limit = r.limit
+	}
+	o, err := r.f.dedup.fs.NewObject(r.ctx, chunkRemote(chunk.Hash))
+	if err != nil {
+		return fmt.Errorf("failed to find chunk %s: %w", chunk.Hash, err)
+	}
+	if !r.f.cipher.isEncryptedSize(o.Size(), chunk.Size) {
+		return fmt.Errorf("corrupted chunk %s: size is %d but should be %d", chunk.Hash, o.Size(), r.f.cipher.EncryptedSize(chunk.Size))
+	}
+	r.in, err = r.f.cipher.DecryptDataSeek(r.ctx, openRangeSeek(o, r.options), r.offset, limit)
+	if err != nil {
+		return fmt.Errorf("failed to open chunk %s: %w", chunk.Hash, err)
+	}
+	r.chunks = r.chunks[1:]
+	r.offset = 0
+	return nil
+}
+
+// Read reads up to len(p) bytes into p
+func (r *dedupReader) Read(p []byte) (n int, err error) {
+	for n == 0 && err == nil {
+		if r.limit == 0 {
+			return 0, io.EOF
+		}
+		if r.in == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			if err = r.openNext(); err != nil {
+				return 0, err
+			}
+		}
+		if r.limit >= 0 && int64(len(p)) > r.limit {
+			p = p[:r.limit]
+		}
+		n, err = r.in.Read(p)
+		if r.limit >= 0 {
+			r.limit -= int64(n)
+		}
+		if err == io.EOF {
+			err = r.in.Close()
+			r.in = nil
+		}
+	}
+	return n, err
+}
+
+// Close closes the chunk being read
+func (r *dedupReader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
+
+// gc removes the chunks which no manifest refers to
+func (d *dedupStore) gc(ctx context.Context, f *Fs) error {
+	ci := fs.GetConfig(ctx)
+	// Read the manifests from the root of the remote whatever the
+	// root of f is
+	rootFs, err := cache.Get(ctx, f.opt.Remote)
+	if err != nil {
+		return fmt.Errorf("failed to make remote to read dedup manifests: %w", err)
+	}
+	root := &Fs{
+		Fs:     rootFs,
+		name:   f.name,
+		opt:    f.opt,
+		cipher: f.cipher,
+		dedup:  d,
+	}
+	var (
+		mu         sync.Mutex
+		referenced = make(map[string]struct{})
+		manifests  int
+	)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(ci.Checkers)
+	err = walk.ListR(gCtx, rootFs, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			obj, ok := entry.(fs.Object)
+			if !ok || isDedupDir(obj.Remote()) {
+				continue
+			}
+			encrypted, _, isManifest := f.parseManifestRemote(obj.Remote())
+			if !isManifest {
+				encrypted = obj.Remote()
+			}
+			remote, err := f.cipher.DecryptFileName(encrypted)
+			if err != nil {
+				fs.Debugf(obj, "dedup: ignoring undecryptable file name: %v", err)
+				continue
+			}
+			if f.opt.MetadataSidecar && isSidecar(remote) {
+				continue
+			}
+			if !isManifest {
+				fs.Logf(remote, "dedup: ignoring file which wasn't written with dedup")
+				continue
+			}
+			o := root.newObject(obj)
+			manifests++
+			g.Go(func() error {
+				m, err := o.readManifest(gCtx)
+				if err != nil {
+					return fmt.Errorf("%s: %w", remote, err)
+				}
+				mu.Lock()
+				for _, chunk := range m.Chunks {
+					referenced[chunk.Hash] = struct{}{}
+				}
+				mu.Unlock()
+				return nil
+			})
+		}
+		return nil
+	})
+	if gErr := g.Wait(); err == nil {
+		err = gErr
+	}
+	if err != nil {
+		return fmt.Errorf("dedup: not removing any chunks as failed to read all the manifests: %w", err)
+	}
+
+	var (
+		removed     int
+		removedSize int64
+	)
+	g, gCtx = errgroup.WithContext(ctx)
+	g.SetLimit(ci.Transfers)
+	err = walk.ListR(gCtx, d.fs, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			obj, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			hash := path.Base(obj.Remote())
+			if _, ok := referenced[hash]; ok {
+				continue
+			}
+			if d.minAge > 0 && time.Since(obj.ModTime(gCtx)) < d.minAge {
+				fs.Debugf(obj, "dedup: not removing unreferenced chunk as it is less than %v old", d.minAge)
+				continue
+			}
+			if ci.DryRun {
+				fs.Logf(obj, "dedup: not removing unreferenced chunk as --dry-run is set")
+				continue
+			}
+			g.Go(func() error {
+				if err := obj.Remove(gCtx); err != nil {
+					return fmt.Errorf("failed to remove chunk %s: %w", hash, err)
+				}
+				mu.Lock()
+				removed++
+				removedSize += obj.Size()
+				mu.Unlock()
+				return nil
+			})
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		err = nil
+	}
+	if gErr := g.Wait(); err == nil {
+		err = gErr
+	}
+	// Check the chunks are still there before using them again
+	d.known.Clear()
+	if err != nil {
+		return fmt.Errorf("dedup: failed to remove unreferenced chunks: %w", err)
+	}
+	fs.Infof(f, "dedup: %d files refer to %d chunks, removed %d unreferenced chunks (%v)", manifests, len(referenced), removed, fs.SizeSuffix(removedSize))
+	return nil
+}
@@ -0,0 +1,600 @@
+package crypt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// The rekey command re-encrypts the names and contents of all the
+// files with a new password, in place on the underlying remote.
+//
+// Each file goes through these states which are recorded in a journal
+// so an interrupted rekey can carry on where it left off:
+//
+//  1. a copy encrypted with the new key is uploaded
+//  2. the copy is checked against the original as cryptcheck does
+//  3. the sidecar, if any, is re-encrypted and the original removed
+//  4. the copy is moved into place if it needed a temporary name
+//
+// The original keeps its name until it is removed, so files which
+// haven't been done yet can still be found with the old password.
+
+const (
+	// rekeyTempSuffix is added to the name of the new copy when it
+	// would otherwise overwrite the original, which happens if the
+	// file names aren't encrypted
+	rekeyTempSuffix = ".rekey"
+
+	// states of files in the rekey journal
+	rekeyCopied   = "copied"   // new copy uploaded
+	rekeyVerified = "verified" // new copy checked against the original
+	rekeyRemoved  = "removed"  // original removed
+	rekeyDone     = "done"     // new copy in place
+)
+
+// rekeyEntry is the state of a file in the journal
+type rekeyEntry struct {
+	State  string `json:"state"`
+	Target string `json:"target"` // name the new copy is uploaded to
+	Final  string `json:"final"`  // name of the new copy when done
+}
+
+// rekeyJournal records the progress of a rekey
+//
+// Files are keyed by their encrypted names on the underlying remote so
+// the journal doesn't reveal the decrypted names.
+type rekeyJournal struct {
+	mu    sync.Mutex
+	path  string
+	Check string                 `json:"check"` // identifies the old and new keys
+	Items map[string]*rekeyEntry `json:"items"`
+}
+
+// rekeyCheck returns a value identifying the change from the keys in
+// oldCipher to the keys in newCipher without revealing them
+func rekeyCheck(oldCipher, newCipher *Cipher) string {
+	mac := hmac.New(sha256.New, newCipher.dataKey[:])
+	mac.Write(oldCipher.dataKey[:])
+	mac.Write(oldCipher.nameKey[:])
+	mac.Write(newCipher.nameKey[:])
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadRekeyJournal loads the journal from path, making a new one if
+// it doesn't exist
+func loadRekeyJournal(path, check string) (*rekeyJournal, error) {
+	j := &rekeyJournal{
+		path:  path,
+		Check: check,
+		Items: make(map[string]*rekeyEntry),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read rekey journal: %w", err)
+	}
+	if err = json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to decode rekey journal %q: %w", path, err)
+	}
+	if j.Check != check {
+		return nil, fmt.Errorf("rekey journal %q is for a different remote or password - remove it to start again", path)
+	}
+	if j.Items == nil {
+		j.Items = make(map[string]*rekeyEntry)
+	}
+	fs.Infof(nil, "Resuming rekey from journal %q", path)
+	return j, nil
+}
+
+// get returns a copy of the entry for name or nil if not found
+func (j *rekeyJournal) get(name string) *rekeyEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.Items[name]
+	if !ok {
+		return nil
+	}
+	newEntry := *entry
+	return &newEntry
+}
+
+// set the entry for name and save the journal
+func (j *rekeyJournal) set(name string, entry *rekeyEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	newEntry := *entry
+	j.Items[name] = &newEntry
+	return j.save()
+}
+
+// names returns the names of the files in the journal
+func (j *rekeyJournal) names() (names []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for name := range j.Items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// save the journal atomically - call with the lock held
+func (j *rekeyJournal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("failed to make rekey journal directory: %w", err)
+	}
+	tmp := j.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write rekey journal: %w", err)
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("failed to write rekey journal: %w", err)
+	}
+	return nil
+}
+
+// remove the journal
+func (j *rekeyJournal) remove() error {
+	err := os.Remove(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// rekeyer holds the state of a rekey
+type rekeyer struct {
+	f          *Fs // Fs with the old key
+	newF       *Fs // Fs with the new key
+	journal    *rekeyJournal
+	files      atomic.Int64
+	serverSide atomic.Int64
+	dirs       atomic.Int64
+}
+
+// rekeyResult is returned by the rekey command
+type rekeyResult struct {
+	Files       int64 `json:"files"`
+	ServerSide  int64 `json:"serverSide"`
+	Dirs        int64 `json:"dirs"`
+	ConfigSaved bool  `json:"configSaved"`
+}
+
+// rekeyCommand is the implementation of the rekey command
+func (f *Fs) rekeyCommand(ctx context.Context, opt map[string]string) (out any, err error) {
+	if f.root != "" {
+		return nil, errors.New("rekey must be run on the root of the crypt remote")
+	}
+	if f.dedup != nil {
+		return nil, errors.New("rekey can't be used with dedup")
+	}
+	password := opt["password"]
+	if password == "" {
+		return nil, errors.New("need the new password with -o password=NEWPASSWORD")
+	}
+	newOpt := f.opt
+	newOpt.Password, err = obscure.Obscure(password)
+	if err != nil {
+		return nil, err
+	}
+	newOpt.Password2 = ""
+	if password2 := opt["password2"]; password2 != "" {
+		newOpt.Password2, err = obscure.Obscure(password2)
+		if err != nil {
+			return nil, err
+		}
+	}
+	newCipher, err := newCipherForConfig(&newOpt)
+	if err != nil {
+		return nil, err
+	}
+	if newCipher.dataKey == f.cipher.dataKey && newCipher.nameKey == f.cipher.nameKey {
+		return nil, errors.New("the new password is the same as the old one")
+	}
+	journalPath := opt["journal"]
+	if journalPath == "" {
+		name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(f.name)
+		journalPath = filepath.Join(config.GetCacheDir(), "crypt-rekey", name+".json")
+	}
+	journal, err := loadRekeyJournal(journalPath, rekeyCheck(f.cipher, newCipher))
+	if err != nil {
+		return nil, err
+	}
+	r := &rekeyer{
+		f: f,
+		newF: &Fs{
+			Fs:       f.Fs,
+			name:     f.name,
+			root:     f.root,
+			opt:      newOpt,
+			features: f.features,
+			cipher:   newCipher,
+		},
+		journal: journal,
+	}
+	if err = r.run(ctx); err != nil {
+		return nil, err
+	}
+	if err = journal.remove(); err != nil {
+		fs.Errorf(nil, "Failed to remove rekey journal: %v", err)
+	}
+	result := &rekeyResult{
+		Files:      r.files.Load(),
+		ServerSide: r.serverSide.Load(),
+		Dirs:       r.dirs.Load(),
+	}
+
+	// Use the new keys from now on
+	f.opt.Password, f.opt.Password2 = newOpt.Password, newOpt.Password2
+	f.cipher = newCipher
+	if _, ok := config.FileGetValue(f.name, "password"); ok {
+		config.FileSetValue(f.name, "password", newOpt.Password)
+		if newOpt.Password2 != "" {
+			config.FileSetValue(f.name, "password2", newOpt.Password2)
+		} else {
+			config.FileDeleteKey(f.name, "password2")
+		}
+		config.SaveConfig()
+		result.ConfigSaved = true
+	} else {
+		fs.Logf(f, "Rekey finished - remember to change the password in the config of the remote")
+	}
+	return result, nil
+}
+
+// run the rekey
+func (r *rekeyer) run(ctx context.Context) error {
+	var (
+		objects = make(map[string]fs.Object)
+		dirs    []string
+	)
+	err := walk.ListR(ctx, r.f.Fs, "", true, -1, walk.ListAll, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case fs.Object:
+				objects[x.Remote()] = x
+			case fs.Directory:
+				dirs = append(dirs, x.Remote())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list underlying remote: %w", err)
+	}
+
+	ci := fs.GetConfig(ctx)
+	var (
+		g      errgroup.Group
+		errs   atomic.Int64
+		doFile = func(name string, fn func() error) {
+			g.Go(func() error {
+				if err := fn(); err != nil {
+					fs.Errorf(name, "Failed to rekey: %v", err)
+					errs.Add(1)
+				}
+				return nil
+			})
+		}
+	)
+	g.SetLimit(ci.Transfers)
+
+	// Carry on with files whose originals have gone
+	for _, name := range r.journal.names() {
+		if _, found := objects[name]; !found {
+			doFile(name, func() error { return r.rekeyObject(ctx, name, nil) })
+		}
+	}
+
+	// Rekey the files the old key can decrypt
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.HasSuffix(name, rekeyTempSuffix) {
+			continue
+		}
+		remote, err := r.f.cipher.DecryptFileName(name)
+		if err != nil {
+			if _, newErr := r.newF.cipher.DecryptFileName(name); newErr != nil {
+				fs.Logf(name, "Skipping undecryptable file name: %v", err)
+			}
+			continue
+		}
+		if r.f.opt.MetadataSidecar && isSidecar(remote) {
+			continue
+		}
+		doFile(remote, func() error { return r.rekeyObject(ctx, name, objects[name]) })
+	}
+	_ = g.Wait()
+	if n := errs.Load(); n > 0 {
+		return fmt.Errorf("failed to rekey %d files - run rekey again with the same password to carry on", n)
+	}
+	return r.rekeyDirs(ctx, dirs)
+}
+
+// rekeyObject moves the file called name on the underlying remote
+// through the rekey states. oldBase is the original or nil if it has
+// been removed.
+func (r *rekeyer) rekeyObject(ctx context.Context, name string, oldBase fs.Object) (err error) {
+	remote, err := r.f.cipher.DecryptFileName(name)
+	if err != nil {
+		return err
+	}
+	entry := r.journal.get(name)
+	if entry != nil && entry.State == rekeyDone {
+		return nil
+	}
+	if entry == nil {
+		if oldBase == nil {
+			return nil
+		}
+		final := r.newF.cipher.EncryptFileName(remote)
+		entry = &rekeyEntry{
+			State:  rekeyCopied,
+			Target: final,
+			Final:  final,
+		}
+		if final == name {
+			if r.f.opt.NoDataEncryption {
+				// Only the sidecar needs re-encrypting
+				entry.State = rekeyVerified
+			} else {
+				entry.Target = final + rekeyTempSuffix
+			}
+		}
+		if entry.State == rekeyCopied {
+			if err = r.copyData(ctx, remote, oldBase, entry.Target); err != nil {
+				return err
+			}
+		}
+		if err = r.journal.set(name, entry); err != nil {
+			return err
+		}
+	}
+	if oldBase == nil && (entry.State == rekeyCopied || entry.State == rekeyVerified) {
+		if entry.State == rekeyCopied {
+			return errors.New("original removed before the new copy was checked")
+		}
+		// Removed but the journal wasn't updated
+		entry.State = rekeyRemoved
+	}
+	if entry.State == rekeyCopied {
+		if err = r.verify(ctx, remote, oldBase, entry.Target); err != nil {
+			return err
+		}
+		entry.State = rekeyVerified
+		if err = r.journal.set(name, entry); err != nil {
+			return err
+		}
+	}
+	if entry.State == rekeyVerified {
+		if err = r.rekeySidecar(ctx, remote, oldBase); err != nil {
+			return err
+		}
+		// Remove the original unless it is also the new copy
+		if entry.Target != name {
+			if err = oldBase.Remove(ctx); err != nil {
+				return fmt.Errorf("failed to remove original: %w", err)
+			}
+		}
+		entry.State = rekeyRemoved
+		if err = r.journal.set(name, entry); err != nil {
+			return err
+		}
+	}
+	if entry.State == rekeyRemoved {
+		if entry.Target != entry.Final {
+			target, err := r.f.Fs.NewObject(ctx, entry.Target)
+			if errors.Is(err, fs.ErrorObjectNotFound) {
+				// Moved but the journal wasn't updated
+			} else if err != nil {
+				return fmt.Errorf("failed to find new copy: %w", err)
+			} else if err = r.moveBase(ctx, target, entry.Final); err != nil {
+				return fmt.Errorf("failed to move new copy into place: %w", err)
+			}
+		}
+		if r.f.opt.MetadataSidecar && r.f.sidecarRemote(remote) != r.newF.sidecarRemote(remote) {
+			if err = r.f.removeSidecar(ctx, remote); err != nil {
+				return fmt.Errorf("failed to remove original metadata sidecar: %w", err)
+			}
+		}
+		entry.State = rekeyDone
+		if err = r.journal.set(name, entry); err != nil {
+			return err
+		}
+		r.files.Add(1)
+		fs.Debugf(remote, "Rekeyed")
+	}
+	return nil
+}
+
+// copyData uploads the contents of oldBase encrypted with the new key
+// to target on the underlying remote
+func (r *rekeyer) copyData(ctx context.Context, remote string, oldBase fs.Object, target string) (err error) {
+	if r.f.opt.NoDataEncryption {
+		if doCopy := r.f.Fs.Features().Copy; doCopy != nil {
+			_, err = doCopy(ctx, oldBase, target)
+			if err == nil {
+				r.serverSide.Add(1)
+				return nil
+			}
+			if !errors.Is(err, fs.ErrorCantCopy) {
+				return err
+			}
+		}
+	}
+	oldObj := r.f.newObject(oldBase)
+	tr := accounting.Stats(ctx).NewTransfer(oldObj, r.newF)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	in, err := oldObj.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open original: %w", err)
+	}
+	in = tr.Account(ctx, in).WithBuffer()
+	put := func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+		return r.f.Fs.Put(ctx, in, fs.NewOverrideRemote(src, target), options...)
+	}
+	_, err = r.newF.putData(ctx, in, oldObj, nil, put)
+	closeErr := in.Close()
+	if err != nil {
+		return fmt.Errorf("failed to upload new copy: %w", err)
+	}
+	return closeErr
+}
+
+// moveBase renames src on the underlying remote to remote
+func (r *rekeyer) moveBase(ctx context.Context, src fs.Object, remote string) (err error) {
+	features := r.f.Fs.Features()
+	if features.Move != nil {
+		_, err = features.Move(ctx, src, remote)
+		if !errors.Is(err, fs.ErrorCantMove) {
+			return err
+		}
+	}
+	if features.Copy != nil {
+		_, err = features.Copy(ctx, src, remote)
+		if err == nil {
+			return src.Remove(ctx)
+		}
+		if !errors.Is(err, fs.ErrorCantCopy) {
+			return err
+		}
+	}
+	in, err := src.Open(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = r.f.Fs.Put(ctx, in, fs.NewOverrideRemote(src, remote))
+	closeErr := in.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return src.Remove(ctx)
+}
+
+// verify checks the new copy at target matches the original oldBase
+// using the same method as cryptcheck
+func (r *rekeyer) verify(ctx context.Context, remote string, oldBase fs.Object, target string) error {
+	newBase, err := r.f.Fs.NewObject(ctx, target)
+	if err != nil {
+		return fmt.Errorf("failed to find new copy: %w", err)
+	}
+	oldObj, newObj := r.f.newObject(oldBase), r.newF.newObject(newBase)
+	if oldObj.Size() != newObj.Size() {
+		return fmt.Errorf("sizes differ after rekey: %d vs %d", oldObj.Size(), newObj.Size())
+	}
+	ht := r.f.Fs.Hashes().GetOne()
+	if ht == hash.None {
+		fs.Debugf(remote, "Checked size only as %v has no hashes", r.f.Fs)
+		return nil
+	}
+	newHash, err := newBase.Hash(ctx, ht)
+	if err != nil {
+		return fmt.Errorf("error reading hash from new copy: %w", err)
+	}
+	var wantHash string
+	if r.f.opt.NoDataEncryption {
+		wantHash, err = oldBase.Hash(ctx, ht)
+	} else {
+		wantHash, err = r.newF.ComputeHash(ctx, newObj, oldObj, ht)
+	}
+	if err != nil {
+		return fmt.Errorf("error computing hash: %w", err)
+	}
+	if newHash == "" || wantHash == "" {
+		fs.Debugf(remote, "Checked size only as %v hash is missing", ht)
+		return nil
+	}
+	if newHash != wantHash {
+		return fmt.Errorf("%v hashes differ after rekey: %q vs %q", ht, wantHash, newHash)
+	}
+	fs.Debugf(remote, "%v = %s OK", ht, newHash)
+	return nil
+}
+
+// rekeySidecar re-encrypts the sidecar of the file with the new key
+func (r *rekeyer) rekeySidecar(ctx context.Context, remote string, oldBase fs.Object) error {
+	if !r.f.opt.MetadataSidecar {
+		return nil
+	}
+	info, err := r.f.loadSidecar(ctx, remote)
+	if err != nil && r.f.sidecarRemote(remote) == r.newF.sidecarRemote(remote) {
+		// It may have been re-encrypted already
+		if _, newErr := r.newF.loadSidecar(ctx, remote); newErr == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return nil
+	}
+	if oldBase != nil && info.Size != r.f.newObject(oldBase).Size() {
+		fs.Debugf(remote, "Not rekeying out of date metadata sidecar")
+		return nil
+	}
+	return r.newF.writeSidecar(ctx, remote, info)
+}
+
+// rekeyDirs makes the directories dirs on the underlying remote with
+// names encrypted with the new key, removing the old ones if empty
+func (r *rekeyer) rekeyDirs(ctx context.Context, dirs []string) error {
+	var oldDirs []string
+	for _, dir := range dirs {
+		remote, err := r.f.cipher.DecryptDirName(dir)
+		if err != nil || r.newF.cipher.EncryptDirName(remote) == dir {
+			continue
+		}
+		if err = r.newF.Mkdir(ctx, remote); err != nil {
+			return fmt.Errorf("failed to make directory %q: %w", remote, err)
+		}
+		oldDirs = append(oldDirs, dir)
+	}
+	// Remove the deepest directories first
+	sort.Slice(oldDirs, func(i, j int) bool {
+		return strings.Count(oldDirs[i], "/") > strings.Count(oldDirs[j], "/")
+	})
+	for _, dir := range oldDirs {
+		if err := r.f.Fs.Rmdir(ctx, dir); err != nil {
+			fs.Logf(path.Join(r.f.Fs.Root(), dir), "Failed to remove old directory: %v", err)
+			continue
+		}
+		r.dirs.Add(1)
+	}
+	return nil
+}
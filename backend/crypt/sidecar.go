@@ -0,0 +1,307 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// If metadata_sidecar is set then each file has an encrypted sidecar
+// object next to it holding the modification time, size, mime type
+// and metadata of the original file. The sidecar is named after the
+// file with sidecarSuffix on the end before the name is encrypted, and
+// it is encrypted and authenticated in the same way as file data.
+//
+// This means crypt can preserve these even if the underlying remote
+// can't store them, and they aren't visible to the underlying remote.
+
+const (
+	// sidecarSuffix is added to the decrypted name of a file to make
+	// the name of its sidecar
+	sidecarSuffix = ".cryptmeta"
+	// sidecarVersion is the version of the sidecar format
+	sidecarVersion = 1
+)
+
+// sidecarInfo is the contents of a sidecar
+type sidecarInfo struct {
+	Version  int         `json:"v"`
+	ModTime  time.Time   `json:"mtime"`
+	Size     int64       `json:"size"`
+	MimeType string      `json:"mime,omitempty"`
+	Metadata fs.Metadata `json:"metadata,omitempty"`
+}
+
+// setMetadata merges metadata into info. The mtime and content-type
+// keys take precedence over the modification time and mime type.
+func (info *sidecarInfo) setMetadata(metadata fs.Metadata) {
+	info.Metadata.Merge(metadata)
+	if mtime, ok := metadata["mtime"]; ok {
+		if modTime, err := time.Parse(time.RFC3339Nano, mtime); err == nil {
+			info.ModTime = modTime
+		}
+	}
+	if mimeType, ok := metadata["content-type"]; ok {
+		info.MimeType = mimeType
+	}
+}
+
+// isSidecar returns true if the decrypted remote is the name of a sidecar
+func isSidecar(remote string) bool {
+	return strings.HasSuffix(remote, sidecarSuffix)
+}
+
+// sidecarRemote returns the encrypted remote of the sidecar for the
+// decrypted remote
+func (f *Fs) sidecarRemote(remote string) string {
+	return f.cipher.EncryptFileName(remote + sidecarSuffix)
+}
+
+// encodeSidecar encrypts info into the contents of a sidecar
+func (f *Fs) encodeSidecar(info *sidecarInfo) ([]byte, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	in, err := f.cipher.EncryptData(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(in)
+}
+
+// decodeSidecar decrypts and authenticates the contents of a sidecar
+func (f *Fs) decodeSidecar(data []byte) (*sidecarInfo, error) {
+	rc, err := f.cipher.DecryptData(io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	data, err = io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	info := new(sidecarInfo)
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	if info.Version != sidecarVersion {
+		return nil, fmt.Errorf("unknown sidecar version %d", info.Version)
+	}
+	return info, nil
+}
+
+// writeSidecar writes info to the sidecar of the decrypted remote
+func (f *Fs) writeSidecar(ctx context.Context, remote string, info *sidecarInfo) error {
+	info.Version = sidecarVersion
+	data, err := f.encodeSidecar(info)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt metadata sidecar: %w", err)
+	}
+	src := object.NewStaticObjectInfo(f.sidecarRemote(remote), info.ModTime, int64(len(data)), true, nil, f.Fs)
+	_, err = f.Fs.Put(ctx, bytes.NewReader(data), src)
+	if err != nil {
+		return fmt.Errorf("failed to upload metadata sidecar: %w", err)
+	}
+	return nil
+}
+
+// removeSidecar removes the sidecar of the decrypted remote if it exists
+func (f *Fs) removeSidecar(ctx context.Context, remote string) error {
+	o, err := f.Fs.NewObject(ctx, f.sidecarRemote(remote))
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return o.Remove(ctx)
+}
+
+// putSidecar writes the sidecar for the newly uploaded object o from
+// src and options
+func (f *Fs) putSidecar(ctx context.Context, o *Object, src fs.ObjectInfo, options []fs.OpenOption) error {
+	meta, err := fs.GetMetadataOptions(ctx, f, src, options)
+	if err != nil {
+		return err
+	}
+	info := &sidecarInfo{
+		ModTime:  src.ModTime(ctx),
+		Size:     o.Size(),
+		MimeType: fs.MimeType(ctx, src),
+	}
+	info.setMetadata(meta)
+	if err = f.writeSidecar(ctx, o.Remote(), info); err != nil {
+		return err
+	}
+	o.setSidecar(info)
+	return nil
+}
+
+// setSidecar sets the sidecar info of the object
+func (o *Object) setSidecar(info *sidecarInfo) {
+	o.mu.Lock()
+	o.sidecar = info
+	o.sidecarRead = true
+	o.sidecarObj = nil
+	o.mu.Unlock()
+}
+
+// loadSidecarObject reads and decrypts the sidecar in the underlying
+// object sidecarObj
+func (f *Fs) loadSidecarObject(ctx context.Context, sidecarObj fs.Object) (*sidecarInfo, error) {
+	in, err := sidecarObj.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata sidecar: %w", err)
+	}
+	data, err := io.ReadAll(in)
+	_ = in.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata sidecar: %w", err)
+	}
+	info, err := f.decodeSidecar(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt metadata sidecar: %w", err)
+	}
+	return info, nil
+}
+
+// loadSidecar reads the sidecar of the decrypted remote
+//
+// It returns nil if there isn't one.
+func (f *Fs) loadSidecar(ctx context.Context, remote string) (*sidecarInfo, error) {
+	sidecarObj, err := f.Fs.NewObject(ctx, f.sidecarRemote(remote))
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find metadata sidecar: %w", err)
+	}
+	return f.loadSidecarObject(ctx, sidecarObj)
+}
+
+// readSidecar returns the sidecar info for the object, reading it if
+// necessary.
+//
+// It returns nil if the object has no sidecar or the sidecar is out
+// of date because the file was changed without updating it.
+func (o *Object) readSidecar(ctx context.Context) (info *sidecarInfo, err error) {
+	if !o.f.opt.MetadataSidecar {
+		return nil, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sidecarRead {
+		return o.sidecar, nil
+	}
+	if o.sidecarObj != nil {
+		info, err = o.f.loadSidecarObject(ctx, o.sidecarObj)
+	} else {
+		info, err = o.f.loadSidecar(ctx, o.Remote())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		if size := o.Size(); size >= 0 && info.Size != size {
+			fs.Debugf(o, "Ignoring out of date metadata sidecar: size %d doesn't match %d", info.Size, size)
+			info = nil
+		}
+	}
+	o.sidecar = info
+	o.sidecarRead = true
+	o.sidecarObj = nil
+	return info, nil
+}
+
+// copySidecar copies the sidecar of src, if any, to dst applying any
+// --metadata-set. If remove is set the sidecar of src is removed
+// afterwards.
+func (f *Fs) copySidecar(ctx context.Context, src *Object, dst *Object, remove bool) error {
+	info, err := src.readSidecar(ctx)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return nil
+	}
+	newInfo := *info
+	if fs.GetConfig(ctx).Metadata {
+		var metadata fs.Metadata
+		metadata.MergeOptions(fs.MetadataAsOpenOptions(ctx))
+		newInfo.Metadata = maps.Clone(info.Metadata)
+		newInfo.setMetadata(metadata)
+	}
+	if err = f.writeSidecar(ctx, dst.Remote(), &newInfo); err != nil {
+		return err
+	}
+	dst.setSidecar(&newInfo)
+	if remove {
+		return src.f.removeSidecar(ctx, src.Remote())
+	}
+	return nil
+}
+
+// updateSidecar reads the sidecar of the object, or makes a new one
+// if there isn't one, and writes it back after calling update on it
+func (o *Object) updateSidecar(ctx context.Context, update func(info *sidecarInfo)) error {
+	info, err := o.readSidecar(ctx)
+	if err != nil {
+		return err
+	}
+	var newInfo sidecarInfo
+	if info != nil {
+		newInfo = *info
+		newInfo.Metadata = maps.Clone(info.Metadata)
+	} else {
+		newInfo = sidecarInfo{
+			ModTime: o.Object.ModTime(ctx),
+			Size:    o.Size(),
+		}
+	}
+	update(&newInfo)
+	if err = o.f.writeSidecar(ctx, o.Remote(), &newInfo); err != nil {
+		return err
+	}
+	o.setSidecar(&newInfo)
+	return nil
+}
+
+// attachSidecars removes the sidecars from entries and gives them to
+// the objects they belong to so they don't need to be found again.
+//
+// entries should have decrypted names. If complete is set entries is
+// the whole of a directory so the objects without a sidecar are
+// marked as not having one.
+func attachSidecars(entries fs.DirEntries, complete bool) fs.DirEntries {
+	sidecars := make(map[string]fs.Object)
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok && isSidecar(o.Remote()) {
+			sidecars[strings.TrimSuffix(o.Remote(), sidecarSuffix)] = o.Object
+		}
+	}
+	if len(sidecars) == 0 && !complete {
+		return entries
+	}
+	newEntries := entries[:0] // in place filter
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok {
+			if isSidecar(o.Remote()) {
+				continue
+			}
+			o.sidecarObj = sidecars[o.Remote()]
+			if o.sidecarObj == nil && complete {
+				o.sidecar = nil
+				o.sidecarRead = true
+			}
+		}
+		newEntries = append(newEntries, entry)
+	}
+	return newEntries
+}
@@ -45,6 +45,11 @@ type Job struct {
 	Output    rc.Params `json:"output"`
 	Stop      func()    `json:"-"`
 	listeners []*func()
+	jobs      *Jobs     // the Jobs this job belongs to
+	path      string    // the rc path being run if known
+	params    rc.Params // the input parameters for storing and retrying
+	stored    bool      // set if the job is kept in the job store
+	redacted  bool      // set if params aren't kept in the job store as they may contain credentials
 
 	// realErr is the Error before printing it as a string, it's used to return
 	// the real error to the upper application layers while still printing the
@@ -79,6 +84,8 @@ func (job *Job) finish(out rc.Params, err error) {
 
 	job.mu.Unlock()
 	running.kickExpire() // make sure this job gets expired
+	job.jobs.notify(job)
+	job.jobs.store.put(job)
 }
 
 func (job *Job) removeListener(fn *func()) {
@@ -121,6 +128,8 @@ type Jobs struct {
 	jobs          map[int64]*Job
 	opt           *rc.Options
 	expireRunning bool
+	listeners     []*func(rc.Params)
+	store         *store // the on disk job store if enabled
 }
 
 var (
@@ -163,12 +172,13 @@ func (jobs *Jobs) kickExpire() {
 // Expire expires any jobs that haven't been collected
 func (jobs *Jobs) Expire() {
 	jobs.mu.Lock()
-	defer jobs.mu.Unlock()
 	now := time.Now()
+	var expired []*Job
 	for ID, job := range jobs.jobs {
 		job.mu.Lock()
 		if job.Finished && now.Sub(job.EndTime) > time.Duration(jobs.opt.JobExpireDuration) {
 			delete(jobs.jobs, ID)
+			expired = append(expired, job)
 		}
 		job.mu.Unlock()
 	}
@@ -178,6 +188,14 @@ func (jobs *Jobs) Expire() {
 	} else {
 		jobs.expireRunning = false
 	}
+	store := jobs.store
+	jobs.mu.Unlock()
+
+	// Remove the jobs from the store without holding the locks
+	// so the disk writes don't block the other job calls
+	for _, job := range expired {
+		store.remove(job)
+	}
 }
 
 // IDs returns the IDs of the running jobs
@@ -207,6 +225,45 @@ func (jobs *Jobs) Stats() (running []int64, finished []int64) {
 	return running, finished
 }
 
+// OnChange adds a listener which is called with the status of a job,
+// as returned by job/status, whenever a job starts or finishes.
+// It returns a function to cancel listening.
+func (jobs *Jobs) OnChange(fn func(status rc.Params)) func() {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.listeners = append(jobs.listeners, &fn)
+	return func() {
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		jobs.listeners = slices.DeleteFunc(jobs.listeners, func(ln *func(rc.Params)) bool {
+			return ln == &fn
+		})
+	}
+}
+
+// notify the listeners of a change to job
+//
+// call without job.mu held
+func (jobs *Jobs) notify(job *Job) {
+	jobs.mu.RLock()
+	listeners := slices.Clone(jobs.listeners)
+	jobs.mu.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+	status := make(rc.Params)
+	job.mu.Lock()
+	err := rc.Reshape(&status, job)
+	job.mu.Unlock()
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to reshape job %d for listeners: %v", job.ID, err)
+		return
+	}
+	for _, fn := range listeners {
+		(*fn)(status)
+	}
+}
+
 // Get a job with a given ID or nil if it doesn't exist
 func (jobs *Jobs) Get(ID int64) *Job {
 	jobs.mu.RLock()
@@ -287,6 +344,13 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 	id := jobID.Add(1)
 	in = in.Copy() // copy input so we can change it
 
+	// The path is only used to record the job
+	path, _ := in.GetString("_path")
+	delete(in, "_path")
+	params := in.Copy()
+	delete(params, "_request")
+	delete(params, "_response")
+
 	ctx, isAsync, err := getAsync(ctx, in)
 	if err != nil {
 		return nil, nil, err
@@ -319,11 +383,20 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 		Group:     group,
 		StartTime: time.Now(),
 		Stop:      stop,
+		jobs:      jobs,
+		path:      path,
+		params:    params,
+	}
+	if jobs.store != nil && storable(path, isAsync) {
+		job.stored = true
+		job.redacted = sensitive(path, params)
 	}
 
 	jobs.mu.Lock()
 	jobs.jobs[job.ID] = job
 	jobs.mu.Unlock()
+	jobs.notify(job)
+	jobs.store.put(job)
 
 	// Add the job to the context
 	ctx = context.WithValue(ctx, jobKey, job)
@@ -358,6 +431,13 @@ func OnFinish(jobID int64, fn func()) (func(), error) {
 	return job.OnFinish(fn), nil
 }
 
+// OnChange adds a listener to the global job queue which is called
+// with the status of a job whenever a job starts or finishes.
+// It returns a function to cancel listening.
+func OnChange(fn func(status rc.Params)) func() {
+	return running.OnChange(fn)
+}
+
 // GetJob gets the Job from the context if possible
 func GetJob(ctx context.Context) (job *Job, ok bool) {
 	job, ok = ctx.Value(jobKey).(*Job)
@@ -507,6 +587,68 @@ func rcGroupStop(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	return out, nil
 }
 
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/retry",
+		AuthRequired: true,
+		Fn:           rcJobRetry,
+		Title:        "Run a finished job again",
+		Help: strings.ReplaceAll(`This starts a new async job with the same call and parameters as the
+job given. It can be used to restart a job which failed or which was
+interrupted by a restart of rclone when |--rc-job-store| is in use.
+
+Jobs loaded from the job store whose parameters weren't stored as
+they may contain credentials can't be retried.
+
+Parameters:
+
+- jobid - id of the job to retry (integer).
+
+Results:
+
+- jobid - id of the new job (integer).
+- executeId - rclone instance ID of the new job.
+`, "|", "`"),
+	})
+}
+
+// Runs a finished job again
+func rcJobRetry(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	jobID, err := in.GetInt64("jobid")
+	if err != nil {
+		return nil, err
+	}
+	job := running.Get(jobID)
+	if job == nil {
+		return nil, errors.New("job not found")
+	}
+	job.mu.Lock()
+	finished, path, params := job.Finished, job.path, job.params
+	job.mu.Unlock()
+	if !finished {
+		return nil, fmt.Errorf("job %d is still running", jobID)
+	}
+	if params == nil {
+		return nil, fmt.Errorf("job %d can't be retried as its parameters weren't stored", jobID)
+	}
+	params = params.Copy()
+	if path == "" {
+		return nil, fmt.Errorf("job %d can't be retried as its call wasn't recorded", jobID)
+	}
+	call := rc.Calls.Get(path)
+	if call == nil {
+		return nil, fmt.Errorf("couldn't find path %q", path)
+	}
+	if call.NeedsRequest || call.NeedsResponse {
+		return nil, fmt.Errorf("can't retry path %q as it needs the request or response", path)
+	}
+	params["_path"] = path
+	params["_async"] = true
+	fs.Debugf(nil, "rc: retrying job %d: %q with parameters %+v", jobID, path, params)
+	_, out, err = running.NewJob(ctx, call.Fn, params)
+	return out, err
+}
+
 // NewJobFromParams creates an rc job rc.Params.
 //
 // The JSON blob should contain a _path entry.
@@ -548,7 +690,10 @@ func NewJobFromParams(ctx context.Context, in rc.Params) (out rc.Params) {
 	}
 
 	fs.Debugf(nil, "rc: %q: with parameters %+v", path, in)
-	_, out, err = NewJob(ctx, call.Fn, in)
+	// Record the path in the job
+	jobIn := in.Copy()
+	jobIn["_path"] = path
+	_, out, err = NewJob(ctx, call.Fn, jobIn)
 	if err != nil {
 		return rcError(err, http.StatusInternalServerError)
 	}
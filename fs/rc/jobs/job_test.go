@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -534,6 +535,60 @@ func TestRcJobStopGroup(t *testing.T) {
 	}
 }
 
+func TestRcJobRetry(t *testing.T) {
+	ctx := context.Background()
+	jobID.Store(0)
+	noop := rc.Calls.Get("rc/noop")
+	require.NotNil(t, noop)
+	_, _, err := NewJob(ctx, noop.Fn, rc.Params{
+		"_path":  "rc/noop",
+		"_group": "myparty",
+		"a":      "potato",
+	})
+	require.NoError(t, err)
+	_, _, err = NewJob(ctx, ctxFn, rc.Params{
+		"_path":  "rc/noop",
+		"_async": true,
+	})
+	require.NoError(t, err)
+	_, _, err = NewJob(ctx, noopFn, rc.Params{})
+	require.NoError(t, err)
+	_, _, err = NewJob(ctx, noopFn, rc.Params{"_path": "test/needs_request"})
+	require.NoError(t, err)
+
+	call := rc.Calls.Get("job/retry")
+	assert.NotNil(t, call)
+	out, err := call.Fn(context.Background(), rc.Params{"jobid": 1})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"jobid": int64(5), "executeId": executeID}, out)
+
+	job := running.Get(5)
+	require.NotNil(t, job)
+	done := make(chan struct{})
+	job.OnFinish(func() { close(done) })
+	<-done
+	job.mu.Lock()
+	assert.Equal(t, "myparty", job.Group)
+	assert.Equal(t, rc.Params{"a": "potato"}, job.Output)
+	assert.True(t, job.Success)
+	job.mu.Unlock()
+
+	for _, test := range []struct {
+		jobid int
+		want  string
+	}{
+		{2, "still running"},
+		{3, "wasn't recorded"},
+		{4, "needs the request"},
+		{123123123, "job not found"},
+	} {
+		_, err = call.Fn(context.Background(), rc.Params{"jobid": test.jobid})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), test.want)
+	}
+	running.Get(2).Stop()
+}
+
 func TestOnFinish(t *testing.T) {
 	jobID.Store(0)
 	done := make(chan struct{})
@@ -572,6 +627,38 @@ func TestOnFinishAlreadyFinished(t *testing.T) {
 	}
 }
 
+func TestJobsOnChange(t *testing.T) {
+	jobs := newJobs()
+	var (
+		mu       sync.Mutex
+		statuses []rc.Params
+	)
+	stop := jobs.OnChange(func(status rc.Params) {
+		mu.Lock()
+		statuses = append(statuses, status)
+		mu.Unlock()
+	})
+
+	job, _, err := jobs.NewJob(context.Background(), noopFn, rc.Params{})
+	require.NoError(t, err)
+	mu.Lock()
+	require.Equal(t, 2, len(statuses))
+	assert.Equal(t, float64(job.ID), statuses[0]["id"])
+	assert.Equal(t, false, statuses[0]["finished"])
+	assert.Equal(t, float64(job.ID), statuses[1]["id"])
+	assert.Equal(t, true, statuses[1]["finished"])
+	assert.Equal(t, true, statuses[1]["success"])
+	mu.Unlock()
+
+	// No more changes after stopping
+	stop()
+	_, _, err = jobs.NewJob(context.Background(), noopFn, rc.Params{})
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, 2, len(statuses))
+	mu.Unlock()
+}
+
 func TestOnFinishDataRace(t *testing.T) {
 	jobID.Store(0)
 	job, _, err := NewJob(context.Background(), ctxFn, rc.Params{"_async": true})
@@ -631,7 +718,10 @@ func init() {
 		Path:          "test/needs_response",
 		NeedsResponse: true,
 	})
-
+	rc.Add(rc.Call{
+		Path:         "test/auth_required",
+		AuthRequired: true,
+	})
 }
 
 func TestNewJobFromParams(t *testing.T) {
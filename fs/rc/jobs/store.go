@@ -0,0 +1,248 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// The job store keeps a record of each job on disk with the
+// parameters it was started with so that the history of jobs survives
+// a restart of rclone and interrupted jobs can be retried.
+//
+// Only async jobs of calls which need authorisation are stored as the
+// other calls only read the state of rclone. The parameters aren't
+// stored if they may contain credentials.
+
+// storeFacility is the name of the kv database for the jobs
+const storeFacility = "rcjobs"
+
+// errInterrupted is the error given to jobs which were running when
+// rclone stopped
+var errInterrupted = errors.New("job interrupted by restart")
+
+// store keeps a record of the jobs in a kv database
+//
+// A nil *store does nothing so the methods can be called when the
+// job store isn't enabled.
+type store struct {
+	db *kv.DB
+}
+
+// storeRecord is the record of a job as stored on disk
+type storeRecord struct {
+	Job      *Job      `json:"job"`
+	Path     string    `json:"path,omitempty"`
+	Params   rc.Params `json:"params,omitempty"`
+	Redacted bool      `json:"redacted,omitempty"` // set if Params weren't stored
+}
+
+// sensitiveParamNames are parts of parameter names whose values may
+// be credentials
+var sensitiveParamNames = []string{"pass", "secret", "token", "key", "credential", "auth"}
+
+// storable returns true if a job running the call at path should be
+// stored
+func storable(path string, isAsync bool) bool {
+	if !isAsync {
+		return false
+	}
+	call := rc.Calls.Get(path)
+	return call != nil && call.AuthRequired
+}
+
+// sensitive returns true if the parameters of a job running the call
+// at path may contain credentials
+func sensitive(path string, params rc.Params) bool {
+	if strings.HasPrefix(path, "config/") {
+		return true
+	}
+	if _, found := params["_config"]; found {
+		return true
+	}
+	return sensitiveValue(map[string]any(params))
+}
+
+// sensitiveValue returns true if v contains a connection string with
+// parameters or a key which looks like it names a credential
+func sensitiveValue(v any) bool {
+	switch x := v.(type) {
+	case string:
+		parsed, err := fspath.Parse(x)
+		return err == nil && len(parsed.Config) > 0
+	case rc.Params:
+		return sensitiveValue(map[string]any(x))
+	case map[string]any:
+		for key, value := range x {
+			lowerKey := strings.ToLower(key)
+			for _, name := range sensitiveParamNames {
+				if strings.Contains(lowerKey, name) {
+					return true
+				}
+			}
+			if sensitiveValue(value) {
+				return true
+			}
+		}
+	case []any:
+		return slices.ContainsFunc(x, sensitiveValue)
+	case []rc.Params:
+		return slices.ContainsFunc(x, func(p rc.Params) bool { return sensitiveValue(p) })
+	case []string:
+		return slices.ContainsFunc(x, func(s string) bool { return sensitiveValue(s) })
+	}
+	return false
+}
+
+// storeKey returns the key for the job with ID so the keys sort
+// in job order
+func storeKey(ID int64) []byte {
+	return fmt.Appendf(nil, "%020d", ID)
+}
+
+// put writes the current state of job to the store
+//
+// call without job.mu held
+func (s *store) put(job *Job) {
+	if s == nil || !job.stored {
+		return
+	}
+	job.mu.Lock()
+	ID := job.ID
+	rec := storeRecord{
+		Job:      job,
+		Path:     job.path,
+		Redacted: job.redacted,
+	}
+	if !job.redacted {
+		rec.Params = job.params
+	}
+	data, err := json.Marshal(rec)
+	job.mu.Unlock()
+	if err == nil {
+		err = s.db.Do(true, &kvPutJob{key: storeKey(ID), data: data})
+	}
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to store job %d: %v", ID, err)
+	}
+}
+
+// remove deletes job from the store
+//
+// call without job.mu held
+func (s *store) remove(job *Job) {
+	if s == nil || !job.stored {
+		return
+	}
+	err := s.db.Do(true, &kvRemoveJob{key: storeKey(job.ID)})
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to remove job %d from store: %v", job.ID, err)
+	}
+}
+
+// kvPutJob: write a job record
+type kvPutJob struct {
+	key  []byte
+	data []byte
+}
+
+func (op *kvPutJob) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Put(op.key, op.data)
+}
+
+// kvRemoveJob: remove a job record
+type kvRemoveJob struct {
+	key []byte
+}
+
+func (op *kvRemoveJob) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete(op.key)
+}
+
+// kvLoadJobs: read all the job records
+type kvLoadJobs struct {
+	records []storeRecord
+}
+
+func (op *kvLoadJobs) Do(ctx context.Context, b kv.Bucket) error {
+	return b.ForEach(func(bkey, data []byte) error {
+		var rec storeRecord
+		if err := json.Unmarshal(data, &rec); err != nil || rec.Job == nil {
+			fs.Errorf(nil, "rc: ignoring corrupted job record %q: %v", bkey, err)
+			return nil
+		}
+		op.records = append(op.records, rec)
+		return nil
+	})
+}
+
+// openStore opens the job store, loading the jobs in it and marking
+// any which were running as failed.
+func (jobs *Jobs) openStore(ctx context.Context) error {
+	db, err := kv.Start(ctx, storeFacility, nil)
+	if err != nil {
+		return err
+	}
+	op := &kvLoadJobs{}
+	err = db.Do(false, op)
+	if err != nil && err != kv.ErrEmpty {
+		_ = db.Stop(false)
+		return err
+	}
+	s := &store{db: db}
+	var interrupted []*Job
+	jobs.mu.Lock()
+	for _, rec := range op.records {
+		job := rec.Job
+		job.Stop = func() {}
+		job.jobs = jobs
+		job.path = rec.Path
+		job.params = rec.Params
+		job.stored = true
+		job.redacted = rec.Redacted
+		if job.params == nil && !job.redacted {
+			job.params = rc.Params{}
+		}
+		if !job.Finished {
+			job.EndTime = time.Now()
+			job.Duration = job.EndTime.Sub(job.StartTime).Seconds()
+			job.Error = errInterrupted.Error()
+			job.realErr = errInterrupted
+			job.Success = false
+			job.Finished = true
+			interrupted = append(interrupted, job)
+		}
+		if job.ID > jobID.Load() {
+			jobID.Store(job.ID)
+		}
+		jobs.jobs[job.ID] = job
+	}
+	jobs.store = s
+	jobs.mu.Unlock()
+	for _, job := range interrupted {
+		fs.Logf(nil, "rc: job %d %q was interrupted by a restart", job.ID, job.path)
+		s.put(job)
+	}
+	if len(op.records) > 0 {
+		fs.Infof(nil, "rc: loaded %d jobs from the job store", len(op.records))
+		jobs.kickExpire()
+	}
+	return nil
+}
+
+// OpenStore opens the job store for the global job queue so that jobs
+// are kept on disk and survive a restart.
+//
+// This should be called before any jobs are started.
+func OpenStore(ctx context.Context) error {
+	return running.openStore(ctx)
+}
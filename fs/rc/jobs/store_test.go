@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobStore(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported")
+	}
+	ctx := context.Background()
+
+	// Record a finished, a running and a redacted job
+	jobs := newJobs()
+	require.NoError(t, jobs.openStore(ctx))
+	t.Cleanup(func() {
+		assert.NoError(t, jobs.store.db.Stop(true))
+	})
+	finished, _, err := jobs.NewJob(ctx, noopFn, rc.Params{"_path": "test/auth_required", "_async": true, "a": "potato"})
+	require.NoError(t, err)
+	done := make(chan struct{})
+	finished.OnFinish(func() { close(done) })
+	<-done
+	interrupted, _, err := jobs.NewJob(ctx, longFn, rc.Params{"_path": "test/auth_required", "_async": true, "b": "sausage"})
+	require.NoError(t, err)
+	redacted, _, err := jobs.NewJob(ctx, noopFn, rc.Params{"_path": "test/auth_required", "_async": true, "srcFs": ":s3,secret_access_key=potato:bucket"})
+	require.NoError(t, err)
+	assert.True(t, redacted.redacted)
+
+	// But not sync jobs or calls which don't need authorisation
+	notStored, _, err := jobs.NewJob(ctx, noopFn, rc.Params{"_path": "rc/noop", "_async": true})
+	require.NoError(t, err)
+	assert.False(t, notStored.stored)
+	notStored, _, err = jobs.NewJob(ctx, noopFn, rc.Params{"_path": "test/auth_required"})
+	require.NoError(t, err)
+	assert.False(t, notStored.stored)
+
+	// Load them as if rclone had been restarted
+	jobID.Store(0)
+	restarted := newJobs()
+	require.NoError(t, restarted.openStore(ctx))
+	t.Cleanup(func() {
+		assert.NoError(t, restarted.store.db.Stop(false))
+	})
+	assert.Equal(t, redacted.ID, jobID.Load())
+	assert.Len(t, restarted.IDs(), 3)
+
+	job := restarted.Get(finished.ID)
+	require.NotNil(t, job)
+	assert.True(t, job.Finished)
+	assert.True(t, job.Success)
+	assert.Equal(t, "test/auth_required", job.path)
+	assert.Equal(t, rc.Params{"_async": true, "a": "potato"}, job.params)
+	assert.Equal(t, finished.Group, job.Group)
+	assert.NotNil(t, job.Stop)
+
+	job = restarted.Get(interrupted.ID)
+	require.NotNil(t, job)
+	assert.True(t, job.Finished)
+	assert.False(t, job.Success)
+	assert.Equal(t, errInterrupted.Error(), job.Error)
+	assert.Equal(t, "test/auth_required", job.path)
+	assert.Equal(t, rc.Params{"_async": true, "b": "sausage"}, job.params)
+	job.Stop()
+
+	job = restarted.Get(redacted.ID)
+	require.NotNil(t, job)
+	assert.Equal(t, "test/auth_required", job.path)
+	assert.Nil(t, job.params)
+
+	// Check expired jobs are removed from the store
+	opt := *restarted.opt
+	opt.JobExpireDuration = 0
+	restarted.opt = &opt
+	restarted.Expire()
+	assert.Empty(t, restarted.IDs())
+	op := &kvLoadJobs{}
+	require.NoError(t, restarted.store.db.Do(false, op))
+	assert.Empty(t, op.records)
+}
+
+func TestSensitive(t *testing.T) {
+	for _, test := range []struct {
+		path   string
+		params rc.Params
+		want   bool
+	}{
+		{"sync/copy", rc.Params{"srcFs": "remote:path", "dstFs": "/tmp"}, false},
+		{"sync/copy", rc.Params{"srcFs": ":s3,access_key_id=a,secret_access_key=b:bucket", "dstFs": "/tmp"}, true},
+		{"sync/copy", rc.Params{"srcFs": "remote:path", "_config": rc.Params{"Transfers": 8}}, true},
+		{"sync/copy", rc.Params{"srcFs": "remote:path", "_filter": rc.Params{"IncludeRule": []string{"*.jpg"}}}, false},
+		{"config/create", rc.Params{"name": "remote", "type": "local"}, true},
+		{"operations/copyfile", rc.Params{"opt": map[string]any{"Password": "potato"}}, true},
+		{"job/batch", rc.Params{"inputs": []any{map[string]any{"fs": ":sftp,pass=potato:"}}}, true},
+	} {
+		assert.Equal(t, test.want, sensitive(test.path, test.params), "%s %v", test.path, test.params)
+	}
+}
@@ -83,6 +83,16 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(10 * time.Second),
 	Help:    "Interval to check for expired async jobs",
 	Groups:  "RC",
+}, {
+	Name:    "rc_job_store",
+	Default: false,
+	Help:    "Keep a record of jobs on disk so they survive restarts",
+	Groups:  "RC",
+}, {
+	Name:    "rc_schedule_max_jobs",
+	Default: 0,
+	Help:    "Max number of scheduled jobs to run at once (0 for unlimited)",
+	Groups:  "RC",
 }, {
 	Name:    "metrics_addr",
 	Default: []string{},
@@ -122,6 +132,8 @@ type Options struct {
 	MetricsTemplate     libhttp.TemplateConfig `config:"metrics"`
 	JobExpireDuration   fs.Duration            `config:"rc_job_expire_duration"`
 	JobExpireInterval   fs.Duration            `config:"rc_job_expire_interval"`
+	JobStore            bool                   `config:"rc_job_store"`
+	ScheduleMaxJobs     int                    `config:"rc_schedule_max_jobs"`
 }
 
 // Opt is the default values used for Options
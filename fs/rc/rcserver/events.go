@@ -0,0 +1,261 @@
+package rcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+)
+
+// GET /events streams events to the client as server-sent events so
+// dashboards don't need to poll core/stats and job/status. Each event
+// has the type "stats", "job" or "log" and its data is a JSON object:
+//
+//	stats - as returned by core/stats every interval
+//	job   - as returned by job/status when a job starts or finishes
+//	log   - a log line as output by --use-json-log
+
+// eventsHeartbeat is how often a comment is sent to keep idle
+// connections open
+const eventsHeartbeat = 15 * time.Second
+
+// eventsQueueSize is the number of events queued for each client
+// before events are dropped
+const eventsQueueSize = 256
+
+// streamEvent is an event to send to a client
+type streamEvent struct {
+	kind string
+	data any
+}
+
+// eventsLogSubscriber receives log lines
+type eventsLogSubscriber struct {
+	level  slog.Level
+	events chan streamEvent
+}
+
+var (
+	eventsLogMu          sync.Mutex
+	eventsLogSubscribers = map[*eventsLogSubscriber]struct{}{}
+	eventsAddLogOutput   sync.Once
+)
+
+// eventsLogOutput is called by the logging system with each log line
+// as JSON.
+//
+// This must not log or block.
+func eventsLogOutput(level slog.Level, text string) {
+	eventsLogMu.Lock()
+	defer eventsLogMu.Unlock()
+	for sub := range eventsLogSubscribers {
+		if level < sub.level {
+			continue
+		}
+		select {
+		case sub.events <- streamEvent{kind: "log", data: json.RawMessage(strings.TrimSpace(text))}:
+		default:
+		}
+	}
+}
+
+// subscribeLogs sends log lines at level or above to events until the
+// returned function is called
+func subscribeLogs(level slog.Level, events chan streamEvent) func() {
+	eventsAddLogOutput.Do(func() {
+		log.Handler.AddOutput(true, eventsLogOutput)
+	})
+	sub := &eventsLogSubscriber{level: level, events: events}
+	eventsLogMu.Lock()
+	eventsLogSubscribers[sub] = struct{}{}
+	eventsLogMu.Unlock()
+	return func() {
+		eventsLogMu.Lock()
+		delete(eventsLogSubscribers, sub)
+		eventsLogMu.Unlock()
+	}
+}
+
+// eventsOptions are the query parameters for /events
+type eventsOptions struct {
+	types    map[string]bool // types of event to send
+	group    string          // only send stats and jobs for this group
+	jobID    int64           // only send stats and jobs for this job if > 0
+	interval time.Duration   // interval between stats events
+	level    fs.LogLevel     // minimum level of log events
+}
+
+// parseEventsOptions reads the eventsOptions from the request
+func parseEventsOptions(r *http.Request) (opt eventsOptions, err error) {
+	q := r.URL.Query()
+	opt = eventsOptions{
+		types:    map[string]bool{"stats": true, "job": true, "log": true},
+		group:    q.Get("group"),
+		interval: time.Second,
+		level:    fs.LogLevelInfo,
+	}
+	if types := q.Get("types"); types != "" {
+		opt.types = map[string]bool{}
+		for kind := range strings.SplitSeq(types, ",") {
+			switch kind {
+			case "stats", "job", "log":
+				opt.types[kind] = true
+			default:
+				return opt, fmt.Errorf("unknown event type %q", kind)
+			}
+		}
+	}
+	in := rc.Params{}
+	for _, key := range []string{"jobid", "interval", "level"} {
+		if value := q.Get(key); value != "" {
+			in[key] = value
+		}
+	}
+	opt.jobID, err = in.GetInt64("jobid")
+	if err != nil && !rc.IsErrParamNotFound(err) {
+		return opt, err
+	}
+	if interval, err := in.GetDuration("interval"); err == nil {
+		if interval <= 0 {
+			return opt, errors.New("interval must be positive")
+		}
+		opt.interval = interval
+	} else if !rc.IsErrParamNotFound(err) {
+		return opt, err
+	}
+	if level, err := in.GetString("level"); err == nil {
+		err = opt.level.Set(level)
+		if err != nil {
+			return opt, err
+		}
+	}
+	return opt, nil
+}
+
+// wantJob returns true if the job with status matches the filters
+func (opt *eventsOptions) wantJob(status rc.Params) bool {
+	if opt.group != "" && status["group"] != opt.group {
+		return false
+	}
+	if opt.jobID > 0 {
+		id, err := status.GetInt64("id")
+		if err != nil || id != opt.jobID {
+			return false
+		}
+	}
+	return true
+}
+
+// statsGroup returns the stats group to send
+func (opt *eventsOptions) statsGroup() string {
+	if opt.group == "" && opt.jobID > 0 {
+		return fmt.Sprintf("job/%d", opt.jobID)
+	}
+	return opt.group
+}
+
+// writeEvent writes a server-sent event to w
+func writeEvent(w http.ResponseWriter, kind string, data any) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, buf)
+	if err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// serveEvents streams events to the client until it disconnects
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, path string) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeError(path, nil, w, errors.New("streaming not supported"), http.StatusInternalServerError)
+		return
+	}
+	opt, err := parseEventsOptions(r)
+	if err != nil {
+		writeError(path, nil, w, err, http.StatusBadRequest)
+		return
+	}
+	// Log lines may contain anything so need authorisation like
+	// the calls which require it
+	if opt.types["log"] && !s.opt.NoAuth && !s.server.UsingAuth() {
+		writeError(path, nil, w, errors.New("authentication must be set up on the rc server to stream log events or the --rc-no-auth flag must be in use"), http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+
+	events := make(chan streamEvent, eventsQueueSize)
+	if opt.types["job"] {
+		stop := jobs.OnChange(func(status rc.Params) {
+			if !opt.wantJob(status) {
+				return
+			}
+			select {
+			case events <- streamEvent{kind: "job", data: status}:
+			default:
+				// drop the event if the client isn't keeping up
+			}
+		})
+		defer stop()
+	}
+	if opt.types["log"] {
+		stop := subscribeLogs(fs.LogLevelToSlog(opt.level), events)
+		defer stop()
+	}
+	var statsCall *rc.Call
+	var statsTick <-chan time.Time
+	if opt.types["stats"] {
+		statsCall = rc.Calls.Get("core/stats")
+		if statsCall != nil {
+			ticker := time.NewTicker(opt.interval)
+			defer ticker.Stop()
+			statsTick = ticker.C
+		}
+	}
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	in := rc.Params{}
+	if group := opt.statsGroup(); group != "" {
+		in["group"] = group
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			err = writeEvent(w, event.kind, event.data)
+		case <-statsTick:
+			var stats rc.Params
+			stats, err = statsCall.Fn(ctx, in)
+			if err == nil {
+				err = writeEvent(w, "stats", stats)
+			}
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			w.(http.Flusher).Flush()
+		}
+		if err != nil {
+			fs.Debugf(nil, "rc: %q: stopping events: %v", path, err)
+			return
+		}
+	}
+}
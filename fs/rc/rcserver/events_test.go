@@ -0,0 +1,144 @@
+package rcserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsBadParameters(t *testing.T) {
+	tests := []testRun{{
+		Name:     "bad-type",
+		URL:      "events?types=stats,potato",
+		Status:   http.StatusBadRequest,
+		Contains: regexp.MustCompile(`unknown event type \\"potato\\"`),
+	}, {
+		Name:     "bad-interval",
+		URL:      "events?interval=-1s",
+		Status:   http.StatusBadRequest,
+		Contains: regexp.MustCompile(`interval must be positive`),
+	}, {
+		Name:     "bad-level",
+		URL:      "events?level=potato",
+		Status:   http.StatusBadRequest,
+		Contains: regexp.MustCompile(`invalid choice`),
+	}, {
+		Name:     "bad-jobid",
+		URL:      "events?jobid=potato",
+		Status:   http.StatusBadRequest,
+		Contains: regexp.MustCompile(`jobid`),
+	}}
+	opt := newTestOpt()
+	testServer(t, tests, &opt)
+}
+
+func TestEventsLogNeedsAuth(t *testing.T) {
+	tests := []testRun{{
+		Name:     "log",
+		URL:      "events",
+		Status:   http.StatusForbidden,
+		Contains: regexp.MustCompile(`authentication must be set up`),
+	}, {
+		Name:     "log-only",
+		URL:      "events?types=log",
+		Status:   http.StatusForbidden,
+		Contains: regexp.MustCompile(`authentication must be set up`),
+	}}
+	opt := newTestOpt()
+	opt.NoAuth = false
+	testServer(t, tests, &opt)
+}
+
+func TestEventsStream(t *testing.T) {
+	opt := newTestOpt()
+	opt.NoAuth = true
+	mux := http.NewServeMux()
+	rcServer, err := newServer(context.Background(), &opt, mux)
+	require.NoError(t, err)
+	require.NoError(t, rcServer.Serve())
+	defer func() {
+		assert.NoError(t, rcServer.Shutdown())
+		rcServer.Wait()
+	}()
+	testURL := rcServer.server.URLs()[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", testURL+"events?level=ERROR&interval=50ms", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Run a job which fails and logs an error
+	go func() {
+		resp, err := http.Post(testURL+"rc/error", "application/json", strings.NewReader(`{"potato":1}`))
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	// Read the events until we've seen one of each type
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(resp.Body)
+	timeout := time.AfterFunc(10*time.Second, cancel)
+	defer timeout.Stop()
+	var kind string
+	for len(seen) < 3 && scanner.Scan() {
+		line := scanner.Text()
+		if event, ok := strings.CutPrefix(line, "event: "); ok {
+			kind = event
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var out rc.Params
+		require.NoError(t, json.Unmarshal([]byte(data), &out))
+		switch kind {
+		case "stats":
+			assert.Contains(t, out, "bytes")
+		case "job":
+			assert.Contains(t, out, "group")
+			if out["finished"] == true {
+				assert.Equal(t, false, out["success"])
+			}
+		case "log":
+			assert.Equal(t, "error", out["level"])
+		default:
+			t.Fatalf("unexpected event %q", kind)
+		}
+		seen[kind] = true
+	}
+	assert.Equal(t, map[string]bool{"stats": true, "job": true, "log": true}, seen)
+}
+
+func TestEventsOptions(t *testing.T) {
+	opt := eventsOptions{}
+	assert.True(t, opt.wantJob(rc.Params{"id": float64(1), "group": "job/1"}))
+	assert.Equal(t, "", opt.statsGroup())
+
+	opt.jobID = 2
+	assert.False(t, opt.wantJob(rc.Params{"id": float64(1), "group": "job/1"}))
+	assert.True(t, opt.wantJob(rc.Params{"id": float64(2), "group": "job/2"}))
+	assert.Equal(t, "job/2", opt.statsGroup())
+
+	opt = eventsOptions{group: "potato"}
+	assert.False(t, opt.wantJob(rc.Params{"id": float64(1), "group": "job/1"}))
+	assert.True(t, opt.wantJob(rc.Params{"id": float64(2), "group": "potato"}))
+	assert.Equal(t, "potato", opt.statsGroup())
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/rclone/rclone/fs/list"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/rclone/rclone/fs/rc/schedule"
 	"github.com/rclone/rclone/fs/rc/webgui"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
@@ -35,7 +36,18 @@ import (
 // If the server wasn't configured the *Server returned may be nil
 func Start(ctx context.Context, opt *rc.Options) (*Server, error) {
 	jobs.SetOpt(opt) // set the defaults for jobs
+	schedule.SetOpt(opt)
 	if opt.Enabled {
+		if opt.JobStore {
+			err := jobs.OpenStore(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open rc job store: %w", err)
+			}
+		}
+		err := schedule.Start(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start rc schedules: %w", err)
+		}
 		// Serve on the DefaultServeMux so can have global registrations appear
 		s, err := newServer(ctx, opt, http.DefaultServeMux)
 		if err != nil {
@@ -270,6 +282,9 @@ func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, path string)
 
 	inOrig := in.Copy()
 
+	// Record the path in the job
+	in["_path"] = path
+
 	if call.NeedsRequest {
 		// Add the request to RC
 		in["_request"] = r
@@ -377,6 +392,10 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, path string)
 	case path == "metrics" && s.opt.EnableMetrics:
 		promHandlerFunc(w, r)
 		return
+	case path == "events":
+		// Stream stats, jobs and logs as server-sent events
+		s.serveEvents(w, r, path)
+		return
 	case path == "*" && s.opt.Serve:
 		// Serve /* as the remote listing
 		s.serveRoot(w, r)
@@ -0,0 +1,212 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed cron expression
+//
+// Each field is a bitmask of the values which match.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool          // day of month or week was *
+	every                         time.Duration // set for @every
+}
+
+// cronField describes one of the fields of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	// 7 is Sunday as well as 0
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// cronDescriptors are the shortcuts which can be used instead of the
+// five fields
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression.
+//
+// This is the standard five fields "minute hour day-of-month month
+// day-of-week", each of which may be *, a value, a range a-b, a list
+// separated by commas and have a step /n. Months and days of the week
+// may be given as three letter names. If both day fields are
+// restricted then either may match as in the traditional cron.
+//
+// The descriptors @yearly, @monthly, @weekly, @daily, @hourly and
+// "@every duration" may be used too.
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("bad @every duration: %w", err)
+		}
+		if every < time.Second {
+			return nil, errors.New("@every duration must be at least 1s")
+		}
+		return &cronSpec{every: every}, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := cronDescriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor %q", spec)
+		}
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("expecting %d fields in cron spec but got %d", len(cronFields), len(fields))
+	}
+	var masks [5]uint64
+	for i, field := range fields {
+		mask, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, err
+		}
+		masks[i] = mask
+	}
+	c := &cronSpec{
+		minute:  masks[0],
+		hour:    masks[1],
+		dom:     masks[2],
+		month:   masks[3],
+		dow:     masks[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	// Sunday may be 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseValue parses a single value of the field
+func (f *cronField) parseValue(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", f.name, s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// parse the field into a bitmask of matching values
+func (f *cronField) parse(field string) (mask uint64, err error) {
+	for part := range strings.SplitSeq(field, ",") {
+		rng, stepString, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			step, err = strconv.Atoi(stepString)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q in %s", stepString, f.name)
+			}
+		}
+		var start, end int
+		if rng == "*" {
+			start, end = f.min, f.max
+		} else {
+			startString, endString, isRange := strings.Cut(rng, "-")
+			start, err = f.parseValue(startString)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				end, err = f.parseValue(endString)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = f.max
+			}
+			if end < start {
+				return 0, fmt.Errorf("bad range %q in %s", rng, f.name)
+			}
+		}
+		for i := start; i <= end; i += step {
+			mask |= 1 << uint(i)
+		}
+	}
+	return mask, nil
+}
+
+// matchDay returns true if the day of t matches
+func (c *cronSpec) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time after t which matches the spec or the
+// zero time if there isn't one in the next 5 years.
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	// advance t to next making sure it always moves forwards even
+	// when next is in a daylight saving gap
+	advance := func(next time.Time) {
+		if !next.After(t) {
+			next = t.Add(time.Hour)
+		}
+		t = next
+	}
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			advance(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !c.matchDay(t) {
+			advance(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			advance(time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
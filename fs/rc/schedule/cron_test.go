@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want string
+	}{
+		{"", "expecting 5 fields"},
+		{"* * * *", "expecting 5 fields"},
+		{"* * * * * *", "expecting 5 fields"},
+		{"60 * * * *", "minute 60 out of range 0-59"},
+		{"* 24 * * *", "hour 24 out of range 0-23"},
+		{"* * 0 * *", "day of month 0 out of range 1-31"},
+		{"* * * 13 *", "month 13 out of range 1-12"},
+		{"* * * * 8", "day of week 8 out of range 0-7"},
+		{"potato * * * *", `bad minute "potato"`},
+		{"*/0 * * * *", `bad step "0" in minute`},
+		{"5-1 * * * *", `bad range "5-1" in minute`},
+		{"@potato", `unknown descriptor "@potato"`},
+		{"@every potato", "bad @every duration"},
+		{"@every 1ms", "at least 1s"},
+	} {
+		_, err := parseCron(test.spec)
+		require.Error(t, err, test.spec)
+		assert.Contains(t, err.Error(), test.want, test.spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	start := time.Date(2025, 1, 15, 10, 30, 20, 0, time.UTC)
+	for _, test := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2025, 1, 15, 11, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, 1, 16, 2, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2025, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon", time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sat,sun", time.Date(2025, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 feb-apr *", time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * fri", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"@hourly", time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", time.Date(2025, 1, 15, 12, 0, 20, 0, time.UTC)},
+	} {
+		c, err := parseCron(test.spec)
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.want, c.next(start), test.spec)
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	c, err := parseCron("30 1 * * *")
+	require.NoError(t, err)
+
+	// 01:30 doesn't exist on the day the clocks go forward
+	start := time.Date(2025, 3, 29, 12, 0, 0, 0, loc)
+	next := c.next(start)
+	assert.True(t, next.After(start))
+	assert.Equal(t, 1, next.Hour())
+	assert.Equal(t, 30, next.Minute())
+	assert.Equal(t, 31, next.Day())
+}
@@ -0,0 +1,463 @@
+// Package schedule runs rc calls at times given by cron expressions.
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+)
+
+// scheduleFileName is the name of the file in the config directory
+// the schedules are saved in
+const scheduleFileName = "schedules.json"
+
+// Schedule is an rc call to run at the times given by Spec
+type Schedule struct {
+	Name         string    `json:"name"`
+	Spec         string    `json:"spec"`
+	Input        rc.Params `json:"input"` // parameters for the call including _path
+	AllowOverlap bool      `json:"allowOverlap"`
+
+	cron      *cronSpec
+	path      string      // rc path to call
+	timer     *time.Timer // timer for the next run
+	next      time.Time   // time of the next run
+	lastRun   time.Time   // time of the last run
+	lastJobID int64       // ID of the last job started
+	lastError string      // error from starting the last job
+	running   int         // number of jobs from this schedule running
+	skipped   int         // number of runs skipped
+}
+
+// scheduler runs the schedules
+type scheduler struct {
+	mu        sync.Mutex
+	opt       *rc.Options
+	path      string // file to save the schedules in or "" if not saved
+	loaded    bool   // set if the saved schedules have been loaded
+	schedules map[string]*Schedule
+	running   int // number of scheduled jobs running
+}
+
+var running = newScheduler()
+
+// newScheduler makes a new scheduler
+func newScheduler() *scheduler {
+	return &scheduler{
+		opt:       &rc.Opt,
+		schedules: map[string]*Schedule{},
+	}
+}
+
+// schedulePath returns the file to save the schedules in or "" if
+// the config isn't stored in a file
+func schedulePath() string {
+	configPath := config.GetConfigPath()
+	if configPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(configPath), scheduleFileName)
+}
+
+// SetOpt sets the options when they are known
+func SetOpt(opt *rc.Options) {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	running.opt = opt
+}
+
+// Start loads the saved schedules and starts running them
+func Start(ctx context.Context) error {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	return running.load(schedulePath())
+}
+
+// load the schedules from path if they haven't been loaded already
+//
+// If path is "" then the schedules aren't saved.
+//
+// call with s.mu held
+func (s *scheduler) load(path string) error {
+	if s.loaded {
+		return nil
+	}
+	if path == "" {
+		s.loaded = true
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.loaded, s.path = true, path
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read schedules: %w", err)
+	}
+	var schedules []*Schedule
+	err = json.Unmarshal(data, &schedules)
+	if err != nil {
+		return fmt.Errorf("failed to parse schedules from %q: %w", path, err)
+	}
+	s.loaded, s.path = true, path
+	for _, sc := range schedules {
+		err = s.add(sc)
+		if err != nil {
+			fs.Errorf(nil, "rc: schedule %q: ignoring: %v", sc.Name, err)
+		}
+	}
+	fs.Debugf(nil, "rc: loaded %d schedules from %q", len(s.schedules), path)
+	return nil
+}
+
+// save the schedules if they are being saved
+//
+// call with s.mu held
+func (s *scheduler) save() error {
+	if s.path == "" {
+		return nil
+	}
+	schedules := s.list()
+	data, err := json.MarshalIndent(schedules, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make directory for schedules: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write schedules: %w", err)
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return fmt.Errorf("failed to save schedules: %w", err)
+	}
+	return nil
+}
+
+// list returns the schedules sorted by name
+//
+// call with s.mu held
+func (s *scheduler) list() []*Schedule {
+	schedules := make([]*Schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		schedules = append(schedules, sc)
+	}
+	slices.SortFunc(schedules, func(a, b *Schedule) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return schedules
+}
+
+// add checks sc and starts running it
+//
+// call with s.mu held
+func (s *scheduler) add(sc *Schedule) (err error) {
+	if sc.Name == "" {
+		return errors.New("schedule needs a name")
+	}
+	if _, found := s.schedules[sc.Name]; found {
+		return fmt.Errorf("schedule %q already exists", sc.Name)
+	}
+	sc.cron, err = parseCron(sc.Spec)
+	if err != nil {
+		return fmt.Errorf("bad spec %q: %w", sc.Spec, err)
+	}
+	sc.path, err = sc.Input.GetString("_path")
+	if err != nil {
+		return err
+	}
+	call := rc.Calls.Get(sc.path)
+	if call == nil {
+		return fmt.Errorf("couldn't find path %q", sc.path)
+	}
+	if call.NeedsRequest || call.NeedsResponse {
+		return fmt.Errorf("can't schedule path %q as it needs the request or response", sc.path)
+	}
+	s.schedules[sc.Name] = sc
+	s.startTimer(sc)
+	return nil
+}
+
+// remove stops the schedule called name
+//
+// Any jobs it has started are left running.
+//
+// call with s.mu held
+func (s *scheduler) remove(name string) error {
+	sc, found := s.schedules[name]
+	if !found {
+		return fmt.Errorf("schedule %q not found", name)
+	}
+	if sc.timer != nil {
+		sc.timer.Stop()
+	}
+	delete(s.schedules, name)
+	return nil
+}
+
+// startTimer sets the timer for the next run of sc
+//
+// call with s.mu held
+func (s *scheduler) startTimer(sc *Schedule) {
+	sc.next = sc.cron.next(time.Now())
+	if sc.next.IsZero() {
+		fs.Errorf(nil, "rc: schedule %q: spec %q never runs", sc.Name, sc.Spec)
+		return
+	}
+	sc.timer = time.AfterFunc(time.Until(sc.next), func() {
+		s.run(sc)
+	})
+}
+
+// run starts a job for sc unless the previous one is still running
+// or there are too many scheduled jobs running
+func (s *scheduler) run(sc *Schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.schedules[sc.Name] != sc {
+		// schedule has been removed
+		return
+	}
+	s.startTimer(sc)
+	if !sc.AllowOverlap && sc.running > 0 {
+		sc.skipped++
+		fs.Logf(nil, "rc: schedule %q: skipping run as job %d is still running", sc.Name, sc.lastJobID)
+		return
+	}
+	if s.opt.ScheduleMaxJobs > 0 && s.running >= s.opt.ScheduleMaxJobs {
+		sc.skipped++
+		fs.Logf(nil, "rc: schedule %q: skipping run as %d scheduled jobs are running", sc.Name, s.running)
+		return
+	}
+	sc.lastRun = time.Now()
+	sc.lastError = ""
+	call := rc.Calls.Get(sc.path)
+	if call == nil {
+		sc.lastError = fmt.Sprintf("couldn't find path %q", sc.path)
+		fs.Errorf(nil, "rc: schedule %q: %s", sc.Name, sc.lastError)
+		return
+	}
+	in := sc.Input.Copy()
+	in["_async"] = true
+	job, _, err := jobs.NewJob(context.Background(), call.Fn, in)
+	if err != nil {
+		sc.lastError = err.Error()
+		fs.Errorf(nil, "rc: schedule %q: failed to start job: %v", sc.Name, err)
+		return
+	}
+	fs.Infof(nil, "rc: schedule %q: started job %d", sc.Name, job.ID)
+	sc.lastJobID = job.ID
+	sc.running++
+	s.running++
+	job.OnFinish(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sc.running--
+		s.running--
+	})
+}
+
+// status returns the state of sc as returned by schedule/list
+//
+// call with s.mu held
+func (sc *Schedule) status() rc.Params {
+	out := rc.Params{
+		"name":         sc.Name,
+		"spec":         sc.Spec,
+		"input":        sc.Input,
+		"allowOverlap": sc.AllowOverlap,
+		"running":      sc.running > 0,
+		"skipped":      sc.skipped,
+	}
+	if !sc.next.IsZero() {
+		out["next"] = sc.next
+	}
+	if !sc.lastRun.IsZero() {
+		out["lastRun"] = sc.lastRun
+		out["lastJobId"] = sc.lastJobID
+		out["lastError"] = sc.lastError
+	}
+	return out
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/add",
+		AuthRequired: true, // require auth always since the calls may require it
+		Fn:           rcScheduleAdd,
+		Title:        "Run an rc command on a schedule",
+		Help: strings.ReplaceAll(`
+This adds a schedule which runs an rc command as an async job at the
+times given by a cron expression. The schedules are saved in
+|schedules.json| in the same directory as the config file and are
+started again when the rc server starts.
+
+Parameters:
+
+- name - name of the schedule (string).
+- spec - cron expression saying when to run (string).
+- input - the input to the command with an extra |_path| parameter as used in |job/batch|.
+- allowOverlap - if set then a new job is started even if the previous one is still running (bool, default false).
+
+The spec has the standard five fields "minute hour day-of-month month
+day-of-week". Each may be |*|, a value, a range |a-b|, a list
+separated by commas, and may have a step |/n|. Months and days of the
+week may be three letter names. The descriptors |@yearly|,
+|@monthly|, |@weekly|, |@daily|, |@hourly| and |@every duration| (e.g.
+|@every 90m|) may be used instead. Times are in the local time zone.
+
+Unless |allowOverlap| is set, a run is skipped if the job from the
+previous run is still going. A run is also skipped if
+|--rc-schedule-max-jobs| scheduled jobs are already running.
+
+For example to sync every night at 2am:
+
+|||sh
+rclone rc schedule/add --json '{
+  "name": "nightly",
+  "spec": "0 2 * * *",
+  "input": {
+    "_path": "sync/sync",
+    "srcFs": "/home/user/files",
+    "dstFs": "remote:backup"
+  }
+}'
+|||
+
+Returns:
+
+- next - the time the command will next run.
+`, "|", "`"),
+	})
+}
+
+// Adds a schedule
+func rcScheduleAdd(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	sc := &Schedule{}
+	sc.Name, err = in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	sc.Spec, err = in.GetString("spec")
+	if err != nil {
+		return nil, err
+	}
+	err = in.GetStruct("input", &sc.Input)
+	if err != nil {
+		return nil, err
+	}
+	sc.AllowOverlap, err = in.GetBool("allowOverlap")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	err = running.load(schedulePath())
+	if err != nil {
+		return nil, err
+	}
+	err = running.add(sc)
+	if err != nil {
+		return nil, err
+	}
+	err = running.save()
+	if err != nil {
+		_ = running.remove(sc.Name)
+		return nil, err
+	}
+	out = rc.Params{}
+	if !sc.next.IsZero() {
+		out["next"] = sc.next
+	}
+	return out, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/list",
+		AuthRequired: true, // the inputs may contain credentials
+		Fn:           rcScheduleList,
+		Title:        "List the scheduled rc commands",
+		Help: `Parameters: None.
+
+Results:
+
+- schedules - a list of the schedules sorted by name, each with
+    - name - name of the schedule
+    - spec - cron expression saying when to run
+    - input - the input to the command including _path
+    - allowOverlap - whether runs may overlap
+    - next - time of the next run
+    - running - true if a job started by the schedule is running
+    - skipped - number of runs skipped since rclone started
+    - lastRun - time of the last run if there was one
+    - lastJobId - id of the job started by the last run
+    - lastError - error starting the last run or empty string
+`,
+	})
+}
+
+// Lists the schedules
+func rcScheduleList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	err = running.load(schedulePath())
+	if err != nil {
+		return nil, err
+	}
+	schedules := []rc.Params{}
+	for _, sc := range running.list() {
+		schedules = append(schedules, sc.status())
+	}
+	return rc.Params{"schedules": schedules}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/remove",
+		AuthRequired: true,
+		Fn:           rcScheduleRemove,
+		Title:        "Remove a scheduled rc command",
+		Help: `Parameters:
+
+- name - name of the schedule (string).
+
+Any job the schedule has started is left running.
+`,
+	})
+}
+
+// Removes a schedule
+func rcScheduleRemove(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	err = running.load(schedulePath())
+	if err != nil {
+		return nil, err
+	}
+	err = running.remove(name)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{}, running.save()
+}
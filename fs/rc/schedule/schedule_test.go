@@ -0,0 +1,240 @@
+package schedule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// release is used to finish the test/schedule/block jobs
+var release = make(chan struct{})
+
+func init() {
+	rc.Add(rc.Call{
+		Path: "test/schedule/block",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			<-release
+			return rc.Params{}, nil
+		},
+	})
+	rc.Add(rc.Call{
+		Path:         "test/schedule/needs_request",
+		NeedsRequest: true,
+	})
+}
+
+// newTestScheduler makes a scheduler saving its schedules in a temporary directory
+func newTestScheduler(t *testing.T) (s *scheduler, path string) {
+	path = filepath.Join(t.TempDir(), scheduleFileName)
+	s = newScheduler()
+	require.NoError(t, s.load(path))
+	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for name := range s.schedules {
+			_ = s.remove(name)
+		}
+	})
+	return s, path
+}
+
+// waitFinished waits for the job with ID to finish
+func waitFinished(t *testing.T, ID int64) {
+	done := make(chan struct{})
+	_, err := jobs.OnFinish(ID, func() { close(done) })
+	require.NoError(t, err)
+	<-done
+}
+
+func TestSchedulerAddErrors(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, test := range []struct {
+		sc   Schedule
+		want string
+	}{
+		{Schedule{Spec: "@daily", Input: rc.Params{"_path": "rc/noop"}}, "needs a name"},
+		{Schedule{Name: "a", Spec: "potato", Input: rc.Params{"_path": "rc/noop"}}, `bad spec "potato"`},
+		{Schedule{Name: "a", Spec: "@daily", Input: rc.Params{}}, "Didn't find key"},
+		{Schedule{Name: "a", Spec: "@daily", Input: rc.Params{"_path": "bad/path"}}, `couldn't find path "bad/path"`},
+		{Schedule{Name: "a", Spec: "@daily", Input: rc.Params{"_path": "test/schedule/needs_request"}}, "needs the request"},
+	} {
+		err := s.add(&test.sc)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), test.want)
+	}
+	require.NoError(t, s.add(&Schedule{Name: "a", Spec: "@daily", Input: rc.Params{"_path": "rc/noop"}}))
+	err := s.add(&Schedule{Name: "a", Spec: "@daily", Input: rc.Params{"_path": "rc/noop"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `schedule "a" already exists`)
+	assert.Contains(t, s.remove("b").Error(), `schedule "b" not found`)
+}
+
+func TestSchedulerSaveLoad(t *testing.T) {
+	s, path := newTestScheduler(t)
+	s.mu.Lock()
+	require.NoError(t, s.add(&Schedule{Name: "b", Spec: "@daily", Input: rc.Params{"_path": "rc/noop", "x": "y"}}))
+	require.NoError(t, s.add(&Schedule{Name: "a", Spec: "*/5 * * * *", Input: rc.Params{"_path": "rc/noop"}, AllowOverlap: true}))
+	require.NoError(t, s.save())
+	s.mu.Unlock()
+
+	loaded := newScheduler()
+	loaded.mu.Lock()
+	require.NoError(t, loaded.load(path))
+	schedules := loaded.list()
+	require.Len(t, schedules, 2)
+	assert.Equal(t, "a", schedules[0].Name)
+	assert.Equal(t, "*/5 * * * *", schedules[0].Spec)
+	assert.True(t, schedules[0].AllowOverlap)
+	assert.False(t, schedules[0].next.IsZero())
+	assert.Equal(t, "b", schedules[1].Name)
+	assert.Equal(t, rc.Params{"_path": "rc/noop", "x": "y"}, schedules[1].Input)
+	for _, sc := range schedules {
+		require.NoError(t, loaded.remove(sc.Name))
+	}
+	require.NoError(t, loaded.save())
+	loaded.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	// A corrupted file shouldn't be overwritten
+	require.NoError(t, os.WriteFile(path, []byte("potato"), 0600))
+	corrupted := newScheduler()
+	assert.Error(t, corrupted.load(path))
+	assert.False(t, corrupted.loaded)
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	s.mu.Lock()
+	sc := &Schedule{Name: "block", Spec: "@yearly", Input: rc.Params{"_path": "test/schedule/block"}}
+	require.NoError(t, s.add(sc))
+	s.mu.Unlock()
+
+	// First run starts a job
+	s.run(sc)
+	s.mu.Lock()
+	firstJobID := sc.lastJobID
+	assert.NotZero(t, firstJobID)
+	assert.Equal(t, 1, sc.running)
+	assert.Equal(t, 1, s.running)
+	s.mu.Unlock()
+
+	// Second run is skipped as the first is still running
+	s.run(sc)
+	s.mu.Lock()
+	assert.Equal(t, firstJobID, sc.lastJobID)
+	assert.Equal(t, 1, sc.skipped)
+	s.mu.Unlock()
+
+	// Unless overlap is allowed
+	s.mu.Lock()
+	sc.AllowOverlap = true
+	s.mu.Unlock()
+	s.run(sc)
+	s.mu.Lock()
+	secondJobID := sc.lastJobID
+	assert.NotEqual(t, firstJobID, secondJobID)
+	assert.Equal(t, 2, sc.running)
+	s.mu.Unlock()
+
+	// But not more than the max scheduled jobs
+	opt := *s.opt
+	opt.ScheduleMaxJobs = 2
+	s.mu.Lock()
+	s.opt = &opt
+	s.mu.Unlock()
+	s.run(sc)
+	s.mu.Lock()
+	assert.Equal(t, secondJobID, sc.lastJobID)
+	assert.Equal(t, 2, sc.skipped)
+	s.mu.Unlock()
+
+	// Finish the jobs
+	release <- struct{}{}
+	release <- struct{}{}
+	waitFinished(t, firstJobID)
+	waitFinished(t, secondJobID)
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return sc.running == 0 && s.running == 0
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestRcSchedule(t *testing.T) {
+	oldRunning := running
+	running, _ = newTestScheduler(t)
+	defer func() {
+		running = oldRunning
+	}()
+	ctx := context.Background()
+
+	call := rc.Calls.Get("schedule/add")
+	require.NotNil(t, call)
+	out, err := call.Fn(ctx, rc.Params{
+		"name":  "noop",
+		"spec":  "0 2 * * *",
+		"input": rc.Params{"_path": "rc/noop", "a": "potato"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "next")
+
+	_, err = call.Fn(ctx, rc.Params{
+		"name":  "bad",
+		"spec":  "potato",
+		"input": rc.Params{"_path": "rc/noop"},
+	})
+	require.Error(t, err)
+
+	call = rc.Calls.Get("schedule/list")
+	require.NotNil(t, call)
+	out, err = call.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	schedules := out["schedules"].([]rc.Params)
+	require.Len(t, schedules, 1)
+	assert.Equal(t, "noop", schedules[0]["name"])
+	assert.Equal(t, "0 2 * * *", schedules[0]["spec"])
+	assert.Equal(t, rc.Params{"_path": "rc/noop", "a": "potato"}, schedules[0]["input"])
+	assert.Equal(t, false, schedules[0]["allowOverlap"])
+	assert.Equal(t, false, schedules[0]["running"])
+	assert.NotContains(t, schedules[0], "lastRun")
+
+	// Run it and check the status is updated
+	running.mu.Lock()
+	sc := running.schedules["noop"]
+	running.mu.Unlock()
+	running.run(sc)
+	running.mu.Lock()
+	jobID := sc.lastJobID
+	running.mu.Unlock()
+	waitFinished(t, jobID)
+	out, err = call.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	schedules = out["schedules"].([]rc.Params)
+	require.Len(t, schedules, 1)
+	assert.Equal(t, jobID, schedules[0]["lastJobId"])
+	assert.Equal(t, "", schedules[0]["lastError"])
+
+	call = rc.Calls.Get("schedule/remove")
+	require.NotNil(t, call)
+	_, err = call.Fn(ctx, rc.Params{"name": "noop"})
+	require.NoError(t, err)
+	_, err = call.Fn(ctx, rc.Params{"name": "noop"})
+	require.Error(t, err)
+
+	call = rc.Calls.Get("schedule/list")
+	out, err = call.Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Empty(t, out["schedules"])
+}
@@ -21,3 +21,13 @@ const SetSparseImplemented = false
 func SetSparse(out *os.File) error {
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole actually does anything.
+const PunchHoleImplemented = false
+
+// PunchHole deallocates size bytes at offset in the file leaving a
+// hole which reads as zeroes. The size of the file is unchanged.
+func PunchHole(out *os.File, offset, size int64) error {
+	return ErrPunchHoleUnsupported
+}
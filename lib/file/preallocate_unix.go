@@ -70,3 +70,24 @@ const SetSparseImplemented = false
 func SetSparse(out *os.File) error {
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole actually does anything.
+const PunchHoleImplemented = true
+
+// PunchHole deallocates size bytes at offset in the file leaving a
+// hole which reads as zeroes. The size of the file is unchanged.
+func PunchHole(out *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	for {
+		err := unix.Fallocate(int(out.Fd()), unix.FALLOC_FL_KEEP_SIZE|unix.FALLOC_FL_PUNCH_HOLE, offset, size)
+		if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+			return ErrPunchHoleUnsupported
+		}
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -231,6 +231,27 @@ func (rs Ranges) Intersection(r Range) (newRs Ranges) {
 	return newRs
 }
 
+// Remove removes r from rs, splitting any segments which r is inside
+func (rs *Ranges) Remove(r Range) {
+	if r.IsEmpty() || len(*rs) == 0 {
+		return
+	}
+	var newRs Ranges
+	for _, curr := range *rs {
+		if curr.Intersection(r).IsEmpty() {
+			newRs = append(newRs, curr)
+			continue
+		}
+		if curr.Pos < r.Pos {
+			newRs = append(newRs, Range{Pos: curr.Pos, Size: r.Pos - curr.Pos})
+		}
+		if curr.End() > r.End() {
+			newRs = append(newRs, Range{Pos: r.End(), Size: curr.End() - r.End()})
+		}
+	}
+	*rs = newRs
+}
+
 // Equal returns true if rs == bs
 func (rs Ranges) Equal(bs Ranges) bool {
 	if len(rs) != len(bs) {
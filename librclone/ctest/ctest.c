@@ -86,6 +86,78 @@ void testError() {
     free(out.Output);
 }
 
+// check an RcloneIOResult has no error
+long long checkIO(struct RcloneIOResult r, char *what) {
+    if (r.Error != NULL) {
+        fprintf(stderr, "%s failed: %s\n", what, r.Error);
+        exit(EXIT_FAILURE);
+    }
+    return r.N;
+}
+
+// check the next read from handle returns expected
+void checkRead(long long handle, const char *expected) {
+    char buf[64];
+    long long n = checkIO(RcloneRead(handle, buf, sizeof(buf)), "read");
+    if (n != strlen(expected) || memcmp(buf, expected, n) != 0) {
+        fprintf(stderr, "Wrong read.\nWant: %s\nGot: %.*s\n", expected, (int)n, buf);
+        exit(EXIT_FAILURE);
+    }
+}
+
+// write a file then read parts of it back with the file handles
+void testFileIO() {
+    printf("test file handles\n");
+    const char *data = "hello, world";
+    long long handle = checkIO(RcloneOpenWrite("/tmp", "librclone-ctest.txt", -1), "open for write");
+    checkIO(RcloneWrite(handle, (void *)data, 7), "write");
+    checkIO(RcloneWrite(handle, (void *)(data + 7), strlen(data) - 7), "write");
+    checkIO(RcloneClose(handle), "close");
+
+    // read 3 bytes from offset 7
+    handle = checkIO(RcloneOpenRead("/tmp", "librclone-ctest.txt", 7, 3), "open for read");
+    checkRead(handle, "wor");
+    checkRead(handle, "");
+    // seeking back reads up to the end of the range
+    checkIO(RcloneSeek(handle, 0, 0), "seek");
+    checkRead(handle, "hello, wor");
+    // a negative size is an error
+    char buf[8];
+    struct RcloneIOResult r = RcloneRead(handle, buf, -1);
+    if (r.Error == NULL) {
+        fprintf(stderr, "read with negative size didn't fail\n");
+        exit(EXIT_FAILURE);
+    }
+    RcloneFreeString(r.Error);
+    checkIO(RcloneClose(handle), "close");
+
+    testRPC("operations/deletefile", "{\"fs\": \"/tmp\", \"remote\": \"librclone-ctest.txt\"}");
+}
+
+// check that running a job queues events for it
+void testEvents() {
+    printf("test events\n");
+    RcloneEventsStart(0);
+    struct RcloneRPCResult out = RcloneRPC("rc/noop", "{}");
+    free(out.Output);
+    int finished = 0;
+    char *event;
+    while (!finished && (event = RcloneEventsNext(1000)) != NULL) {
+        printf("event: %s\n", event);
+        finished = strstr(event, "\"type\":\"job\"") != NULL && strstr(event, "\"finished\":true") != NULL;
+        free(event);
+    }
+    if (!finished) {
+        fprintf(stderr, "Didn't get job finished event\n");
+        exit(EXIT_FAILURE);
+    }
+    RcloneEventsStop();
+    if (RcloneEventsNext(0) != NULL) {
+        fprintf(stderr, "Got event after stopping\n");
+        exit(EXIT_FAILURE);
+    }
+}
+
 // copy file using "operations/copyfile" command
 void testCopyFile() {
     printf("test operations/copyfile\n");
@@ -110,6 +182,8 @@ int main(int argc, char** argv) {
 
     testNoOp();
     testError();
+    testFileIO();
+    testEvents();
     /* testCopyFile(); */
     /* testListRemotes(); */
 
@@ -26,10 +26,19 @@ struct RcloneRPCResult {
 	char*	Output;
 	int	Status;
 };
+
+struct RcloneIOResult {
+	long long	N;
+	char*	Error;
+};
 */
 import "C"
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
 	"unsafe"
 
 	"github.com/rclone/rclone/librclone/librclone"
@@ -100,5 +109,177 @@ func RcloneFreeString(str *C.char) {
 	C.free(unsafe.Pointer(str))
 }
 
+// RcloneIOResult is returned from the file handle functions
+//
+//	N is the handle, the number of bytes or the offset depending on the function
+//	Error is NULL on success or an error message on failure
+type RcloneIOResult struct { //nolint:deadcode
+	N     C.longlong
+	Error *C.char
+}
+
+// ioResult makes an RcloneIOResult from n and err
+func ioResult(n int64, err error) (result C.struct_RcloneIOResult) {
+	result.N = C.longlong(n)
+	if err != nil {
+		result.Error = C.CString(err.Error())
+	}
+	return result
+}
+
+// ioBuffer converts the C buffer buf of size bytes into a slice
+func ioBuffer(buf unsafe.Pointer, size C.longlong) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid buffer size %d", size)
+	}
+	if buf == nil && size > 0 {
+		return nil, errors.New("buffer is NULL")
+	}
+	return unsafe.Slice((*byte)(buf), int(size)), nil
+}
+
+// RcloneOpenRead opens the object remote on the remote fs for
+// reading without going through the RPC.
+//
+//	fs is the remote, eg "crypt:" or "/tmp/dir"
+//	remote is the path of the object within fs
+//	offset is where to start reading
+//	length is the number of bytes to read or -1 to read to the end
+//	result.N is the handle to pass to RcloneRead, RcloneSeek and RcloneClose
+//
+// If result.Error is not NULL the caller is responsible for freeing
+// it (see RcloneFreeString).
+//
+//export RcloneOpenRead
+func RcloneOpenRead(fs *C.char, remote *C.char, offset C.longlong, length C.longlong) (result C.struct_RcloneIOResult) {
+	handle, err := librclone.OpenRead(C.GoString(fs), C.GoString(remote), int64(offset), int64(length))
+	return ioResult(handle, err)
+}
+
+// RcloneOpenWrite opens the object remote on the remote fs for
+// writing without going through the RPC, replacing it if it exists.
+//
+//	fs is the remote, eg "crypt:" or "/tmp/dir"
+//	remote is the path of the object within fs
+//	size is the number of bytes which will be written or -1 if not known
+//	result.N is the handle to pass to RcloneWrite and RcloneClose
+//
+// The data is uploaded as it is written. The upload is only complete
+// when RcloneClose returns without an error.
+//
+// If result.Error is not NULL the caller is responsible for freeing
+// it (see RcloneFreeString).
+//
+//export RcloneOpenWrite
+func RcloneOpenWrite(fs *C.char, remote *C.char, size C.longlong) (result C.struct_RcloneIOResult) {
+	handle, err := librclone.OpenWrite(C.GoString(fs), C.GoString(remote), int64(size))
+	return ioResult(handle, err)
+}
+
+// RcloneRead reads up to size bytes from handle into buf.
+//
+//	result.N is the number of bytes read which is 0 at the end of the file
+//
+// If result.Error is not NULL the caller is responsible for freeing
+// it (see RcloneFreeString).
+//
+//export RcloneRead
+func RcloneRead(handle C.longlong, buf unsafe.Pointer, size C.longlong) (result C.struct_RcloneIOResult) {
+	p, err := ioBuffer(buf, size)
+	if err != nil {
+		return ioResult(0, err)
+	}
+	n, err := librclone.Read(int64(handle), p)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return ioResult(int64(n), err)
+}
+
+// RcloneSeek sets the offset of the next RcloneRead on handle.
+//
+//	whence is 0 to seek from the start, 1 from the current offset or 2 from the end
+//	result.N is the new offset
+//
+// If result.Error is not NULL the caller is responsible for freeing
+// it (see RcloneFreeString).
+//
+//export RcloneSeek
+func RcloneSeek(handle C.longlong, offset C.longlong, whence C.int) (result C.struct_RcloneIOResult) {
+	pos, err := librclone.Seek(int64(handle), int64(offset), int(whence))
+	return ioResult(pos, err)
+}
+
+// RcloneWrite writes size bytes from buf to handle.
+//
+//	result.N is the number of bytes written
+//
+// If result.Error is not NULL the caller is responsible for freeing
+// it (see RcloneFreeString).
+//
+//export RcloneWrite
+func RcloneWrite(handle C.longlong, buf unsafe.Pointer, size C.longlong) (result C.struct_RcloneIOResult) {
+	p, err := ioBuffer(buf, size)
+	if err != nil {
+		return ioResult(0, err)
+	}
+	n, err := librclone.Write(int64(handle), p)
+	return ioResult(int64(n), err)
+}
+
+// RcloneClose closes handle. For handles opened with RcloneOpenWrite
+// this waits for the upload to finish.
+//
+// If result.Error is not NULL the caller is responsible for freeing
+// it (see RcloneFreeString).
+//
+//export RcloneClose
+func RcloneClose(handle C.longlong) (result C.struct_RcloneIOResult) {
+	return ioResult(0, librclone.Close(int64(handle)))
+}
+
+// RcloneEventsStart starts queueing events for RcloneEventsNext.
+//
+// The events are job state changes, transfer stats and log lines
+// which the caller would otherwise have to poll for. If
+// statsIntervalMs is > 0 then the transfer stats are queued every
+// statsIntervalMs milliseconds while there are transfers in progress.
+//
+// Calling it again discards any events queued.
+//
+//export RcloneEventsStart
+func RcloneEventsStart(statsIntervalMs C.longlong) {
+	librclone.StartEvents(time.Duration(statsIntervalMs) * time.Millisecond)
+}
+
+// RcloneEventsStop stops queueing events.
+//
+// Any RcloneEventsNext waiting for an event will return.
+//
+//export RcloneEventsStop
+func RcloneEventsStop() {
+	librclone.StopEvents()
+}
+
+// RcloneEventsNext returns the next event waiting up to timeoutMs
+// milliseconds for one to arrive, or forever if timeoutMs is < 0.
+//
+// The event is returned as a string with a serialized JSON object
+// with "type" set to "job", "stats" or "log", "time" and the data in
+// a key named after the type. NULL is returned if there was no event
+// or the events aren't started.
+//
+// Caller is responsible for freeing the memory for the event (see
+// RcloneFreeString).
+//
+//export RcloneEventsNext
+func RcloneEventsNext(timeoutMs C.longlong) *C.char {
+	event, ok := librclone.NextEvent(time.Duration(timeoutMs) * time.Millisecond)
+	if !ok {
+		return nil
+	}
+	return C.CString(event)
+}
+
 // do nothing here - necessary for building into a C library
 func main() {}
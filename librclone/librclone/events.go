@@ -0,0 +1,175 @@
+package librclone
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+)
+
+// The event queue lets the caller receive job state changes, transfer
+// progress and log lines as they happen rather than polling
+// job/status and core/stats.
+//
+// Each event is a JSON object with a "type" of "job", "stats" or
+// "log", the "time" it happened and the data under a key named after
+// the type:
+//
+//	{"type":"job","time":"...","job":{...as returned by job/status...}}
+//	{"type":"stats","time":"...","stats":{...as returned by core/stats...}}
+//	{"type":"log","time":"...","log":{...a JSON log line...}}
+
+// eventQueueSize is the number of events queued before the oldest are
+// dropped
+const eventQueueSize = 1000
+
+var (
+	eventsMu    sync.Mutex
+	eventQueue  chan string // nil if events aren't started
+	eventsStop  func()      // stops the event sources
+	addLogEvent sync.Once
+)
+
+// pushEvent queues an event of type kind with data, dropping the
+// oldest event if the queue is full.
+//
+// This is called from the logging system so it mustn't log.
+func pushEvent(kind string, data any) {
+	buf, err := json.Marshal(map[string]any{
+		"type": kind,
+		"time": time.Now(),
+		kind:   data,
+	})
+	if err != nil {
+		return
+	}
+	event := string(buf)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventQueue == nil {
+		return
+	}
+	for {
+		select {
+		case eventQueue <- event:
+			return
+		default:
+		}
+		select {
+		case <-eventQueue:
+		default:
+		}
+	}
+}
+
+// logEvent is called by the logging system with each log line as JSON
+func logEvent(level slog.Level, text string) {
+	eventsMu.Lock()
+	started := eventQueue != nil
+	eventsMu.Unlock()
+	if started {
+		pushEvent("log", json.RawMessage(strings.TrimSpace(text)))
+	}
+}
+
+// runStats queues a stats event every interval while there are
+// transfers in progress and one more when they finish, until ctx is
+// cancelled
+func runStats(ctx context.Context, interval time.Duration) {
+	call := rc.Calls.Get("core/stats")
+	if call == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	transferring := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		stats, err := call.Fn(ctx, rc.Params{})
+		if err != nil {
+			continue
+		}
+		_, found := stats["transferring"]
+		if found || transferring {
+			pushEvent("stats", stats)
+		}
+		transferring = found
+	}
+}
+
+// StartEvents starts queueing events for NextEvent, replacing any
+// events already queued.
+//
+// If statsInterval is > 0 then the transfer stats are queued at that
+// interval while there are transfers in progress.
+func StartEvents(statsInterval time.Duration) {
+	StopEvents()
+	addLogEvent.Do(func() {
+		log.Handler.AddOutput(true, logEvent)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopJobs := jobs.OnChange(func(status rc.Params) {
+		pushEvent("job", status)
+	})
+	eventsMu.Lock()
+	eventQueue = make(chan string, eventQueueSize)
+	eventsStop = func() {
+		stopJobs()
+		cancel()
+	}
+	eventsMu.Unlock()
+	if statsInterval > 0 {
+		go runStats(ctx, statsInterval)
+	}
+}
+
+// StopEvents stops queueing events and discards any queued.
+//
+// Any NextEvent waiting for an event will return.
+func StopEvents() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventQueue == nil {
+		return
+	}
+	eventsStop()
+	close(eventQueue)
+	eventQueue = nil
+	eventsStop = nil
+}
+
+// NextEvent returns the next event as JSON, waiting up to timeout for
+// one to arrive. If timeout is < 0 then it waits until an event
+// arrives or the events are stopped.
+//
+// It returns false if there was no event.
+func NextEvent(timeout time.Duration) (event string, ok bool) {
+	eventsMu.Lock()
+	queue := eventQueue
+	eventsMu.Unlock()
+	if queue == nil {
+		return "", false
+	}
+	if timeout < 0 {
+		event, ok = <-queue
+		return event, ok
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case event, ok = <-queue:
+		return event, ok
+	case <-timer.C:
+		return "", false
+	}
+}
@@ -0,0 +1,246 @@
+package librclone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/chunkedreader"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// The file handles let the caller stream data to and from objects on
+// a remote without going through the JSON RPC.
+//
+// Handles are identified by positive integers so they can be passed
+// across the C interface. They must be closed with Close when
+// finished with, otherwise they will leak.
+
+// handle is an open file
+type handle interface {
+	io.Closer
+}
+
+var (
+	handlesMu  sync.Mutex
+	handles    = map[int64]handle{}
+	lastHandle int64
+)
+
+// addHandle stores h and returns its handle number
+func addHandle(h handle) int64 {
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	lastHandle++
+	handles[lastHandle] = h
+	return lastHandle
+}
+
+// getHandle returns the handle numbered n
+func getHandle(n int64) (handle, error) {
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	h, found := handles[n]
+	if !found {
+		return nil, fmt.Errorf("invalid handle %d", n)
+	}
+	return h, nil
+}
+
+// readHandle is a file open for reading
+type readHandle struct {
+	mu     sync.Mutex
+	cr     chunkedreader.ChunkedReader
+	offset int64 // current read position
+	end    int64 // end of the range to read or -1 to read to the end
+}
+
+// Read reads up to len(p) bytes stopping at the end of the range
+func (h *readHandle) Read(p []byte) (n int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.end >= 0 {
+		if h.offset >= h.end {
+			return 0, io.EOF
+		}
+		if remaining := h.end - h.offset; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err = h.cr.Read(p)
+	h.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the position of the next Read
+func (h *readHandle) Seek(offset int64, whence int) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if whence == io.SeekCurrent {
+		offset += h.offset
+		whence = io.SeekStart
+	}
+	pos, err := h.cr.Seek(offset, whence)
+	if err != nil {
+		return h.offset, err
+	}
+	h.offset = pos
+	return pos, nil
+}
+
+// Close the handle
+func (h *readHandle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cr.Close()
+}
+
+// writeHandle is a file open for writing
+type writeHandle struct {
+	mu   sync.Mutex
+	pw   *io.PipeWriter
+	done chan error // the result of the upload
+}
+
+// Write sends p to the upload
+func (h *writeHandle) Write(p []byte) (n int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pw.Write(p)
+}
+
+// Close finishes the upload and returns any error from it
+func (h *writeHandle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_ = h.pw.Close()
+	return <-h.done
+}
+
+// OpenRead opens the object remote on the remote fsString for reading.
+//
+// Reading starts at offset. If length is >= 0 then at most length
+// bytes will be read, otherwise the object is read to the end.
+//
+// It returns a handle which should be passed to Read, Seek and Close.
+func OpenRead(fsString, remote string, offset, length int64) (n int64, err error) {
+	ctx := context.Background()
+	f, err := cache.Get(ctx, fsString)
+	if err != nil {
+		return 0, err
+	}
+	o, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return 0, err
+	}
+	opt := &vfscommon.Opt
+	h := &readHandle{
+		cr:     chunkedreader.New(ctx, o, int64(opt.ChunkSize), int64(opt.ChunkSizeLimit), opt.ChunkStreams),
+		offset: offset,
+		end:    -1,
+	}
+	if length >= 0 {
+		h.end = offset + length
+	}
+	_, err = h.cr.RangeSeek(ctx, offset, io.SeekStart, length)
+	if err != nil {
+		_ = h.cr.Close()
+		return 0, err
+	}
+	return addHandle(h), nil
+}
+
+// OpenWrite opens the object remote on the remote fsString for
+// writing, replacing it if it exists.
+//
+// If size is >= 0 then exactly that many bytes must be written,
+// otherwise the size is unknown and the data will be streamed to the
+// remote.
+//
+// It returns a handle which should be passed to Write and Close. The
+// upload is only complete when Close returns without error.
+func OpenWrite(fsString, remote string, size int64) (n int64, err error) {
+	ctx := context.Background()
+	f, err := cache.Get(ctx, fsString)
+	if err != nil {
+		return 0, err
+	}
+	pr, pw := io.Pipe()
+	h := &writeHandle{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		var err error
+		if size >= 0 {
+			_, err = operations.RcatSize(ctx, f, remote, pr, size, time.Now(), nil)
+		} else {
+			_, err = operations.Rcat(ctx, f, remote, pr, time.Now(), nil)
+		}
+		// Make any further writes fail
+		_ = pr.CloseWithError(err)
+		h.done <- err
+	}()
+	return addHandle(h), nil
+}
+
+// Read reads up to len(p) bytes from the handle n into p.
+//
+// It returns io.EOF at the end of the file or range.
+func Read(n int64, p []byte) (int, error) {
+	h, err := getHandle(n)
+	if err != nil {
+		return 0, err
+	}
+	rh, ok := h.(*readHandle)
+	if !ok {
+		return 0, fmt.Errorf("handle %d not open for reading", n)
+	}
+	return rh.Read(p)
+}
+
+// Seek sets the offset of the next Read on the handle n as for
+// io.Seeker and returns the new offset.
+func Seek(n int64, offset int64, whence int) (int64, error) {
+	h, err := getHandle(n)
+	if err != nil {
+		return 0, err
+	}
+	rh, ok := h.(*readHandle)
+	if !ok {
+		return 0, fmt.Errorf("handle %d not open for reading", n)
+	}
+	return rh.Seek(offset, whence)
+}
+
+// Write writes all of p to the handle n.
+func Write(n int64, p []byte) (int, error) {
+	h, err := getHandle(n)
+	if err != nil {
+		return 0, err
+	}
+	wh, ok := h.(*writeHandle)
+	if !ok {
+		return 0, fmt.Errorf("handle %d not open for writing", n)
+	}
+	return wh.Write(p)
+}
+
+// Close closes the handle n.
+//
+// For handles open for writing this waits for the upload to finish
+// and returns any error from it.
+func Close(n int64) error {
+	handlesMu.Lock()
+	h, found := handles[n]
+	delete(handles, n)
+	handlesMu.Unlock()
+	if !found {
+		return fmt.Errorf("invalid handle %d", n)
+	}
+	return h.Close()
+}
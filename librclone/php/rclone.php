@@ -10,6 +10,21 @@ Then call rpc calls on it
 
     $rc->rpc( "config/listremotes", "{}" );
 
+Or receive job, stats and log events as they happen
+
+    $rc->eventsStart( 1000 );
+    $event = $rc->eventsNext( 10000 );
+
+Or stream files to and from remotes
+
+    $h = $rc->openWrite( "crypt:", "file.txt" );
+    $rc->write( $h, "hello" );
+    $rc->closeHandle( $h );
+
+    $h = $rc->openRead( "crypt:", "file.txt" );
+    $data = $rc->read( $h, 1024 );
+    $rc->closeHandle( $h );
+
 When finished, close it
 
     $rc->close();
@@ -31,6 +46,19 @@ class Rclone {
         extern void RcloneFinalize();
         extern struct RcloneRPCResult RcloneRPC(char* method, char* input);
         extern void RcloneFreeString(char* str);
+        struct RcloneIOResult {
+            long long N;
+            char* Error;
+        };
+        extern struct RcloneIOResult RcloneOpenRead(char* fs, char* remote, long long offset, long long length);
+        extern struct RcloneIOResult RcloneOpenWrite(char* fs, char* remote, long long size);
+        extern struct RcloneIOResult RcloneRead(long long handle, char* buf, long long size);
+        extern struct RcloneIOResult RcloneSeek(long long handle, long long offset, int whence);
+        extern struct RcloneIOResult RcloneWrite(long long handle, char* buf, long long size);
+        extern struct RcloneIOResult RcloneClose(long long handle);
+        extern void RcloneEventsStart(long long statsIntervalMs);
+        extern void RcloneEventsStop();
+        extern char* RcloneEventsNext(long long timeoutMs);
         ", $libshared);
         $this->rclone->RcloneInitialize();
     }
@@ -46,6 +74,81 @@ class Rclone {
         return $response;
     }
 
+    // io returns N from an RcloneIOResult throwing an Exception if it has an error
+    private function io( $result ): int
+    {
+        if ( !\FFI::isNull( $result->Error ) ) {
+            $error = \FFI::string( $result->Error );
+            $this->rclone->RcloneFreeString( $result->Error );
+            throw new \Exception( $error );
+        }
+        return $result->N;
+    }
+
+    // openRead opens a file for reading returning its handle
+    public function openRead( $fs, $remote, $offset = 0, $length = -1 ): int
+    {
+        return $this->io( $this->rclone->RcloneOpenRead( $fs, $remote, $offset, $length ) );
+    }
+
+    // openWrite opens a file for writing returning its handle
+    public function openWrite( $fs, $remote, $size = -1 ): int
+    {
+        return $this->io( $this->rclone->RcloneOpenWrite( $fs, $remote, $size ) );
+    }
+
+    // read reads up to $size bytes, returning "" at the end of the file
+    public function read( $handle, $size ): string
+    {
+        $buf = \FFI::new( "char[$size]" );
+        $n = $this->io( $this->rclone->RcloneRead( $handle, $buf, $size ) );
+        return \FFI::string( $buf, $n );
+    }
+
+    // seek sets the offset of the next read returning the new offset
+    public function seek( $handle, $offset, $whence = SEEK_SET ): int
+    {
+        return $this->io( $this->rclone->RcloneSeek( $handle, $offset, $whence ) );
+    }
+
+    // write writes $data returning the number of bytes written
+    public function write( $handle, $data ): int
+    {
+        return $this->io( $this->rclone->RcloneWrite( $handle, $data, strlen( $data ) ) );
+    }
+
+    // closeHandle closes a file, waiting for the upload to finish if writing
+    public function closeHandle( $handle ): void
+    {
+        $this->io( $this->rclone->RcloneClose( $handle ) );
+    }
+
+    // eventsStart starts queueing events, with the transfer stats every
+    // $statsIntervalMs milliseconds if set
+    public function eventsStart( $statsIntervalMs = 0 ): void
+    {
+        $this->rclone->RcloneEventsStart( $statsIntervalMs );
+    }
+
+    // eventsStop stops queueing events
+    public function eventsStop( ): void
+    {
+        $this->rclone->RcloneEventsStop();
+    }
+
+    // eventsNext returns the next event waiting up to $timeoutMs
+    // milliseconds, or forever if negative, returning null if none
+    public function eventsNext( $timeoutMs = -1 ): ?array
+    {
+        $event = $this->rclone->RcloneEventsNext( $timeoutMs );
+        if ( \FFI::isNull( $event ) ) {
+            return null;
+        }
+        $output = json_decode( \FFI::string( $event ), true );
+        $this->rclone->RcloneFreeString( $event );
+        return $output;
+    }
+
     public function close( ): void
     {
         $this->rclone->RcloneFinalize();
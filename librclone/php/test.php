@@ -10,6 +10,7 @@ const FOLDER    = "rcloneTest";
 const FILE      = "testFile.txt";
 
 $rc = new Rclone( __DIR__ . '/librclone.so' );
+$rc->eventsStart();
 
 $response = $rc->rpc( "config/listremotes", "{}" );
 print_r( $response );
@@ -52,4 +53,17 @@ if ( $response['output'] ) {
     }
 }
 
+$handle = $rc->openWrite( REMOTE . FOLDER, "stream.txt" );
+$rc->write( $handle, "Streamed!!!" );
+$rc->closeHandle( $handle );
+$handle = $rc->openRead( REMOTE . FOLDER, "stream.txt", 0, 8 );
+$data = $rc->read( $handle, 1024 );
+$rc->closeHandle( $handle );
+print_r("The stream test seems: " . ( $data == "Streamed" ? "SUCCESS" : "FAIL" ) . "\n");
+
+while ( ( $event = $rc->eventsNext( 0 ) ) !== null ) {
+    print_r( $event['type'] . "\n" );
+}
+$rc->eventsStop();
+
 $rc->close();
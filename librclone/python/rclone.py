@@ -9,12 +9,24 @@ Then call rpc calls on it
 
     rclone.rpc("rc/noop", a=42, b="string", c=[1234])
 
+Or receive job, stats and log events as they happen
+
+    rclone.events_start(stats_interval=1)
+    event = rclone.events_next(timeout=10)
+
+Or stream files to and from remotes
+
+    with rclone.open("crypt:", "file.txt", "w") as f:
+        f.write(b"hello")
+    with rclone.open("crypt:", "file.txt") as f:
+        data = f.read()
+
 When finished, close it
 
     rclone.close()
 """
 
-__all__ = ('Rclone', 'RcloneException')
+__all__ = ('Rclone', 'RcloneException', 'RcloneFile')
 
 import os
 import json
@@ -39,6 +51,13 @@ class RcloneRPCResult(Structure):
     _fields_ = [("Output", RcloneRPCString),
                 ("Status", c_int)]
 
+class RcloneIOResult(Structure):
+    """
+    This is returned from the C API file handle functions
+    """
+    _fields_ = [("N", c_longlong),
+                ("Error", RcloneRPCString)]
+
 class RcloneException(Exception):
     """
     Exception raised from rclone
@@ -54,6 +73,55 @@ class RcloneException(Exception):
         message = self.output.get('error', 'Unknown rclone error')
         super().__init__(message)
 
+class RcloneFile():
+    """
+    A file on a remote opened with Rclone.open
+
+    It supports read, seek, write and close and can be used as a
+    context manager.
+    """
+    def __init__(self, rclone, handle, mode):
+        self.rclone = rclone
+        self.handle = handle
+        self.mode = mode
+    def read(self, size=-1):
+        """
+        Read up to size bytes or to the end of the file if size is negative
+        """
+        chunks = []
+        while size != 0:
+            n = size if 0 < size < 1024*1024 else 1024*1024
+            buf = create_string_buffer(n)
+            got = self.rclone._io(self.rclone.rclone.RcloneRead(self.handle, buf, n))
+            if got == 0:
+                break
+            chunks.append(buf.raw[:got])
+            if size > 0:
+                size -= got
+        return b"".join(chunks)
+    def seek(self, offset, whence=os.SEEK_SET):
+        """
+        Set the offset of the next read and return it
+        """
+        return self.rclone._io(self.rclone.rclone.RcloneSeek(self.handle, offset, whence))
+    def write(self, data):
+        """
+        Write the bytes in data to the file
+        """
+        return self.rclone._io(self.rclone.rclone.RcloneWrite(self.handle, data, len(data)))
+    def close(self):
+        """
+        Close the file. For files opened for writing this waits for
+        the upload to finish.
+        """
+        if self.handle is not None:
+            handle, self.handle = self.handle, None
+            self.rclone._io(self.rclone.rclone.RcloneClose(handle))
+    def __enter__(self):
+        return self
+    def __exit__(self, *args):
+        self.close()
+
 class Rclone():
     """
     Interface to Rclone via librclone.so
@@ -66,6 +134,24 @@ class Rclone():
         self.rclone.RcloneRPC.argtypes = (c_char_p, c_char_p)
         self.rclone.RcloneFreeString.restype = None
         self.rclone.RcloneFreeString.argtypes = (c_char_p,)
+        self.rclone.RcloneOpenRead.restype = RcloneIOResult
+        self.rclone.RcloneOpenRead.argtypes = (c_char_p, c_char_p, c_longlong, c_longlong)
+        self.rclone.RcloneOpenWrite.restype = RcloneIOResult
+        self.rclone.RcloneOpenWrite.argtypes = (c_char_p, c_char_p, c_longlong)
+        self.rclone.RcloneRead.restype = RcloneIOResult
+        self.rclone.RcloneRead.argtypes = (c_longlong, c_void_p, c_longlong)
+        self.rclone.RcloneSeek.restype = RcloneIOResult
+        self.rclone.RcloneSeek.argtypes = (c_longlong, c_longlong, c_int)
+        self.rclone.RcloneWrite.restype = RcloneIOResult
+        self.rclone.RcloneWrite.argtypes = (c_longlong, c_char_p, c_longlong)
+        self.rclone.RcloneClose.restype = RcloneIOResult
+        self.rclone.RcloneClose.argtypes = (c_longlong,)
+        self.rclone.RcloneEventsStart.restype = None
+        self.rclone.RcloneEventsStart.argtypes = (c_longlong,)
+        self.rclone.RcloneEventsStop.restype = None
+        self.rclone.RcloneEventsStop.argtypes = ()
+        self.rclone.RcloneEventsNext.restype = RcloneRPCString
+        self.rclone.RcloneEventsNext.argtypes = (c_longlong,)
         self.rclone.RcloneInitialize.restype = None
         self.rclone.RcloneInitialize.argtypes = ()
         self.rclone.RcloneFinalize.restype = None
@@ -89,6 +175,64 @@ class Rclone():
         if status != 200:
             raise RcloneException(output, status)
         return output
+    def _io(self, result):
+        """
+        Return N from an RcloneIOResult raising an RcloneException if
+        it has an error
+        """
+        if result.Error:
+            error = result.Error.value.decode("utf-8")
+            self.rclone.RcloneFreeString(result.Error)
+            raise RcloneException(dict(error=error), 500)
+        return result.N
+    def open(self, fs, remote, mode="r", offset=0, length=-1, size=-1):
+        """
+        Open the file remote on the remote fs returning an RcloneFile.
+
+        With mode "r" the file is read starting at offset and reading
+        at most length bytes if it isn't negative.
+
+        With mode "w" the file is written, replacing it if it
+        exists. Set size to the number of bytes which will be written
+        if known.
+        """
+        fs = fs.encode("utf-8")
+        remote = remote.encode("utf-8")
+        if mode == "r":
+            handle = self._io(self.rclone.RcloneOpenRead(fs, remote, offset, length))
+        elif mode == "w":
+            handle = self._io(self.rclone.RcloneOpenWrite(fs, remote, size))
+        else:
+            raise ValueError(f"unknown mode {mode!r}")
+        return RcloneFile(self, handle, mode)
+    def events_start(self, stats_interval=0):
+        """
+        Start queueing events for events_next.
+
+        If stats_interval is set then the transfer stats are queued
+        every stats_interval seconds while there are transfers in
+        progress.
+        """
+        self.rclone.RcloneEventsStart(int(stats_interval * 1000))
+    def events_stop(self):
+        """
+        Stop queueing events
+        """
+        self.rclone.RcloneEventsStop()
+    def events_next(self, timeout=None):
+        """
+        Return the next event as a dictionary, waiting up to timeout
+        seconds for one or forever if timeout is None.
+
+        Returns None if there was no event.
+        """
+        timeout_ms = -1 if timeout is None else int(timeout * 1000)
+        event = self.rclone.RcloneEventsNext(timeout_ms)
+        if not event:
+            return None
+        output = json.loads(event.value.decode("utf-8"))
+        self.rclone.RcloneFreeString(event)
+        return output
     def close(self):
         """
         Call to finish with the rclone connection
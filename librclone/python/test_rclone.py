@@ -38,5 +38,46 @@ class TestRclone(unittest.TestCase):
         else:
             raise ValueError("Expecting exception")
 
+    def test_file(self):
+        with self.rclone.open("/tmp", "librclone-test.txt", "w") as f:
+            f.write(b"hello, ")
+            f.write(b"world")
+        try:
+            with self.rclone.open("/tmp", "librclone-test.txt") as f:
+                self.assertEqual(f.read(), b"hello, world")
+                self.assertEqual(f.seek(7), 7)
+                self.assertEqual(f.read(3), b"wor")
+                self.assertEqual(f.seek(-2, os.SEEK_END), 10)
+                self.assertEqual(f.read(), b"ld")
+            with self.rclone.open("/tmp", "librclone-test.txt", offset=2, length=3) as f:
+                self.assertEqual(f.read(), b"llo")
+        finally:
+            self.rclone.rpc("operations/deletefile", fs="/tmp", remote="librclone-test.txt")
+
+    def test_file_error(self):
+        with self.assertRaises(RcloneException) as cm:
+            self.rclone.open("/tmp", "librclone-test-missing.txt")
+        self.assertIn("not found", str(cm.exception))
+
+    def test_events(self):
+        self.rclone.events_start()
+        try:
+            with self.assertRaises(RcloneException):
+                self.rclone.rpc("rc/error", a=42)
+            types = set()
+            while True:
+                event = self.rclone.events_next(timeout=1)
+                if event is None:
+                    break
+                types.add(event["type"])
+                if event["type"] == "job" and event["job"]["finished"]:
+                    self.assertFalse(event["job"]["success"])
+                if event["type"] == "log":
+                    self.assertEqual(event["log"]["level"], "error")
+            self.assertEqual(types, {"job", "log"})
+        finally:
+            self.rclone.events_stop()
+        self.assertIsNone(self.rclone.events_next(timeout=0))
+
 if __name__ == '__main__':
     unittest.main()
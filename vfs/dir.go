@@ -35,6 +35,7 @@ type Dir struct {
 	path    string
 	entry   fs.Directory
 	read    time.Time         // time directory entry last read
+	listed  bool              // set if items holds a listing, even a stale one
 	items   map[string]Node   // directory entries - can be empty but not nil
 	virtual map[string]vState // virtual directory entries - may be nil
 	sys     atomic.Value      // user defined info to be attached here
@@ -245,11 +246,17 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 	d._purgeVirtual()
 
 	// Don't clear directory entries if there are virtual entries in this
-	// directory or any children
+	// directory or any children or the directory is pinned
 	hasVirtual = d.hasVirtual()
-	if !hasVirtual {
+	if d.vfs.pinner.keepListing(d.path) {
+		// Keep the listing of a pinned directory to use if the
+		// remote can't be listed, but read it again when next used
+		d.read = time.Time{}
+		d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	} else if !hasVirtual {
 		d.read = time.Time{}
 		d.items = make(map[string]Node)
+		d.listed = false
 		d.cleanupTimer.Stop()
 	} else {
 		d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
@@ -544,6 +551,12 @@ func (d *Dir) _readDir() error {
 		// We treat directory not found as empty because we
 		// create directories on the fly
 	} else if err != nil {
+		// Carry on using the old listing of a pinned directory if
+		// the remote can't be listed
+		if d.listed && d.vfs.pinner.keepListing(d.path) {
+			fs.Errorf(d.path, "Using old listing of pinned directory as failed to read directory: %v", err)
+			return nil
+		}
 		return err
 	}
 
@@ -582,6 +595,7 @@ func (d *Dir) _readDir() error {
 	}
 
 	d.read = time.Now()
+	d.listed = true
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
 
 	return nil
@@ -769,6 +783,7 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 					dir.read = time.Time{}
 				} else {
 					dir.read = when
+					dir.listed = true
 					dir.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
 				}
 			}
@@ -807,6 +822,7 @@ func (d *Dir) readDirTree() error {
 	}
 	fs.Debugf(d.path, "Reading directory tree done in %s", time.Since(when))
 	d.read = when
+	d.listed = true
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
 	return nil
 }
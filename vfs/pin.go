@@ -0,0 +1,348 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"golang.org/x/sync/errgroup"
+)
+
+// Directories can be pinned in the VFS cache when using
+// --vfs-cache-mode full. The files in pinned directories are
+// downloaded into the cache in the background and are never evicted
+// from it. The directory listings of pinned directories are kept in
+// memory and are used if the remote can't be listed, so the files can
+// be read while the remote is unreachable.
+//
+// The pinned directories are stored with the cache so they persist
+// across restarts.
+
+// pinStatus is the progress of downloading a pinned directory
+type pinStatus struct {
+	Files       int64     `json:"files"`           // number of files in the directory
+	FilesCached int64     `json:"filesCached"`     // number of files fully cached
+	Bytes       int64     `json:"bytes"`           // total size of the files
+	BytesCached int64     `json:"bytesCached"`     // size of the files fully cached
+	Scanning    bool      `json:"scanning"`        // set if the directory is being downloaded
+	Scanned     time.Time `json:"scanned"`         // when the directory was last fully downloaded
+	Error       string    `json:"error,omitempty"` // last error downloading the directory
+}
+
+// pinner downloads the pinned directories in the background
+type pinner struct {
+	vfs  *VFS
+	kick chan struct{}
+
+	mu   sync.Mutex
+	dirs map[string]*pinStatus // pinned directories
+}
+
+// newPinner makes a pinner for the VFS and starts it running until
+// ctx is cancelled
+func newPinner(ctx context.Context, vfs *VFS) *pinner {
+	p := &pinner{
+		vfs:  vfs,
+		kick: make(chan struct{}, 1),
+		dirs: make(map[string]*pinStatus),
+	}
+	dirs, err := vfs.cache.PinnedDirs()
+	if err != nil {
+		fs.Errorf(vfs.f, "vfs pin: %v", err)
+	}
+	for _, dir := range dirs {
+		p.dirs[dir] = &pinStatus{}
+	}
+	go p.run(ctx)
+	return p
+}
+
+// cleanPinPath returns the cleaned version of dir for use as a key
+func cleanPinPath(dir string) string {
+	dir = path.Clean(strings.Trim(dir, "/"))
+	if dir == "." {
+		dir = ""
+	}
+	return dir
+}
+
+// inDir returns true if remote is dir or inside it
+func inDir(remote, dir string) bool {
+	return dir == "" || remote == dir || strings.HasPrefix(remote, dir+"/")
+}
+
+// _isPinned returns true if remote is in a pinned directory
+//
+// Call with mu held
+func (p *pinner) _isPinned(remote string) bool {
+	for dir := range p.dirs {
+		if inDir(remote, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinItem pins the cache item name if it is still in a pinned
+// directory, returning false if it isn't.
+//
+// This holds mu so it can't race with unpin.
+func (p *pinner) pinItem(name string) (pinned bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p._isPinned(name) {
+		return false, nil
+	}
+	return true, p.vfs.cache.SetPinned(name, true)
+}
+
+// keepListing returns true if the listing of the directory dir must
+// be kept because it is pinned or it is the parent of a pinned
+// directory
+func (p *pinner) keepListing(dir string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pinned := range p.dirs {
+		if inDir(dir, pinned) || inDir(pinned, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// _save stores the pinned directories with the cache
+//
+// Call with mu held
+func (p *pinner) _save() error {
+	dirs := make([]string, 0, len(p.dirs))
+	for dir := range p.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return p.vfs.cache.SetPinnedDirs(dirs)
+}
+
+// pin the directory and start downloading it
+func (p *pinner) pin(dir string) error {
+	dir = cleanPinPath(dir)
+	node, err := p.vfs.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !node.IsDir() {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+	p.mu.Lock()
+	if _, found := p.dirs[dir]; !found {
+		p.dirs[dir] = &pinStatus{}
+		err = p._save()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// unpin the directory allowing its files to be evicted from the cache
+func (p *pinner) unpin(dir string) error {
+	dir = cleanPinPath(dir)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, found := p.dirs[dir]; !found {
+		return fmt.Errorf("%q is not pinned", dir)
+	}
+	delete(p.dirs, dir)
+	err := p._save()
+	if err != nil {
+		return err
+	}
+	// Unpin the files unless they are in another pinned
+	// directory. This is done with mu held so a download in
+	// progress can't pin them again afterwards.
+	for _, name := range p.vfs.cache.PinnedItems(dir) {
+		if !p._isPinned(name) {
+			err = p.vfs.cache.SetPinned(name, false)
+			if err != nil {
+				fs.Errorf(name, "vfs pin: failed to unpin: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// status returns the progress of the pinned directories
+func (p *pinner) status() rc.Params {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(rc.Params, len(p.dirs))
+	for dir, status := range p.dirs {
+		out[dir] = *status
+	}
+	return out
+}
+
+// update calls fn to update the status of dir if it is still pinned
+func (p *pinner) update(dir string, fn func(status *pinStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if status := p.dirs[dir]; status != nil {
+		fn(status)
+	}
+}
+
+// run downloads the pinned directories whenever kicked and every
+// --dir-cache-time until ctx is cancelled
+func (p *pinner) run(ctx context.Context) {
+	interval := time.Duration(p.vfs.Opt.DirCacheTime)
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		dirs := make([]string, 0, len(p.dirs))
+		for dir := range p.dirs {
+			dirs = append(dirs, dir)
+		}
+		p.mu.Unlock()
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			if ctx.Err() != nil {
+				return
+			}
+			p.download(ctx, dir)
+		}
+		select {
+		case <-p.kick:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// download reads the listing of the pinned directory dir and makes
+// sure all the files in it are pinned and fully cached
+func (p *pinner) download(ctx context.Context, dir string) {
+	p.update(dir, func(status *pinStatus) {
+		status.Scanning = true
+	})
+	err := p._download(ctx, dir)
+	p.update(dir, func(status *pinStatus) {
+		status.Scanning = false
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Error = ""
+			status.Scanned = time.Now()
+		}
+	})
+	if err != nil {
+		fs.Errorf(dir, "vfs pin: failed to download pinned directory: %v", err)
+	}
+}
+
+func (p *pinner) _download(ctx context.Context, dir string) error {
+	node, err := p.vfs.Stat(dir)
+	if err != nil {
+		return err
+	}
+	d, ok := node.(*Dir)
+	if !ok {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+	err = d.readDirTree()
+	if err != nil {
+		return err
+	}
+	var files []*File
+	d.walk(func(d *Dir) {
+		for _, node := range d.items {
+			if file, ok := node.(*File); ok {
+				files = append(files, file)
+			}
+		}
+	})
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+	p.update(dir, func(status *pinStatus) {
+		status.Files = int64(len(files))
+		status.FilesCached = 0
+		status.Bytes = size
+		status.BytesCached = 0
+	})
+
+	// Download the files with up to --transfers at once
+	seen := make(map[string]struct{}, len(files))
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Transfers)
+	var errMu sync.Mutex
+	var lastErr error
+	for _, file := range files {
+		name := file.CachePath()
+		seen[name] = struct{}{}
+		o := file.getObject()
+		if o == nil {
+			// file is being written
+			continue
+		}
+		g.Go(func() error {
+			if gCtx.Err() != nil {
+				return gCtx.Err()
+			}
+			pinned, err := p.pinItem(name)
+			if err == nil && !pinned {
+				// directory was unpinned
+				return nil
+			}
+			if err == nil {
+				err = p.vfs.cache.Item(name).Download(o)
+			}
+			if err != nil {
+				fs.Errorf(name, "vfs pin: failed to download: %v", err)
+				errMu.Lock()
+				lastErr = err
+				errMu.Unlock()
+				return nil
+			}
+			p.update(dir, func(status *pinStatus) {
+				status.FilesCached++
+				status.BytesCached += o.Size()
+			})
+			return nil
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		return err
+	}
+
+	// Unpin cached files which have been removed from the remote
+	for _, name := range p.vfs.cache.PinnedItems(dir) {
+		if _, found := seen[name]; !found {
+			err = p.vfs.cache.SetPinned(name, false)
+			if err != nil {
+				fs.Errorf(name, "vfs pin: failed to unpin: %v", err)
+			}
+		}
+	}
+	return lastErr
+}
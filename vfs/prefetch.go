@@ -0,0 +1,126 @@
+package vfs
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// If --vfs-prefetch-files is set when using --vfs-cache-mode full then
+// the VFS watches for files in a directory being read from the start
+// one after the other, like the tracks of an album being played. When
+// it sees this it starts downloading the start of the next few files
+// in the directory into the cache so they can be opened without
+// waiting for the remote.
+//
+// The amount downloaded is limited by --vfs-prefetch-size which is
+// shared between the files prefetched.
+
+// prefetcher downloads the start of the files following the ones being
+// read in order
+type prefetcher struct {
+	vfs *VFS
+	ctx context.Context
+
+	mu     sync.Mutex
+	last   map[string]string   // directory path to the leaf of the last file read from the start
+	active map[string]struct{} // cache paths of the files being prefetched
+}
+
+// newPrefetcher makes a prefetcher for the VFS which stops
+// prefetching when ctx is cancelled
+func newPrefetcher(ctx context.Context, vfs *VFS) *prefetcher {
+	return &prefetcher{
+		vfs:    vfs,
+		ctx:    ctx,
+		last:   make(map[string]string),
+		active: make(map[string]struct{}),
+	}
+}
+
+// readStart should be called when file is read from the start.
+//
+// If the last file read from the start in the same directory was the
+// one before file then the following files are prefetched in the
+// background.
+func (p *prefetcher) readStart(file *File) {
+	if p == nil {
+		return
+	}
+	d := file.Dir()
+	name := file.Name()
+	p.mu.Lock()
+	last, found := p.last[d.Path()]
+	p.last[d.Path()] = name
+	p.mu.Unlock()
+	if !found || last == name {
+		return
+	}
+	go p.prefetch(d, last, name)
+}
+
+// prefetch downloads the start of the files after name in d if name
+// follows last
+func (p *prefetcher) prefetch(d *Dir, last, name string) {
+	nodes, err := d.ReadDirAll()
+	if err != nil {
+		fs.Debugf(d, "vfs prefetch: failed to list directory: %v", err)
+		return
+	}
+	var files []*File
+	for _, node := range nodes {
+		if file, ok := node.(*File); ok {
+			files = append(files, file)
+		}
+	}
+	i := slices.IndexFunc(files, func(file *File) bool {
+		return file.Name() == name
+	})
+	if i < 1 || files[i-1].Name() != last {
+		return
+	}
+	next := files[i+1 : min(len(files), i+1+p.vfs.Opt.PrefetchFiles)]
+	budget := int64(p.vfs.Opt.PrefetchSize)
+	for j, file := range next {
+		if p.ctx.Err() != nil {
+			return
+		}
+		// Share what is left of the budget between the remaining
+		// files so small files leave more for the others
+		size := min(file.Size(), budget/int64(len(next)-j))
+		if size <= 0 {
+			continue
+		}
+		budget -= size
+		o := file.getObject()
+		if o == nil {
+			// file is being written
+			continue
+		}
+		p.download(file.CachePath(), o, size)
+	}
+}
+
+// download the first size bytes of o into the cache as name unless
+// it is already being prefetched
+func (p *prefetcher) download(name string, o fs.Object, size int64) {
+	p.mu.Lock()
+	_, found := p.active[name]
+	p.active[name] = struct{}{}
+	p.mu.Unlock()
+	if found {
+		return
+	}
+	defer func() {
+		p.mu.Lock()
+		delete(p.active, name)
+		p.mu.Unlock()
+	}()
+	fs.Debugf(name, "vfs prefetch: downloading first %d bytes", size)
+	err := p.vfs.cache.Item(name).Prefetch(o, size)
+	if err != nil {
+		fs.Errorf(name, "vfs prefetch: failed to download: %v", err)
+	}
+}
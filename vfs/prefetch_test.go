@@ -0,0 +1,63 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetch(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping test on non local remote")
+	}
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.PrefetchFiles = 2
+	opt.PrefetchSize = 12
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		r.WriteObject(ctx, "dir/"+name, "0123456789", t1)
+	}
+	require.NotNil(t, vfs.prefetcher)
+
+	read := func(name string) {
+		b, err := vfs.ReadFile("dir/" + name)
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(b))
+	}
+	cached := func(name string, size int64) bool {
+		return vfs.cache.Item("dir/" + name).HasRange(ranges.Range{Pos: 0, Size: size})
+	}
+
+	// Reading files which aren't next to each other doesn't prefetch
+	read("a")
+	read("c")
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, vfs.cache.Exists("dir/b"))
+	assert.False(t, vfs.cache.Exists("dir/d"))
+	assert.False(t, vfs.cache.Exists("dir/e"))
+
+	// Reading the next file prefetches the start of the two after it
+	// sharing the budget between them
+	read("d")
+	require.Eventually(t, func() bool {
+		return cached("e", 6) && cached("f", 6)
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.False(t, vfs.cache.Exists("dir/b"))
+	assert.False(t, vfs.cache.Exists("dir/g"))
+}
+
+func TestPrefetchDisabled(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.PrefetchFiles = 0
+	_, vfs := newTestVFSOpt(t, &opt)
+	assert.Nil(t, vfs.prefetcher)
+}
@@ -554,3 +554,137 @@ func rcQueueSetExpiry(ctx context.Context, in rc.Params) (out rc.Params, err err
 	err = vfs.cache.QueueSetExpiry(writeback.Handle(id), refTime, time.Duration(float64(time.Second)*expiry))
 	return nil, err
 }
+
+// getPinner gets the VFS and its pinner checking the cache mode
+func getPinner(in rc.Params) (*pinner, error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.pinner == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't pin directories unless using --vfs-cache-mode full"))
+	}
+	return vfs.pinner, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pin",
+		Title: "Pin a directory in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This pins a directory in the VFS cache. This is only possible with
+|--vfs-cache-mode full|.
+
+The files in a pinned directory and its subdirectories are
+downloaded into the cache in the background and are never evicted
+from it. The directory is read again every |--dir-cache-time| and any
+new files are downloaded too.
+
+The directory listings of pinned directories are kept in memory and
+are used if the remote can't be listed, so the files in them can
+still be read if the remote is unreachable.
+
+Pinned directories are stored with the cache so they stay pinned
+when rclone is restarted.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |dir| - the directory to pin - use "" for the root
+
+    rclone rc vfs/pin dir=music/jazz
+
+The files being downloaded show as transfers in |core/stats| and the
+progress of each pinned directory can be read with |vfs/pinned|.
+
+This returns an empty result on success, or an error.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPin,
+	})
+}
+
+func rcPin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	p, err := getPinner(in)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := in.GetString("dir")
+	if err != nil {
+		return nil, err
+	}
+	return nil, p.pin(dir)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/unpin",
+		Title: "Unpin a directory in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This unpins a directory pinned with |vfs/pin| so its files can be
+evicted from the cache again. Files which are also in another pinned
+directory stay pinned.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |dir| - the directory to unpin
+
+    rclone rc vfs/unpin dir=music/jazz
+
+This returns an empty result on success, or an error if the directory
+wasn't pinned.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcUnpin,
+	})
+}
+
+func rcUnpin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	p, err := getPinner(in)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := in.GetString("dir")
+	if err != nil {
+		return nil, err
+	}
+	return nil, p.unpin(dir)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pinned",
+		Title: "List the pinned directories in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This lists the directories pinned with |vfs/pin| and the progress of
+downloading them into the cache.
+
+    {
+        "pinned": {
+            "music/jazz": {
+                "files": 120,              // integer: number of files in the directory
+                "filesCached": 80,         // integer: number of files fully cached
+                "bytes": 1073741824,       // integer: total size of the files
+                "bytesCached": 715827882,  // integer: size of the files fully cached
+                "scanning": true,          // boolean: true if the files are being downloaded
+                "scanned": "2024-01-01T12:00:00Z", // time: when all the files were last downloaded
+                "error": ""                // string: last error downloading the files, if any
+            }
+        }
+    }
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPinned,
+	})
+}
+
+func rcPinned(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	p, err := getPinner(in)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"pinned": p.status(),
+	}, nil
+}
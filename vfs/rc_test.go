@@ -2,11 +2,15 @@ package vfs
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -125,3 +129,166 @@ func TestRcStats(t *testing.T) {
 	assert.Equal(t, 1, out["metadataCache"].(rc.Params)["dirs"])
 	assert.Equal(t, vfs.Opt, out["opt"].(vfscommon.Options))
 }
+
+func TestRcPin(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping test on non local remote")
+	}
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	r.WriteObject(ctx, "dir/file1", "one", t1)
+	r.WriteObject(ctx, "dir/sub/file2", "two2", t1)
+	r.WriteObject(ctx, "other", "other", t1)
+
+	pin := rc.Calls.Get("vfs/pin")
+	unpin := rc.Calls.Get("vfs/unpin")
+	pinned := rc.Calls.Get("vfs/pinned")
+
+	// Pin the directory and wait for it to be downloaded
+	_, err := pin.Fn(ctx, rc.Params{"dir": "/dir/"})
+	require.NoError(t, err)
+	var status pinStatus
+	require.Eventually(t, func() bool {
+		out, err := pinned.Fn(ctx, rc.Params{})
+		require.NoError(t, err)
+		status = out["pinned"].(rc.Params)["dir"].(pinStatus)
+		return !status.Scanned.IsZero()
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "", status.Error)
+	assert.Equal(t, int64(2), status.Files)
+	assert.Equal(t, int64(2), status.FilesCached)
+	assert.Equal(t, int64(7), status.Bytes)
+	assert.Equal(t, int64(7), status.BytesCached)
+
+	// Check the files are pinned and cached
+	for _, name := range []string{"dir/file1", "dir/sub/file2"} {
+		item := vfs.cache.Item(name)
+		assert.True(t, item.IsPinned(), name)
+		size, err := item.GetSize()
+		require.NoError(t, err)
+		assert.True(t, item.HasRange(ranges.Range{Pos: 0, Size: size}), name)
+	}
+	assert.False(t, vfs.cache.Item("other").IsPinned())
+	dirs, err := vfs.cache.PinnedDirs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir"}, dirs)
+
+	// Check the listing of the pinned directory is kept
+	vfs.FlushDirCache()
+	assert.NotNil(t, vfs.root.cachedNode("dir/file1"))
+
+	// Unpin it
+	_, err = unpin.Fn(ctx, rc.Params{"dir": "dir"})
+	require.NoError(t, err)
+	assert.False(t, vfs.cache.Item("dir/file1").IsPinned())
+	assert.False(t, vfs.cache.Item("dir/sub/file2").IsPinned())
+	dirs, err = vfs.cache.PinnedDirs()
+	require.NoError(t, err)
+	assert.Equal(t, []string(nil), dirs)
+
+	_, err = unpin.Fn(ctx, rc.Params{"dir": "dir"})
+	assert.ErrorContains(t, err, "is not pinned")
+	_, err = pin.Fn(ctx, rc.Params{"dir": "other"})
+	assert.ErrorContains(t, err, "is not a directory")
+}
+
+// failListFs is an fs.Fs whose listings can be made to fail
+type failListFs struct {
+	fs.Fs
+	fail atomic.Bool
+}
+
+var errListFailed = errors.New("remote unreachable")
+
+// List the objects and directories in dir into entries
+func (f *failListFs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if f.fail.Load() {
+		return nil, errListFailed
+	}
+	return f.Fs.List(ctx, dir)
+}
+
+// Features returns the optional features of this Fs
+func (f *failListFs) Features() *fs.Features {
+	return (&fs.Features{}).Fill(context.Background(), f)
+}
+
+func TestRcPinOffline(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping test on non local remote")
+	}
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	r.WriteObject(ctx, "dir/file1", "one", t1)
+	r.WriteObject(ctx, "dir/sub/file2", "two2", t1)
+	r.WriteObject(ctx, "other/file3", "three", t1)
+	f := &failListFs{Fs: r.Fremote}
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	vfs := New(f, &opt)
+	t.Cleanup(func() { cleanupVFS(t, vfs) })
+
+	pinned := rc.Calls.Get("vfs/pinned")
+	_, err := rc.Calls.Get("vfs/pin").Fn(ctx, rc.Params{"dir": "dir"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		out, err := pinned.Fn(ctx, rc.Params{})
+		require.NoError(t, err)
+		return !out["pinned"].(rc.Params)["dir"].(pinStatus).Scanned.IsZero()
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err = vfs.ReadDir("other")
+	require.NoError(t, err)
+
+	names := func(dir string) (names []string, err error) {
+		fis, err := vfs.ReadDir(dir)
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return names, err
+	}
+
+	// Forgetting a pinned directory still makes it be read again
+	r.WriteObject(ctx, "dir/file4", "four", t1)
+	vfs.FlushDirCache()
+	got, err := names("dir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file1", "file4", "sub"}, got)
+
+	// As does a change notification
+	r.WriteObject(ctx, "dir/file5", "five", t1)
+	vfs.root.changeNotify("dir/file5", fs.EntryObject)
+	got, err = names("dir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file1", "file4", "file5", "sub"}, got)
+
+	// When the remote can't be listed the pinned directories and
+	// their parents are listed from the kept listings
+	f.fail.Store(true)
+	vfs.root.changeNotify("dir/file5", fs.EntryObject)
+	vfs.root.changeNotify("dir/sub", fs.EntryDirectory)
+	for dir, want := range map[string][]string{
+		"":        {"dir", "other"},
+		"dir":     {"file1", "file4", "file5", "sub"},
+		"dir/sub": {"file2"},
+	} {
+		got, err = names(dir)
+		require.NoError(t, err, dir)
+		assert.Equal(t, want, got, dir)
+	}
+	vfs.FlushDirCache()
+	got, err = names("dir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file1", "file4", "file5", "sub"}, got)
+
+	// But not other directories
+	_, err = names("other")
+	assert.ErrorIs(t, err, errListFailed)
+}
+
+func TestRcPinNeedsCacheModeFull(t *testing.T) {
+	_, _, call := rcNewRun(t, "vfs/pin")
+	_, err := call.Fn(context.Background(), rc.Params{"dir": "dir"})
+	assert.ErrorContains(t, err, "--vfs-cache-mode full")
+}
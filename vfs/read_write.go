@@ -268,6 +268,9 @@ func (fh *RWFileHandle) _readAt(b []byte, off int64, release bool) (n int, err e
 	if err = fh.openPending(); err != nil {
 		return n, err
 	}
+	if off == 0 {
+		fh.d.vfs.prefetcher.readStart(fh.file)
+	}
 	if release {
 		// Do the writing with fh.mu unlocked
 		fh.mu.Unlock()
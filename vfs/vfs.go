@@ -179,6 +179,8 @@ type VFS struct {
 	root        *Dir
 	Opt         vfscommon.Options
 	cache       *vfscache.Cache
+	pinner      *pinner     // pinned directories - only with --vfs-cache-mode full
+	prefetcher  *prefetcher // prefetches following files - only with --vfs-cache-mode full
 	cancel      context.CancelFunc
 	cancelCache context.CancelFunc
 	usageMu     sync.Mutex
@@ -350,6 +352,8 @@ func (vfs *VFS) Fs() fs.Fs {
 func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 	vfs.shutdownCache()
 	vfs.cache = nil
+	vfs.pinner = nil
+	vfs.prefetcher = nil
 	if cacheMode > vfscommon.CacheModeOff {
 		ctx, cancel := context.WithCancel(context.Background())
 		cache, err := vfscache.New(ctx, vfs.f, &vfs.Opt, vfs.AddVirtual) // FIXME pass on context or get from Opt?
@@ -362,6 +366,12 @@ func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 		vfs.Opt.CacheMode = cacheMode
 		vfs.cancelCache = cancel
 		vfs.cache = cache
+		if cacheMode >= vfscommon.CacheModeFull {
+			vfs.pinner = newPinner(ctx, vfs)
+			if vfs.Opt.PrefetchFiles > 0 {
+				vfs.prefetcher = newPrefetcher(ctx, vfs)
+			}
+		}
 	}
 }
 
@@ -0,0 +1,133 @@
+package vfscache
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/rclone/rclone/lib/ranges"
+)
+
+// If --vfs-cache-block-size is set then the cache records when each
+// block of a file was last read so that, when the cache is over
+// quota, the least recently read blocks of all the files can be
+// evicted rather than whole files.
+//
+// The read times are stored in the item metadata as a list of
+// non-overlapping byte ranges, each with the time it was last read.
+// Adjacent ranges read in the same second are merged so a file which
+// is read sequentially only needs a few entries.
+
+// accessTime records when a range of a file was last read
+type accessTime struct {
+	Pos  int64 // start of the range
+	Size int64 // size of the range
+	Time int64 // last read time in unix seconds
+}
+
+// end returns the offset just after the range
+func (a accessTime) end() int64 {
+	return a.Pos + a.Size
+}
+
+// accessTimes is a list of non-overlapping accessTime sorted by Pos
+type accessTimes []accessTime
+
+// search returns the index of the first entry which ends after pos
+func (as accessTimes) search(pos int64) int {
+	return sort.Search(len(as), func(i int) bool {
+		return as[i].end() > pos
+	})
+}
+
+// touch records that r was read at t
+func (as *accessTimes) touch(r ranges.Range, t int64) {
+	if r.IsEmpty() {
+		return
+	}
+	a := *as
+	end := r.End()
+	i := a.search(r.Pos)
+	// Fast path - r is already recorded as read at t
+	if i < len(a) && a[i].Pos <= r.Pos && a[i].end() >= end && a[i].Time == t {
+		return
+	}
+	// Find the entries overlapping r which are a[i:j]
+	j := i
+	for j < len(a) && a[j].Pos < end {
+		j++
+	}
+	// Replace them with r and the parts of them outside r
+	repl := make([]accessTime, 0, 3)
+	k := i // index of r when inserted
+	if i < j && a[i].Pos < r.Pos {
+		repl = append(repl, accessTime{Pos: a[i].Pos, Size: r.Pos - a[i].Pos, Time: a[i].Time})
+		k++
+	}
+	repl = append(repl, accessTime{Pos: r.Pos, Size: r.Size, Time: t})
+	if i < j && a[j-1].end() > end {
+		repl = append(repl, accessTime{Pos: end, Size: a[j-1].end() - end, Time: a[j-1].Time})
+	}
+	a = slices.Replace(a, i, j, repl...)
+	// Merge r with its neighbours if they were read at the same time
+	if k+1 < len(a) && a[k].end() == a[k+1].Pos && a[k+1].Time == t {
+		a[k].Size += a[k+1].Size
+		a = slices.Delete(a, k+1, k+2)
+	}
+	if k > 0 && a[k-1].end() == a[k].Pos && a[k-1].Time == t {
+		a[k-1].Size += a[k].Size
+		a = slices.Delete(a, k, k+1)
+	}
+	*as = a
+}
+
+// remove forgets the read times of r
+func (as *accessTimes) remove(r ranges.Range) {
+	var out accessTimes
+	for _, a := range *as {
+		ar := ranges.Range{Pos: a.Pos, Size: a.Size}
+		if ar.Intersection(r).IsEmpty() {
+			out = append(out, a)
+			continue
+		}
+		if a.Pos < r.Pos {
+			out = append(out, accessTime{Pos: a.Pos, Size: r.Pos - a.Pos, Time: a.Time})
+		}
+		if a.end() > r.End() {
+			out = append(out, accessTime{Pos: r.End(), Size: a.end() - r.End(), Time: a.Time})
+		}
+	}
+	*as = out
+}
+
+// split divides r into ranges with the time each was last read. Parts
+// of r with no read time recorded are given the time defaultTime.
+func (as accessTimes) split(r ranges.Range, defaultTime int64, fn func(r ranges.Range, t int64)) {
+	pos, end := r.Pos, r.End()
+	for i := as.search(pos); i < len(as) && pos < end; i++ {
+		a := as[i]
+		if a.Pos >= end {
+			break
+		}
+		if a.Pos > pos {
+			fn(ranges.Range{Pos: pos, Size: a.Pos - pos}, defaultTime)
+			pos = a.Pos
+		}
+		aEnd := min(a.end(), end)
+		fn(ranges.Range{Pos: pos, Size: aEnd - pos}, a.Time)
+		pos = aEnd
+	}
+	if pos < end {
+		fn(ranges.Range{Pos: pos, Size: end - pos}, defaultTime)
+	}
+}
+
+// blockAlign expands r so it starts and ends on multiples of
+// blockSize, clipping the end to size
+func blockAlign(r ranges.Range, blockSize, size int64) ranges.Range {
+	pos := r.Pos - r.Pos%blockSize
+	end := r.End() + blockSize - 1
+	end -= end % blockSize
+	out := ranges.Range{Pos: pos, Size: end - pos}
+	out.Clip(size)
+	return out
+}
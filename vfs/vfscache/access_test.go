@@ -0,0 +1,143 @@
+package vfscache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessTimesTouch(t *testing.T) {
+	for _, test := range []struct {
+		as   accessTimes
+		r    ranges.Range
+		t    int64
+		want accessTimes
+	}{
+		{
+			as:   nil,
+			r:    ranges.Range{Pos: 0, Size: 0},
+			t:    1,
+			want: nil,
+		},
+		{
+			as:   nil,
+			r:    ranges.Range{Pos: 0, Size: 10},
+			t:    1,
+			want: accessTimes{{0, 10, 1}},
+		},
+		{
+			// already present
+			as:   accessTimes{{0, 20, 1}},
+			r:    ranges.Range{Pos: 5, Size: 10},
+			t:    1,
+			want: accessTimes{{0, 20, 1}},
+		},
+		{
+			// merge with the previous entry
+			as:   accessTimes{{0, 10, 1}},
+			r:    ranges.Range{Pos: 10, Size: 10},
+			t:    1,
+			want: accessTimes{{0, 20, 1}},
+		},
+		{
+			// merge with the next entry
+			as:   accessTimes{{10, 10, 1}},
+			r:    ranges.Range{Pos: 0, Size: 10},
+			t:    1,
+			want: accessTimes{{0, 20, 1}},
+		},
+		{
+			// different time so no merge
+			as:   accessTimes{{0, 10, 1}},
+			r:    ranges.Range{Pos: 10, Size: 10},
+			t:    2,
+			want: accessTimes{{0, 10, 1}, {10, 10, 2}},
+		},
+		{
+			// split an entry
+			as:   accessTimes{{0, 30, 1}},
+			r:    ranges.Range{Pos: 10, Size: 10},
+			t:    2,
+			want: accessTimes{{0, 10, 1}, {10, 10, 2}, {20, 10, 1}},
+		},
+		{
+			// overwrite several entries and merge both sides
+			as:   accessTimes{{0, 10, 2}, {10, 10, 1}, {20, 10, 3}, {30, 10, 2}},
+			r:    ranges.Range{Pos: 10, Size: 20},
+			t:    2,
+			want: accessTimes{{0, 40, 2}},
+		},
+		{
+			// overlap the ends of entries
+			as:   accessTimes{{0, 10, 1}, {20, 10, 3}},
+			r:    ranges.Range{Pos: 5, Size: 20},
+			t:    2,
+			want: accessTimes{{0, 5, 1}, {5, 20, 2}, {25, 5, 3}},
+		},
+		{
+			// in a gap
+			as:   accessTimes{{0, 10, 1}, {40, 10, 1}},
+			r:    ranges.Range{Pos: 20, Size: 10},
+			t:    2,
+			want: accessTimes{{0, 10, 1}, {20, 10, 2}, {40, 10, 1}},
+		},
+	} {
+		what := fmt.Sprintf("as=%v, r=%v, t=%d", test.as, test.r, test.t)
+		got := append(accessTimes(nil), test.as...)
+		got.touch(test.r, test.t)
+		assert.Equal(t, test.want, got, what)
+	}
+}
+
+func TestAccessTimesRemove(t *testing.T) {
+	as := accessTimes{{0, 10, 1}, {10, 10, 2}, {20, 10, 3}}
+	as.remove(ranges.Range{Pos: 5, Size: 20})
+	assert.Equal(t, accessTimes{{0, 5, 1}, {25, 5, 3}}, as)
+	as.remove(ranges.Range{Pos: 0, Size: 100})
+	assert.Equal(t, accessTimes(nil), as)
+}
+
+func TestAccessTimesSplit(t *testing.T) {
+	type split struct {
+		r ranges.Range
+		t int64
+	}
+	as := accessTimes{{10, 10, 1}, {20, 10, 2}, {40, 10, 3}}
+	var got []split
+	as.split(ranges.Range{Pos: 0, Size: 45}, 9, func(r ranges.Range, t int64) {
+		got = append(got, split{r, t})
+	})
+	assert.Equal(t, []split{
+		{ranges.Range{Pos: 0, Size: 10}, 9},
+		{ranges.Range{Pos: 10, Size: 10}, 1},
+		{ranges.Range{Pos: 20, Size: 10}, 2},
+		{ranges.Range{Pos: 30, Size: 10}, 9},
+		{ranges.Range{Pos: 40, Size: 5}, 3},
+	}, got)
+
+	got = nil
+	as.split(ranges.Range{Pos: 25, Size: 10}, 9, func(r ranges.Range, t int64) {
+		got = append(got, split{r, t})
+	})
+	assert.Equal(t, []split{
+		{ranges.Range{Pos: 25, Size: 5}, 2},
+		{ranges.Range{Pos: 30, Size: 5}, 9},
+	}, got)
+}
+
+func TestBlockAlign(t *testing.T) {
+	for _, test := range []struct {
+		r    ranges.Range
+		want ranges.Range
+	}{
+		{ranges.Range{Pos: 0, Size: 1}, ranges.Range{Pos: 0, Size: 10}},
+		{ranges.Range{Pos: 5, Size: 10}, ranges.Range{Pos: 0, Size: 20}},
+		{ranges.Range{Pos: 10, Size: 10}, ranges.Range{Pos: 10, Size: 10}},
+		{ranges.Range{Pos: 35, Size: 1}, ranges.Range{Pos: 30, Size: 5}},
+	} {
+		got := blockAlign(test.r, 10, 35)
+		assert.Equal(t, test.want, got, fmt.Sprintf("r=%v", test.r))
+	}
+}
@@ -3,6 +3,7 @@ package vfscache
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -24,6 +25,7 @@ import (
 	"github.com/rclone/rclone/lib/diskusage"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/lib/systemd"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
@@ -47,6 +49,7 @@ type Cache struct {
 	opt        *vfscommon.Options   // vfs Options
 	root       string               // root of the cache directory
 	metaRoot   string               // root of the cache metadata directory
+	pinPath    string               // file to store the pinned directories in
 	hashType   hash.Type            // hash to use locally and remotely
 	hashOption *fs.HashesOption     // corresponding OpenOption
 	writeback  *writeback.WriteBack // holds Items for writeback
@@ -121,6 +124,7 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		opt:        opt,
 		root:       dataOSPath,
 		metaRoot:   metaOSPath,
+		pinPath:    file.UNCPath(filepath.Join(parentOSPath, "vfsPin", relativeDirOSPath, "pinned.json")),
 		item:       make(map[string]*Item),
 		errItems:   make(map[string]error),
 		hashType:   hashType,
@@ -495,6 +499,75 @@ func (c *Cache) Remove(name string) (wasWriting bool) {
 	return item.remove("file deleted")
 }
 
+// SetPinned sets whether name is pinned in the cache
+//
+// Pinned items are never evicted from the cache.
+//
+// name should be a remote path not an osPath
+func (c *Cache) SetPinned(name string, pinned bool) error {
+	item, _ := c.get(name)
+	return item.SetPinned(pinned)
+}
+
+// PinnedItems returns the names of the pinned items in dir and its
+// subdirectories
+func (c *Cache) PinnedItems(dir string) (names []string) {
+	dir = clean(dir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, item := range c.item {
+		if dir != "" && name != dir && !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+		if item.IsPinned() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PinnedDirs reads the list of pinned directories stored with the
+// cache
+func (c *Cache) PinnedDirs() (dirs []string, err error) {
+	data, err := os.ReadFile(c.pinPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read pinned directories: %w", err)
+	}
+	err = json.Unmarshal(data, &dirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pinned directories: %w", err)
+	}
+	return dirs, nil
+}
+
+// SetPinnedDirs stores the list of pinned directories with the cache
+// so they persist across restarts
+func (c *Cache) SetPinnedDirs(dirs []string) error {
+	if len(dirs) == 0 {
+		err := os.Remove(c.pinPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove pinned directories: %w", err)
+		}
+		return nil
+	}
+	err := createDir(filepath.Dir(c.pinPath))
+	if err != nil {
+		return fmt.Errorf("failed to create pinned directories directory: %w", err)
+	}
+	data, err := json.MarshalIndent(dirs, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode pinned directories: %w", err)
+	}
+	err = os.WriteFile(c.pinPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write pinned directories: %w", err)
+	}
+	return nil
+}
+
 // SetModTime should be called to set the modification time of the cache file
 func (c *Cache) SetModTime(name string, modTime time.Time) {
 	item, _ := c.get(name)
@@ -505,9 +578,13 @@ func (c *Cache) SetModTime(name string, modTime time.Time) {
 func (c *Cache) CleanUp() error {
 	err1 := os.RemoveAll(c.root)
 	err2 := os.RemoveAll(c.metaRoot)
+	err3 := os.Remove(c.pinPath)
 	if err1 != nil {
 		return err1
 	}
+	if err3 != nil && !os.IsNotExist(err3) {
+		return err3
+	}
 	return err2
 }
 
@@ -751,6 +828,69 @@ func (c *Cache) haveQuotas() bool {
 	return c.opt.CacheMaxSize > 0 || c.opt.CacheMinFreeSpace > 0
 }
 
+// evictBlock is a range of a cache file which could be evicted
+type evictBlock struct {
+	item *Item
+	r    ranges.Range
+	t    int64 // when r was last read in unix seconds
+}
+
+// Evict blocks of clean cache files that are not open until the total
+// space is reduced below quota starting from the least recently read
+//
+// This does nothing unless --vfs-cache-block-size is set.
+func (c *Cache) purgeBlocks() {
+	if c.opt.CacheBlockSize <= 0 || !file.PunchHoleImplemented {
+		return
+	}
+	c.updateUsed()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.quotasOK() {
+		return
+	}
+
+	// Make a slice of the blocks which could be evicted
+	var blocks []evictBlock
+	for _, item := range c.item {
+		item.evictable(func(r ranges.Range, t int64) {
+			blocks = append(blocks, evictBlock{item: item, r: r, t: t})
+		})
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].t < blocks[j].t
+	})
+
+	// Evict blocks until the quota is OK
+	for _, block := range blocks {
+		if c.quotasOK() {
+			break
+		}
+		if c.item[block.item.name] != block.item {
+			// item was removed by a previous evict
+			continue
+		}
+		removed, spaceFreed, err := block.item.evict(block.r)
+		c.used -= spaceFreed
+		if err != nil {
+			fs.Errorf(c.fremote, "vfs cache purgeBlocks: failed to evict %v from %s: %v", block.r, block.item.GetName(), err)
+		}
+		if removed {
+			fs.Infof(c.fremote, "vfs cache purgeBlocks: item %s was removed, freed %d bytes", block.item.GetName(), spaceFreed)
+			delete(c.item, block.item.name)
+		} else {
+			fs.Debugf(c.fremote, "vfs cache purgeBlocks: evicted %v from %s, freed %d bytes", block.r, block.item.GetName(), spaceFreed)
+		}
+	}
+	if c.quotasOK() {
+		c.outOfSpace = false
+		c.cond.Broadcast()
+	}
+}
+
 // Remove clean cache files that are not open until the total space
 // is reduced below quota starting from the oldest first
 func (c *Cache) purgeOverQuota() {
@@ -801,6 +941,9 @@ func (c *Cache) clean(kicked bool) {
 
 	// If have a maximum cache size...
 	if c.haveQuotas() {
+		// Evict blocks not in use until cache size is below quota starting from the least recently read
+		c.purgeBlocks()
+
 		// Remove files not in use until cache size is below quota starting from the oldest first
 		c.purgeOverQuota()
 
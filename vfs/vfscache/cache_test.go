@@ -14,6 +14,8 @@ import (
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/diskusage"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
@@ -514,6 +516,112 @@ func TestCachePurgeClean(t *testing.T) {
 	assert.Equal(t, []string(nil), itemAsString(c))
 }
 
+func TestCachePurgeBlocks(t *testing.T) {
+	if !file.PunchHoleImplemented {
+		t.Skip("punching holes in files not supported on this OS")
+	}
+	r, c := newItemTestCache(t)
+	c.opt.CacheBlockSize = 10
+
+	// Read two files into the cache
+	contents, obj, potato := newFile(t, r, c, "potato")
+	_, obj2, potato2 := newFile(t, r, c, "potato2")
+	buf := make([]byte, 100)
+	require.NoError(t, potato.Open(obj))
+	_, err := potato.ReadAt(buf, 0)
+	require.NoError(t, err)
+	require.NoError(t, potato2.Open(obj2))
+	_, err = potato2.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, accessTimes{{0, 100, potato.info.ATime.Unix()}}, potato.info.Accessed)
+
+	// Check nothing evicted while they are open
+	c.opt.CacheMaxSize = 1
+	c.purgeBlocks()
+	assert.Equal(t, int64(200), c.used)
+
+	require.NoError(t, potato.Close(nil))
+	require.NoError(t, potato2.Close(nil))
+
+	// Make the start of potato the oldest and the end the newest
+	potato.info.Accessed = accessTimes{{0, 50, 100}, {50, 50, 300}}
+	potato2.info.Accessed = accessTimes{{0, 100, 200}}
+
+	// Check only the start of potato is evicted
+	c.opt.CacheMaxSize = 150
+	c.purgeBlocks()
+	assert.Equal(t, int64(150), c.used)
+	assert.Equal(t, ranges.Ranges{{Pos: 50, Size: 50}}, potato.info.Rs)
+	assert.Equal(t, ranges.Ranges{{Pos: 0, Size: 100}}, potato2.info.Rs)
+	assert.Equal(t, accessTimes{{50, 50, 300}}, potato.info.Accessed)
+
+	// Check potato2 is evicted next
+	c.opt.CacheMaxSize = 60
+	c.purgeBlocks()
+	assert.Equal(t, int64(50), c.used)
+	assert.Equal(t, ranges.Ranges{{Pos: 50, Size: 50}}, potato.info.Rs)
+	assert.Equal(t, ranges.Ranges(nil), potato2.info.Rs)
+
+	// Check the hole is refilled when potato is read again
+	require.NoError(t, potato.Open(obj))
+	n, err := potato.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(buf[:n]))
+	require.NoError(t, potato.Close(nil))
+	assert.Equal(t, ranges.Ranges{{Pos: 0, Size: 100}}, potato.info.Rs)
+	assert.Equal(t, []string{
+		`name="potato" opens=0 size=100 space=100`,
+		`name="potato2" opens=0 size=100 space=0`,
+	}, itemSpaceAsString(c))
+}
+
+func TestCachePinned(t *testing.T) {
+	_, c := newTestCache(t)
+
+	potato := c.Item("sub/dir/potato")
+	itemWrite(t, potato, "hello")
+	require.NoError(t, c.SetPinned("sub/dir/potato", true))
+	require.NoError(t, potato.Close(nil))
+	assert.True(t, potato.IsPinned())
+
+	potato2 := c.Item("sub/dir2/potato2")
+	itemWrite(t, potato2, "hello2")
+	require.NoError(t, potato2.Close(nil))
+
+	// Check the pinned file survives all the purges
+	c.purgeOld(-10 * time.Second)
+	assert.Equal(t, []string{
+		`name="sub/dir/potato" opens=0 size=5`,
+	}, itemAsString(c))
+
+	c.opt.CacheMaxSize = 1
+	c.purgeOverQuota()
+	c.purgeClean()
+	assert.Equal(t, []string{
+		`name="sub/dir/potato" opens=0 size=5`,
+	}, itemAsString(c))
+
+	// Check the pin is remembered when the item is reloaded
+	c.mu.Lock()
+	delete(c.item, "sub/dir/potato")
+	c.mu.Unlock()
+	potato = c.Item("sub/dir/potato")
+	assert.True(t, potato.IsPinned())
+
+	// Pin a file which isn't in the cache yet
+	require.NoError(t, c.SetPinned("sub/dir3/potato3", true))
+	c.mu.Lock()
+	delete(c.item, "sub/dir3/potato3")
+	c.mu.Unlock()
+	assert.True(t, c.Item("sub/dir3/potato3").IsPinned())
+
+	// Check it can be removed once unpinned
+	require.NoError(t, c.SetPinned("sub/dir/potato", false))
+	require.NoError(t, c.SetPinned("sub/dir3/potato3", false))
+	c.purgeOverQuota()
+	assert.Equal(t, []string(nil), itemAsString(c))
+}
+
 func TestCacheInUse(t *testing.T) {
 	_, c := newTestCache(t)
 
@@ -68,6 +68,7 @@ type Item struct {
 	pendingAccesses int                      // number of threads - cache reset not allowed if not zero
 	modified        bool                     // set if the file has been modified since the last Open
 	beingReset      bool                     // cache cleaner is resetting the cache file, access not allowed
+	saved           time.Time                // when the metadata was last saved
 }
 
 // Info is persisted to backing store
@@ -78,8 +79,15 @@ type Info struct {
 	Rs          ranges.Ranges // which parts of the file are present
 	Fingerprint string        // fingerprint of remote object
 	Dirty       bool          // set if the backing file has been modified
+	Pinned      bool          // set if the file must never be evicted
+	Accessed    accessTimes   // when each block was last read if using block eviction
 }
 
+// metadataSaveInterval is how often the metadata is saved while the
+// downloaders are writing to the cache file so the downloaded ranges
+// are remembered if rclone is stopped without closing the file.
+const metadataSaveInterval = 10 * time.Second
+
 // Items are a slice of *Item ordered by ATime
 type Items []*Item
 
@@ -94,11 +102,12 @@ const (
 	RemovedNotInUse                         // Item not used. Remove instead of reset
 	ResetFailed                             // Reset failed with an error
 	ResetComplete                           // Reset completed successfully
+	SkippedPinned                           // Pinned item cannot be reset
 )
 
 func (rr ResetResult) String() string {
 	return [...]string{"Dirty item skipped", "In-access item skipped", "Empty item skipped",
-		"Not-in-use item removed", "Item reset failed", "Item reset completed"}[rr]
+		"Not-in-use item removed", "Item reset failed", "Item reset completed", "Pinned item skipped"}[rr]
 }
 
 func (v Items) Len() int      { return len(v) }
@@ -118,8 +127,10 @@ func (v Items) Less(i, j int) bool {
 }
 
 // clean the item after its cache file has been deleted
+//
+// The item stays pinned if it was.
 func (info *Info) clean() {
-	*info = Info{}
+	*info = Info{Pinned: info.Pinned}
 	info.ModTime = time.Now()
 	info.ATime = info.ModTime
 }
@@ -161,6 +172,8 @@ func newItem(c *Cache, name string) (item *Item) {
 	// Get size estimate (which is best we can do until Open() called)
 	if statErr == nil {
 		item.info.Size = fi.Size()
+		// The cache file can't hold any ranges past its end
+		item.info.Rs = item.info.Rs.Intersection(ranges.Range{Pos: 0, Size: fi.Size()})
 	}
 	return item
 }
@@ -219,6 +232,7 @@ func (item *Item) _save() (err error) {
 	if err != nil {
 		return fmt.Errorf("vfs cache item: failed to encode metadata: %w", err)
 	}
+	item.saved = time.Now()
 	return nil
 }
 
@@ -353,6 +367,7 @@ func (item *Item) Truncate(size int64) (err error) {
 	} else if size < oldSize {
 		// Truncate shrinks the file so clip the downloaded ranges
 		item.info.Rs = item.info.Rs.Intersection(ranges.Range{Pos: 0, Size: size})
+		item.info.Accessed.remove(ranges.Range{Pos: size, Size: oldSize - size})
 	} else {
 		changed = item.o == nil
 	}
@@ -914,7 +929,7 @@ func (item *Item) RemoveNotInUse(maxAge time.Duration, emptyOnly bool) (removed
 	spaceFreed = 0
 	removed = false
 
-	if item.opens != 0 || item.info.Dirty {
+	if item.opens != 0 || item.info.Dirty || item.info.Pinned {
 		return
 	}
 
@@ -949,6 +964,11 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 	item.mu.Lock()
 	defer item.mu.Unlock()
 
+	// never reset a pinned file
+	if item.info.Pinned {
+		return SkippedPinned, 0, nil
+	}
+
 	// The item is not being used now.  Just remove it instead of resetting it.
 	if item.opens == 0 && !item.info.Dirty {
 		spaceFreed = item.info.Rs.Size()
@@ -1190,6 +1210,140 @@ func (item *Item) _written(offset, size int64) {
 	item.info.Rs.Insert(ranges.Range{Pos: offset, Size: size})
 }
 
+// _touch records that the range at off, size was read for block
+// eviction
+//
+// call with lock held
+func (item *Item) _touch(off, size int64) {
+	blockSize := int64(item.c.opt.CacheBlockSize)
+	if blockSize <= 0 {
+		return
+	}
+	r := blockAlign(ranges.Range{Pos: off, Size: size}, blockSize, item.info.Size)
+	item.info.Accessed.touch(r, item.info.ATime.Unix())
+}
+
+// evictable calls fn with each range of the cache file which may be
+// evicted and the time it was last read
+//
+// Ranges of the file with no read time recorded are given the access
+// time of the file.
+func (item *Item) evictable(fn func(r ranges.Range, t int64)) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.opens != 0 || item.info.Dirty || item.info.Pinned {
+		return
+	}
+	aTime := item.info.ATime.Unix()
+	for _, r := range item.info.Rs {
+		item.info.Accessed.split(r, aTime, fn)
+	}
+}
+
+// evict removes the range r from the cache file by punching a hole
+// in it.
+//
+// If the hole can't be made then the whole cache file is removed
+// instead and removed is set.
+func (item *Item) evict(r ranges.Range) (removed bool, spaceFreed int64, err error) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.opens != 0 || item.info.Dirty || item.info.Pinned {
+		return false, 0, nil
+	}
+	oldSize := item.info.Rs.Size()
+
+	// Save the metadata without the range first so it is never
+	// marked as present when it isn't
+	item.info.Rs.Remove(r)
+	item.info.Accessed.remove(r)
+	err = item._save()
+	if err != nil {
+		return false, 0, err
+	}
+	spaceFreed = oldSize - item.info.Rs.Size()
+
+	osPath := item.c.toOSPath(item.name) // No locking in Cache
+	fd, err := file.OpenFile(osPath, os.O_WRONLY, 0600)
+	if err == nil {
+		err = file.PunchHole(fd, r.Pos, r.Size)
+		closeErr := fd.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fs.Debugf(item.name, "vfs cache: failed to evict %v so removing whole file: %v", r, err)
+		if item._remove("couldn't evict blocks from cache file") {
+			fs.Errorf(item.name, "item removed when it was writing/uploaded")
+		}
+		return true, oldSize, nil
+	}
+	return false, spaceFreed, nil
+}
+
+// Download makes sure all of the item is present in the cache file,
+// fetching any missing parts from o.
+func (item *Item) Download(o fs.Object) (err error) {
+	return item.Prefetch(o, -1)
+}
+
+// Prefetch makes sure the first size bytes of the item are present in
+// the cache file, fetching any missing parts from o. If size is
+// negative or larger than the item then all of it is fetched.
+func (item *Item) Prefetch(o fs.Object, size int64) (err error) {
+	err = item.Open(o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := item.Close(nil)
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	item.preAccess()
+	defer item.postAccess()
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if size < 0 || size > item.info.Size {
+		size = item.info.Size
+	}
+	return item._ensure(0, size)
+}
+
+// IsPinned returns true if the item is pinned in the cache
+func (item *Item) IsPinned() bool {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return item.info.Pinned
+}
+
+// SetPinned sets whether the item is pinned in the cache.
+//
+// Pinned items are never evicted from the cache. The pin is stored
+// in the metadata so it persists across restarts.
+func (item *Item) SetPinned(pinned bool) error {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.info.Pinned == pinned {
+		return nil
+	}
+	item.info.Pinned = pinned
+	// The cache file must exist for the metadata to be loaded again
+	_, err := item.c.createItemDir(item.name) // No locking in Cache
+	if err != nil {
+		return fmt.Errorf("vfs cache item: createItemDir failed: %w", err)
+	}
+	if !item._exists() {
+		err = item._truncate(item.info.Size)
+		if err != nil {
+			return err
+		}
+	}
+	return item._save()
+}
+
 // update the fingerprint of the object if any
 //
 // call with lock held
@@ -1298,6 +1452,7 @@ func (item *Item) readAt(b []byte, off int64) (n int, err error) {
 	}
 
 	item.info.ATime = time.Now()
+	item._touch(off, int64(len(b)))
 	// Do the reading with Item.mu unlocked and cache protected by preAccess
 	n, err = item.fd.ReadAt(b, off)
 	return n, err
@@ -1389,6 +1544,13 @@ func (item *Item) WriteAtNoOverwrite(b []byte, off int64) (n int, skipped int, e
 			break
 		}
 	}
+	// Save the downloaded ranges every so often
+	if time.Since(item.saved) >= metadataSaveInterval {
+		saveErr := item._save()
+		if saveErr != nil {
+			fs.Errorf(item.name, "vfs cache: failed to save item info: %v", saveErr)
+		}
+	}
 	item.mu.Unlock()
 	return n, skipped, err
 }
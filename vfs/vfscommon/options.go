@@ -75,6 +75,11 @@ var OptionsInfo = fs.Options{{
 	Default: fs.SizeSuffix(-1),
 	Help:    "Target minimum free space on the disk containing the cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_cache_block_size",
+	Default: fs.SizeSuffix(0),
+	Help:    "Evict the least recently read blocks of this size from cached files when over quota (0 to evict whole files)",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_read_chunk_size",
 	Default: 128 * fs.Mebi,
@@ -135,6 +140,16 @@ var OptionsInfo = fs.Options{{
 	Default: 0 * fs.Mebi,
 	Help:    "Extra read ahead over --buffer-size when using cache-mode full",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_prefetch_files",
+	Default: 0,
+	Help:    "Number of following files in a directory to start downloading when files are read in order when using cache-mode full (0 to disable)",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_prefetch_size",
+	Default: 64 * fs.Mebi,
+	Help:    "Max bytes to download from the start of the files prefetched by --vfs-prefetch-files",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_used_is_size",
 	Default: false,
@@ -199,6 +214,7 @@ type Options struct {
 	CacheMaxAge        fs.Duration   `config:"vfs_cache_max_age"`
 	CacheMaxSize       fs.SizeSuffix `config:"vfs_cache_max_size"`
 	CacheMinFreeSpace  fs.SizeSuffix `config:"vfs_cache_min_free_space"`
+	CacheBlockSize     fs.SizeSuffix `config:"vfs_cache_block_size"` // if > 0 evict blocks of this size rather than whole files
 	CachePollInterval  fs.Duration   `config:"vfs_cache_poll_interval"`
 	CaseInsensitive    bool          `config:"vfs_case_insensitive"`
 	BlockNormDupes     bool          `config:"vfs_block_norm_dupes"`
@@ -206,6 +222,8 @@ type Options struct {
 	ReadWait           fs.Duration   `config:"vfs_read_wait"`        // time to wait for in-sequence read
 	WriteBack          fs.Duration   `config:"vfs_write_back"`       // time to wait before writing back dirty files
 	ReadAhead          fs.SizeSuffix `config:"vfs_read_ahead"`       // bytes to read ahead in cache mode "full"
+	PrefetchFiles      int           `config:"vfs_prefetch_files"`   // number of following files to prefetch in cache mode "full"
+	PrefetchSize       fs.SizeSuffix `config:"vfs_prefetch_size"`    // max bytes to prefetch from the start of the following files
 	UsedIsSize         bool          `config:"vfs_used_is_size"`     // if true, use the `rclone size` algorithm for Used size
 	FastFingerprint    bool          `config:"vfs_fast_fingerprint"` // if set use fast fingerprints
 	DiskSpaceTotalSize fs.SizeSuffix `config:"vfs_disk_space_total_size"`